@@ -5,34 +5,22 @@ import (
 	logger "github.com/sirupsen/logrus"
 	"os"
 	"os/signal"
+	"sync/atomic"
 	"syscall"
 )
 
-var isPersonShutdown = false
+var isPersonShutdown atomic.Bool
 var personShutdownChan = make(chan struct{})
 
 func InitExitHook() {
 	shutdownChan := make(chan os.Signal, 1)
 	signal.Notify(shutdownChan, os.Interrupt, syscall.SIGTERM)
 	go func() {
-		select {
-		case <-shutdownChan:
-			signal.Stop(shutdownChan)
-			isPersonShutdown = true
-			for _, c := range internal.ExitChanList {
-				internal.ExitWaitGroup.Add(1)
-				c <- struct{}{}
-			}
-			internal.ExitWaitGroup.Wait()
-			close(personShutdownChan)
-		case <-internal.ExitChan:
-			for _, c := range internal.ExitChanList {
-				internal.ExitWaitGroup.Add(1)
-				c <- struct{}{}
-			}
-			internal.ExitWaitGroup.Done()
-			return
-		}
+		<-shutdownChan
+		signal.Stop(shutdownChan)
+		isPersonShutdown.Store(true)
+		internal.DefaultEnv().Close()
+		close(personShutdownChan)
 	}()
 }
 
@@ -41,13 +29,13 @@ func Exit() {
 		logger.Error(r)
 	}
 	// 要是人工点击了关闭，那退出方法就无效了
-	if isPersonShutdown {
-		select {
-		case <-personShutdownChan:
-			return
-		}
+	if isPersonShutdown.Load() {
+		<-personShutdownChan
+		return
 	}
-	internal.ExitWaitGroup.Add(1)
-	internal.ExitChan <- struct{}{}
-	internal.ExitWaitGroup.Wait()
+	// 没有使用过旧用法的全局环境
+	if !initialized.Load() {
+		return
+	}
+	internal.DefaultEnv().Close()
 }
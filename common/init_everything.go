@@ -1,24 +1,25 @@
 package common
 
 import (
-	"encoding/gob"
 	"github.com/hefeiyu2025/pan-client/internal"
 	"github.com/hefeiyu2025/pan-client/pan"
-	"github.com/hefeiyu2025/pan-client/pan/driver/cloudreve"
+	"sync"
+	"sync/atomic"
 )
 
-func init() {
-	internal.InitConfig()
-	internal.InitLog()
-	InitGob()
-	internal.InitCache()
-	internal.InitChunkDownload()
-	InitExitHook()
-}
-func InitGob() {
-	gob.Register(&cloudreve.PolicySummary{})
-	gob.Register([]*pan.PanObj{})
-	gob.Register(&pan.PanObj{})
-	//gob.RegisterName("*cloudreve.UploadCredential", &cloudreve.UploadCredential{})
-	gob.RegisterName("cloudreve.UploadCredential", cloudreve.UploadCredential{})
+var initOnce sync.Once
+var initialized atomic.Bool
+
+// Init 旧用法的初始化：加载全局环境并监听退出信号，重复调用无效
+func Init() {
+	initOnce.Do(func() {
+		env := internal.DefaultEnv()
+		InitExitHook()
+		if env.Config.Server.WatchConfig {
+			if _, err := pan.WatchConfig(); err != nil {
+				env.Logger.WithError(err).Error("watch config fail")
+			}
+		}
+		initialized.Store(true)
+	})
 }
@@ -2,25 +2,211 @@ package pan_client
 
 import (
 	"github.com/hefeiyu2025/pan-client/common"
+	"github.com/hefeiyu2025/pan-client/internal"
 	"github.com/hefeiyu2025/pan-client/pan"
 	_ "github.com/hefeiyu2025/pan-client/pan/driver"
+	"github.com/sirupsen/logrus"
+	"sync"
 )
 
+// Options 库模式的选项，均为空时配置和缓存只保存在内存中
+type Options struct {
+	// 配置文件，支持yaml、json、toml等viper支持的格式，不存在时写配置时创建
+	ConfigFile string
+	// 初始配置，结构与配置文件相同，如 {"driver": {"quark": {...}}}
+	Settings map[string]interface{}
+	// 为空时新建一个logrus.Logger，不会修改全局的logrus
+	Logger *logrus.Logger
+	// 缓存持久化文件，Close时写入
+	CacheFile string
+	// 驱动配置的存储，为空时驱动配置保存在ConfigFile中，见pan.NewDirStore、pan.NewEnvStore等
+	Store pan.ConfigStore
+	// 加密凭证的密钥，为空时从环境变量PAN_CLIENT_SECRET_KEY、PAN_CLIENT_SECRET_KEY_FILE或server.secret_key_file读取
+	SecretKey string
+}
+
+// Client 一套独立的配置、日志、缓存、下载调度和驱动实例，多个Client之间互不影响
+type Client struct {
+	env       *internal.Env
+	registry  *pan.Registry
+	watchMu   sync.Mutex
+	stopWatch func()
+}
+
+// New 库模式，不读取运行目录的配置文件，不修改全局logrus，也不监听退出信号，用完需调用Close
+func New(opts Options) (*Client, error) {
+	env, err := internal.NewEnv(internal.EnvOptions{
+		ConfigFile: opts.ConfigFile,
+		Settings:   opts.Settings,
+		Logger:     opts.Logger,
+		CacheFile:  opts.CacheFile,
+		Store:      opts.Store,
+		SecretKey:  opts.SecretKey,
+	})
+	if err != nil {
+		return nil, err
+	}
+	client := &Client{env: env, registry: pan.NewRegistry(env)}
+	if env.Config.Server.WatchConfig {
+		if err = client.WatchConfig(); err != nil {
+			env.Close()
+			return nil, err
+		}
+	}
+	return client, nil
+}
+
+// WatchConfig 监听配置存储的变化，如手动编辑了配置文件，变化时重新加载已经初始化的实例，Close时停止
+func (c *Client) WatchConfig() error {
+	c.watchMu.Lock()
+	defer c.watchMu.Unlock()
+	if c.stopWatch != nil {
+		return nil
+	}
+	stop, err := c.registry.WatchConfig()
+	if err != nil {
+		return err
+	}
+	c.stopWatch = stop
+	return nil
+}
+
+func (c *Client) GetClient(driverType pan.DriverType) (pan.Driver, error) {
+	return c.registry.GetDriver("", driverType, nil, nil)
+}
+
+func (c *Client) GetClientById(id string, driverType pan.DriverType) (pan.Driver, error) {
+	return c.registry.GetDriver(id, driverType, nil, nil)
+}
+
+func (c *Client) GetClientByRw(id string, driverType pan.DriverType, read pan.ConfigRW, write pan.ConfigRW) (pan.Driver, error) {
+	return c.registry.GetDriver(id, driverType, read, write)
+}
+
+func (c *Client) LookupClient(id string) (pan.Driver, bool) {
+	return c.registry.Lookup(id)
+}
+
+func (c *Client) Clients() []pan.Instance {
+	return c.registry.Instances()
+}
+
+func (c *Client) ClearClientCache(driverType pan.DriverType, id string) int {
+	return c.registry.ClearCache(driverType, id)
+}
+
+func (c *Client) RemoveClient(id string, deleteConfig bool) error {
+	return c.registry.Remove(id, pan.RemoveOptions{DeleteConfig: deleteConfig})
+}
+
+// ConfigSchema 驱动账号配置的JSON Schema，可用于生成配置表单
+func (c *Client) ConfigSchema(driverType pan.DriverType) (map[string]interface{}, error) {
+	return c.registry.ConfigSchema(driverType)
+}
+
+// SetRateLimit 修改该Client所有实例共享的上传下载限速，单位字节/秒，0为不限速
+// 单个实例用Driver.SetRateLimit，单次传输用请求中的RateLimit
+func (c *Client) SetRateLimit(download, upload int64) {
+	c.env.SetRateLimit(download, upload)
+}
+
+// AddProgressListener 该Client所有实例的上传下载进度都会通知listener，返回的remove用于移除
+// 单个文件的进度可以用请求中的Progress监听
+func (c *Client) AddProgressListener(listener pan.ProgressListener) (remove func()) {
+	return c.registry.AddProgressListener(listener)
+}
+
+// Logger 该Client使用的日志
+func (c *Client) Logger() *logrus.Logger {
+	return c.env.Logger
+}
+
+// Close 释放所有实例，等待正在进行的下载结束并保存缓存
+func (c *Client) Close() error {
+	c.watchMu.Lock()
+	if c.stopWatch != nil {
+		c.stopWatch()
+		c.stopWatch = nil
+	}
+	c.watchMu.Unlock()
+	var err error
+	for _, instance := range c.registry.Instances() {
+		if e := c.registry.RemoveDriver(instance.Id); e != nil && err == nil {
+			err = e
+		}
+	}
+	c.env.Close()
+	return err
+}
+
+// RekeyConfig 用newKey重新加密配置文件中的凭证，oldKey为空表示原来是明文，newKey为空表示解密为明文
+func RekeyConfig(configFile, oldKey, newKey string) error {
+	store, err := pan.NewFileStore(configFile)
+	if err != nil {
+		return err
+	}
+	oldCipher, err := optionalCipher(oldKey)
+	if err != nil {
+		return err
+	}
+	newCipher, err := optionalCipher(newKey)
+	if err != nil {
+		return err
+	}
+	return pan.Rekey(store, oldCipher, newCipher)
+}
+
+func optionalCipher(key string) (*pan.Cipher, error) {
+	if key == "" {
+		return nil, nil
+	}
+	return pan.NewCipher(key)
+}
+
 func GracefulExist() {
 	common.Exit()
 }
 func GetClient(driverType pan.DriverType) (pan.Driver, error) {
+	common.Init()
 	return pan.GetDriver("", driverType, nil, nil)
 }
 
 func GetClientById(id string, driverType pan.DriverType) (pan.Driver, error) {
+	common.Init()
 	return pan.GetDriver(id, driverType, nil, nil)
 }
 
 func GetClientByRw(id string, driverType pan.DriverType, read pan.ConfigRW, write pan.ConfigRW) (pan.Driver, error) {
+	common.Init()
 	return pan.GetDriver(id, driverType, read, write)
 }
 
-func RemoveDriver(id string) {
-	pan.RemoveDriver(id)
+// LookupClient 按id获取已经初始化的实例
+func LookupClient(id string) (pan.Driver, bool) {
+	return pan.LookupDriver(id)
+}
+
+// DriverTypes 已注册的驱动类型
+func DriverTypes() []pan.DriverType {
+	return pan.DriverTypes()
+}
+
+// Clients 已经初始化的实例
+func Clients() []pan.Instance {
+	return pan.Instances()
+}
+
+// ClearClientCache 清除某个账号的缓存
+func ClearClientCache(driverType pan.DriverType, id string) int {
+	common.Init()
+	return pan.ClearCache(driverType, id)
+}
+
+func RemoveDriver(id string) error {
+	return pan.RemoveDriver(id)
+}
+
+// RemoveClient 移除账号，deleteConfig为true时同时删除配置文件中该账号的配置
+func RemoveClient(id string, deleteConfig bool) error {
+	return pan.RemoveDriverWithOptions(id, pan.RemoveOptions{DeleteConfig: deleteConfig})
 }
@@ -1,23 +1,42 @@
 package pan_client
 
 import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/hefeiyu2025/pan-client/internal"
 	"github.com/hefeiyu2025/pan-client/pan"
+	"github.com/hefeiyu2025/pan-client/pan/driver/cloudreve"
+	"github.com/hefeiyu2025/pan-client/pan/driver/quark"
 	"github.com/hefeiyu2025/pan-client/pan/driver/thunder_browser"
+	"github.com/imroc/req/v3"
 	logger "github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func TestDownloadAndUpload(t *testing.T) {
 	defer GracefulExist()
+	ctx := context.Background()
 	client, err := GetClient(pan.Cloudreve)
 	if err != nil {
 		t.Error(err)
 		return
 	}
-	err = client.UploadPath(pan.UploadPathReq{
+	err = client.UploadPath(ctx, pan.UploadPathReq{
 		LocalPath:   "./tmpdata",
 		RemotePath:  "/test1",
 		Resumable:   true,
@@ -30,7 +49,7 @@ func TestDownloadAndUpload(t *testing.T) {
 		return
 	}
 
-	list, err := client.List(pan.ListReq{Dir: &pan.PanObj{
+	list, err := client.List(ctx, pan.ListReq{Dir: &pan.PanObj{
 		Path: "/",
 		Name: "test1",
 	}, Reload: true})
@@ -40,7 +59,7 @@ func TestDownloadAndUpload(t *testing.T) {
 	}
 	for _, item := range list {
 		if item.Type == "file" && item.Name == "后浪电影学院039《看不见的剪辑》.pdf" {
-			err = client.DownloadFile(pan.DownloadFileReq{
+			err = client.DownloadFile(ctx, pan.DownloadFileReq{
 				RemoteFile:  item,
 				LocalPath:   "./tmpdata",
 				ChunkSize:   50 * 1024 * 1024,
@@ -54,7 +73,7 @@ func TestDownloadAndUpload(t *testing.T) {
 				t.Error(err)
 				return
 			}
-			err = client.ObjRename(pan.ObjRenameReq{
+			err = client.ObjRename(ctx, pan.ObjRenameReq{
 				Obj:     item,
 				NewName: "1.pdf",
 			})
@@ -62,7 +81,7 @@ func TestDownloadAndUpload(t *testing.T) {
 				t.Error(err)
 				return
 			}
-			err = client.ObjRename(pan.ObjRenameReq{
+			err = client.ObjRename(ctx, pan.ObjRenameReq{
 				Obj:     item,
 				NewName: "后浪电影学院039《看不见的剪辑》.pdf",
 			})
@@ -70,7 +89,7 @@ func TestDownloadAndUpload(t *testing.T) {
 				t.Error(err)
 				return
 			}
-			err = client.Move(pan.MovieReq{
+			err = client.Move(ctx, pan.MovieReq{
 				Items: []*pan.PanObj{item},
 				TargetObj: &pan.PanObj{
 					Name: "test2",
@@ -83,14 +102,14 @@ func TestDownloadAndUpload(t *testing.T) {
 				return
 			}
 
-			err = client.Delete(pan.DeleteReq{
+			err = client.Delete(ctx, pan.DeleteReq{
 				Items: []*pan.PanObj{item},
 			})
 			if err != nil {
 				t.Error(err)
 				return
 			}
-			err = client.UploadPath(pan.UploadPathReq{
+			err = client.UploadPath(ctx, pan.UploadPathReq{
 				LocalPath:   "./tmpdata",
 				RemotePath:  "/test1",
 				Resumable:   true,
@@ -108,13 +127,14 @@ func TestDownloadAndUpload(t *testing.T) {
 
 func TestDownload(t *testing.T) {
 	defer GracefulExist()
+	ctx := context.Background()
 	client, err := GetClient(pan.Quark)
 	if err != nil {
 		t.Error(err)
 		return
 	}
 
-	err = client.DownloadPath(pan.DownloadPathReq{
+	err = client.DownloadPath(ctx, pan.DownloadPathReq{
 		RemotePath: &pan.PanObj{
 			Name: "来自：分享",
 			Type: "dir",
@@ -134,13 +154,14 @@ func TestDownload(t *testing.T) {
 
 func TestUpload(t *testing.T) {
 	defer GracefulExist()
+	ctx := context.Background()
 	client, err := GetClient(pan.Cloudreve)
 	if err != nil {
 		t.Error(err)
 		return
 	}
 
-	err = client.UploadFile(pan.UploadFileReq{
+	err = client.UploadFile(ctx, pan.UploadFileReq{
 		LocalFile:  "D:/download/包青天/新包青天/HD高清修復版 _ 新包青天  01_160 _ 情節峰迴路轉扣人心弦 _ 金超群 _ 呂良偉 _ 范鴻軒 _ 曾守明 _粵語_亞視經典劇集_Asia TV Drama_亞視 1995.mp4",
 		RemotePath: "/test1",
 		Resumable:  true,
@@ -152,14 +173,1330 @@ func TestUpload(t *testing.T) {
 	}
 }
 
+func TestUploadStream(t *testing.T) {
+	defer GracefulExist()
+	ctx := context.Background()
+	client, err := GetClient(pan.Quark)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	content := "pan-client upload stream test"
+	err = client.UploadStream(ctx, pan.UploadStreamReq{
+		Reader:     strings.NewReader(content),
+		Size:       int64(len(content)),
+		Name:       "stream.txt",
+		RemotePath: "/test1",
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+}
+
+func TestPrepareStreamHash(t *testing.T) {
+	ctx := context.Background()
+	content := "0123456789abcdef"
+	reader := strings.NewReader(content)
+	_, _ = reader.Seek(2, io.SeekStart)
+	// 只计算当前位置开始的Size个字节，之后回到原位置
+	streamReq := pan.UploadStreamReq{Reader: reader, Size: 8, Name: "stream.txt"}
+	cleanup, err := pan.PrepareStreamHash(ctx, &streamReq, pan.HashMd5)
+	cleanup()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := md5.Sum([]byte(content[2:10]))
+	if streamReq.Hashes[pan.HashMd5] != hex.EncodeToString(sum[:]) {
+		t.Fatalf("md5 %s", streamReq.Hashes[pan.HashMd5])
+	}
+	if pos, _ := reader.Seek(0, io.SeekCurrent); pos != 2 {
+		t.Fatalf("position %d", pos)
+	}
+	// 流比Size短
+	short := pan.UploadStreamReq{Reader: strings.NewReader(content), Size: 32, Name: "short.txt"}
+	cleanup, err = pan.PrepareStreamHash(ctx, &short, pan.HashMd5)
+	cleanup()
+	if err == nil {
+		t.Fatal("size mismatch not reported")
+	}
+	// ctx取消
+	cancelCtx, cancel := context.WithCancel(ctx)
+	cancel()
+	canceled := pan.UploadStreamReq{Reader: strings.NewReader(content), Size: int64(len(content)), Name: "canceled.txt"}
+	cleanup, err = pan.PrepareStreamHash(cancelCtx, &canceled, pan.HashMd5)
+	cleanup()
+	if err == nil || !errors.Is(err, context.Canceled) {
+		t.Fatalf("canceled %v", err)
+	}
+}
+
+func TestOpen(t *testing.T) {
+	defer GracefulExist()
+	ctx := context.Background()
+	client, err := GetClient(pan.Quark)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	objs, err := client.List(ctx, pan.ListReq{
+		Reload: true,
+		Dir:    &pan.PanObj{Path: "/", Name: "test1", Type: "dir"},
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	var obj *pan.PanObj
+	for _, o := range objs {
+		if o.Name == "stream.txt" {
+			obj = o
+		}
+	}
+	if obj == nil {
+		t.Error("stream.txt not found")
+		return
+	}
+	reader, err := client.Open(ctx, pan.OpenReq{
+		RemoteFile: obj,
+		Offset:     4,
+		Length:     6,
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer reader.Close()
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	// 内容由TestUploadStream上传
+	content := "pan-client upload stream test"
+	if string(data) != content[4:10] {
+		t.Errorf("open got %q", data)
+	}
+}
+
+func TestBaseOpen(t *testing.T) {
+	ctx := context.Background()
+	content := []byte("0123456789abcdefghij")
+	var ranged atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/range":
+			if r.Header.Get("Range") != "" {
+				ranged.Add(1)
+			}
+			http.ServeContent(w, r, "file", time.Time{}, bytes.NewReader(content))
+		case "/full":
+			// 不支持Range，总是返回整个文件
+			_, _ = w.Write(content)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	env, err := internal.NewEnv(internal.EnvOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer env.Close()
+	base := pan.BaseOperate{Env: pan.NewRegistry(env).Env()}
+	open := func(path string, offset, length int64) (string, error) {
+		reader, err := base.BaseOpen(ctx, pan.OpenReq{
+			RemoteFile: &pan.PanObj{Name: "file", Type: "file", Size: int64(len(content))},
+			Offset:     offset,
+			Length:     length,
+		}, req.C(), func(ctx context.Context, req pan.DownloadFileReq) (string, error) {
+			return server.URL + path, nil
+		})
+		if err != nil {
+			return "", err
+		}
+		defer reader.Close()
+		data, err := io.ReadAll(reader)
+		return string(data), err
+	}
+	cases := []struct {
+		offset, length int64
+		want           string
+	}{
+		{4, 6, "456789"},
+		{4, 0, "456789abcdefghij"},
+		{0, 0, string(content)},
+		{18, 10, "ij"},
+	}
+	for _, path := range []string{"/range", "/full"} {
+		for _, c := range cases {
+			got, err := open(path, c.offset, c.length)
+			if err != nil {
+				t.Fatal(path, c.offset, c.length, err)
+			}
+			if got != c.want {
+				t.Errorf("%s offset %d length %d got %q", path, c.offset, c.length, got)
+			}
+		}
+	}
+	// 除了从头读取整个文件，其他都应该带Range请求
+	if n := ranged.Load(); n != 3 {
+		t.Errorf("range requests %d", n)
+	}
+	if _, err = open("/missing", 0, 0); err == nil {
+		t.Error("open missing file should fail")
+	}
+}
+
+func TestListIter(t *testing.T) {
+	defer GracefulExist()
+	ctx := context.Background()
+	client, err := GetClient(pan.ThunderBrowser)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	iter := pan.NewListIter(ctx, client.ListPage, pan.ListPageReq{
+		Dir:      &pan.PanObj{Path: "/", Name: "", Type: "dir"},
+		PageSize: 20,
+	})
+	count := 0
+	for iter.Next() {
+		fmt.Println(iter.Obj().Name)
+		count++
+		// 只取前50个，后面的页不会再请求
+		if count >= 50 {
+			break
+		}
+	}
+	if iter.Err() != nil {
+		t.Error(iter.Err())
+		return
+	}
+}
+
+func TestWalk(t *testing.T) {
+	defer GracefulExist()
+	ctx := context.Background()
+	client, err := GetClient(pan.Quark)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = pan.Walk(ctx, client, &pan.PanObj{Path: "/", Name: "", Type: "dir"}, func(remotePath string, obj *pan.PanObj, err error) error {
+		if err != nil {
+			return err
+		}
+		fmt.Println(remotePath)
+		return nil
+	}, pan.WalkOptions{
+		MaxDepth:    2,
+		Concurrency: 3,
+		Filter: pan.Filter{
+			Exclude: []string{".*"},
+		},
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+}
+
+func TestWalkOptions(t *testing.T) {
+	ctx := context.Background()
+	tree := map[string][]*pan.PanObj{
+		"/": {
+			{Path: "/", Name: "a", Type: "dir"},
+			{Path: "/", Name: "c.txt", Type: "file"},
+			{Path: "/", Name: "d.log", Type: "file"},
+			{Path: "/", Name: ".hidden", Type: "dir"},
+			{Path: "/", Name: "e", Type: "dir"},
+		},
+		"/a": {
+			{Path: "/a", Name: "a1.txt", Type: "file"},
+			{Path: "/a", Name: "b", Type: "dir"},
+		},
+		"/a/b":     {{Path: "/a/b", Name: "b1.txt", Type: "file"}},
+		"/.hidden": {{Path: "/.hidden", Name: "h.txt", Type: "file"}},
+		"/e":       {{Path: "/e", Name: "e1.txt", Type: "file"}},
+	}
+	var listing, maxListing atomic.Int32
+	lister := pan.ListFunc(func(ctx context.Context, req pan.ListReq) ([]*pan.PanObj, error) {
+		n := listing.Add(1)
+		defer listing.Add(-1)
+		for {
+			m := maxListing.Load()
+			if n <= m || maxListing.CompareAndSwap(m, n) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+		return tree[pan.ObjPath(req.Dir)], nil
+	})
+	walk := func(opts pan.WalkOptions, stop map[string]error) []string {
+		var mu sync.Mutex
+		visited := make([]string, 0)
+		err := pan.Walk(ctx, lister, &pan.PanObj{Path: "/", Name: "", Type: "dir"}, func(remotePath string, obj *pan.PanObj, err error) error {
+			if err != nil {
+				return err
+			}
+			mu.Lock()
+			visited = append(visited, remotePath)
+			mu.Unlock()
+			return stop[remotePath]
+		}, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return visited
+	}
+	cases := []struct {
+		name string
+		opts pan.WalkOptions
+		stop map[string]error
+		want string
+	}{
+		{"pre order", pan.WalkOptions{}, nil, "/a,/a/a1.txt,/a/b,/a/b/b1.txt,/c.txt,/d.log,/.hidden,/.hidden/h.txt,/e,/e/e1.txt"},
+		{"skip dir", pan.WalkOptions{}, map[string]error{"/a": pan.SkipDir}, "/a,/c.txt,/d.log,/.hidden,/.hidden/h.txt,/e,/e/e1.txt"},
+		{"skip rest of dir", pan.WalkOptions{}, map[string]error{"/a/a1.txt": pan.SkipDir}, "/a,/a/a1.txt,/c.txt,/d.log,/.hidden,/.hidden/h.txt,/e,/e/e1.txt"},
+		{"skip all", pan.WalkOptions{}, map[string]error{"/c.txt": pan.SkipAll}, "/a,/a/a1.txt,/a/b,/a/b/b1.txt,/c.txt"},
+		{"max depth", pan.WalkOptions{MaxDepth: 1}, nil, "/a,/c.txt,/d.log,/.hidden,/e"},
+		{"post order", pan.WalkOptions{PostOrder: true}, nil, "/a/a1.txt,/a/b/b1.txt,/a/b,/a,/c.txt,/d.log,/.hidden/h.txt,/.hidden,/e/e1.txt,/e"},
+		{"include and exclude", pan.WalkOptions{Filter: pan.Filter{Include: []string{"*.txt"}, Exclude: []string{".*"}}}, nil, "/a,/a/a1.txt,/a/b,/a/b/b1.txt,/c.txt,/e,/e/e1.txt"},
+	}
+	for _, c := range cases {
+		if got := strings.Join(walk(c.opts, c.stop), ","); got != c.want {
+			t.Errorf("%s: %s", c.name, got)
+		}
+	}
+	// 并发时顺序不定，只比较集合，同时列目录的数量不超过Concurrency
+	maxListing.Store(0)
+	got := walk(pan.WalkOptions{Concurrency: 2}, nil)
+	sort.Strings(got)
+	want := strings.Split(cases[0].want, ",")
+	sort.Strings(want)
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("concurrency: %v", got)
+	}
+	if m := maxListing.Load(); m > 2 {
+		t.Errorf("concurrent list %d", m)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	defer GracefulExist()
+	ctx := context.Background()
+	client, err := GetClient(pan.Quark)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	objs, err := client.Search(ctx, pan.SearchReq{
+		Keyword: "stream",
+		Type:    pan.SearchFile,
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	for _, obj := range objs {
+		fmt.Println(obj.Path, obj.Name, obj.Size)
+	}
+}
+
+func TestAncestorIndex(t *testing.T) {
+	// 0 -> a -> b -> c，0 -> x，范围为/a
+	tree := map[string][2]string{"a": {"a", "0"}, "b": {"b", "a"}, "c": {"c", "b"}, "x": {"x", "0"}}
+	calls := make(map[string]int)
+	index := pan.NewAncestorIndex(&pan.PanObj{Id: "a", Name: "a", Path: "/", Type: "dir"}, "0",
+		func(ctx context.Context, id string) (string, string, error) {
+			calls[id]++
+			node, ok := tree[id]
+			if !ok {
+				return "", "", pan.NotFound(id)
+			}
+			return node[0], node[1], nil
+		})
+	ctx := context.Background()
+	dir, err := index.Dir(ctx, "c")
+	if err != nil || dir == nil || pan.ObjPath(dir) != "/a/b/c" {
+		t.Fatal("c should be in scope with full path", dir, err)
+	}
+	if dir, _ = index.Dir(ctx, "b"); dir == nil || pan.ObjPath(dir) != "/a/b" {
+		t.Error("b should be in scope", dir)
+	}
+	if dir, _ = index.Dir(ctx, "x"); dir != nil {
+		t.Error("x should be out of scope", dir)
+	}
+	if dir, err = index.Dir(ctx, "missing"); dir != nil || err != nil {
+		t.Error("missing dir should be out of scope", dir, err)
+	}
+	_, _ = index.Dir(ctx, "x")
+	if calls["a"] != 0 || calls["b"] != 1 || calls["c"] != 1 || calls["x"] != 1 {
+		t.Error("each ancestor should be looked up at most once", calls)
+	}
+}
+
+func TestCapabilities(t *testing.T) {
+	ctx := context.Background()
+	// 能力是静态的，不需要Init
+	cases := []struct {
+		driver pan.Driver
+		want   pan.Capabilities
+	}{
+		{&quark.Quark{}, pan.Capabilities{Drop: true, FastUpload: true, Share: true, ShareRestore: true, Copy: true, Search: true, NativeListPage: true, NativeStat: true}},
+		{&cloudreve.Cloudreve{}, pan.Capabilities{Drop: true, ResumableUpload: true, DirectLink: true, Copy: true, Search: true, NativeStat: true}},
+		{&thunder_browser.ThunderBrowser{}, pan.Capabilities{Drop: true, OfflineDownload: true, TaskList: true, Share: true, ShareRestore: true, Copy: true, Search: true, NativeListPage: true}},
+	}
+	for _, c := range cases {
+		caps := c.driver.Capabilities()
+		if caps != c.want {
+			t.Errorf("%T capabilities %+v", c.driver, caps)
+		}
+		// 不支持的操作返回ErrNotSupported
+		if !caps.DirectLink {
+			if _, err := c.driver.DirectLink(ctx, pan.DirectLinkReq{}); !errors.Is(err, pan.ErrNotSupported) {
+				t.Errorf("%T direct link should return ErrNotSupported: %v", c.driver, err)
+			}
+		}
+		if !caps.OfflineDownload {
+			if _, err := c.driver.OfflineDownload(ctx, pan.OfflineDownloadReq{}); !errors.Is(err, pan.ErrNotSupported) {
+				t.Errorf("%T offline download should return ErrNotSupported: %v", c.driver, err)
+			}
+		}
+	}
+}
+
+func TestDriverError(t *testing.T) {
+	err := pan.KindCodeMsg(pan.ErrAlreadyExists, 40004, "test is exist")
+	wrapped := pan.MsgError("mkdir error", err)
+	if !errors.Is(wrapped, pan.ErrAlreadyExists) || errors.Is(wrapped, pan.ErrNotFound) {
+		t.Error("kind should be kept after wrap", wrapped)
+	}
+	if wrapped.GetCode() != 40004 {
+		t.Error("code should be kept after wrap", wrapped)
+	}
+	if wrapped.Error() != `kind="already exists" code="40004" msg="test is exist mkdir error"` {
+		t.Error("unexpected error string", wrapped)
+	}
+	if !errors.Is(fmt.Errorf("stat: %w", pan.NotFound("/a")), pan.ErrNotFound) {
+		t.Error("not found should work with errors.Is")
+	}
+}
+
+type fakeDriver struct {
+	pan.Driver
+	inits *int32
+	drops int32
+}
+
+func (f *fakeDriver) InitByCustom(id string, read pan.ConfigRW, write pan.ConfigRW) (string, error) {
+	atomic.AddInt32(f.inits, 1)
+	time.Sleep(10 * time.Millisecond)
+	return "fake_id", nil
+}
+
+func (f *fakeDriver) Drop() error {
+	atomic.AddInt32(&f.drops, 1)
+	return pan.NotSupported("drop")
+}
+
+func TestRegistry(t *testing.T) {
+	var inits int32
+	env, err := internal.NewEnv(internal.EnvOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry := pan.NewRegistry(env)
+	var created []*fakeDriver
+	var mu sync.Mutex
+	registry.RegisterDriver("fake", func(env *pan.Env) pan.Driver {
+		mu.Lock()
+		defer mu.Unlock()
+		d := &fakeDriver{inits: &inits}
+		created = append(created, d)
+		return d
+	})
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := registry.GetDriver("", "fake", nil, nil); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if inits != 1 {
+		t.Error("driver should be initialized once, got", inits)
+	}
+	if _, ok := registry.Lookup("fake_id"); !ok || len(registry.Instances()) != 1 {
+		t.Error("instance fake_id should be registered")
+	}
+	existing, _ := registry.Lookup("fake_id")
+	// 另一个id初始化后发现是同一个账号，新初始化的实例被丢弃
+	d, err := registry.GetDriver("other", "fake", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d != existing {
+		t.Error("duplicate account should resolve to the existing instance")
+	}
+	if existing.(*fakeDriver).drops != 0 || len(registry.Instances()) != 1 {
+		t.Error("existing instance should not be dropped")
+	}
+	if len(created) != 2 || created[1].drops != 1 {
+		t.Error("discarded instance should be dropped")
+	}
+	if err := registry.RemoveDriver("fake_id"); err != nil {
+		t.Error(err)
+	}
+	if _, ok := registry.Lookup("fake_id"); ok {
+		t.Error("instance fake_id should be removed")
+	}
+}
+
+func TestCacheNamespace(t *testing.T) {
+	client, err := New(Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	env := client.registry.Env()
+	env.Cache.Set("fake.directory_0", "legacy", time.Minute)
+	a := &pan.CacheOperate{DriverType: "fake", Env: env}
+	a.SetInstanceId("a")
+	b := &pan.CacheOperate{DriverType: "fake", Env: env}
+	b.SetInstanceId("b")
+	if v, ok := a.Get("directory_0"); !ok || v != "legacy" {
+		t.Error("legacy cache should be migrated to the first instance")
+	}
+	if _, ok := b.Get("directory_0"); ok {
+		t.Error("cache should not be shared between instances")
+	}
+	b.Set("directory_0", "b")
+	if client.ClearClientCache("fake", "a") != 1 {
+		t.Error("clear cache of a should delete one item")
+	}
+	if _, ok := a.Get("directory_0"); ok {
+		t.Error("cache of a should be cleared")
+	}
+	if v, ok := b.Get("directory_0"); !ok || v != "b" {
+		t.Error("cache of b should be kept")
+	}
+	b.ClearCache()
+}
+
+func TestNew(t *testing.T) {
+	debug, err := New(Options{Settings: map[string]interface{}{
+		"server": map[string]interface{}{"debug": true},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer debug.Close()
+	quiet, err := New(Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer quiet.Close()
+	if debug.Logger() == logger.StandardLogger() || debug.Logger() == quiet.Logger() {
+		t.Error("each client should have its own logger")
+	}
+	if !debug.Logger().IsLevelEnabled(logger.DebugLevel) || quiet.Logger().IsLevelEnabled(logger.DebugLevel) {
+		t.Error("log level should follow the client config")
+	}
+	debug.registry.Env().Cache.Set("fake/a.directory_0", "a", time.Minute)
+	if quiet.ClearClientCache("fake", "a") != 0 || debug.ClearClientCache("fake", "a") != 1 {
+		t.Error("cache should not be shared between clients")
+	}
+}
+
+type storeProperties struct {
+	Id        string `mapstructure:"id"`
+	Pus       string `mapstructure:"pus"`
+	ChunkSize int64  `mapstructure:"chunk_size"`
+}
+
+func TestConfigStore(t *testing.T) {
+	dir := t.TempDir()
+	fileStore, err := pan.NewFileStore(dir + "/pan-client.toml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PAN_DRIVER_QUARK_PUS", "env_pus")
+	t.Setenv("PAN_DRIVER_QUARK_CHUNK_SIZE", "1024")
+	stores := map[string]pan.ConfigStore{
+		"file":   fileStore,
+		"memory": pan.NewMemoryStore(nil),
+		"env":    pan.NewEnvStore(""),
+		"dir":    pan.NewDirStore(dir+"/accounts", "json"),
+	}
+	for name, store := range stores {
+		saved := &storeProperties{Id: "a", Pus: "pus", ChunkSize: 10}
+		if err = store.Save("driver.quark", saved); err != nil {
+			t.Error(name, err)
+			continue
+		}
+		saved.Pus = "changed"
+		if name == "file" {
+			// 从文件重新读取
+			if store, err = pan.NewFileStore(dir + "/pan-client.toml"); err != nil {
+				t.Fatal(err)
+			}
+		}
+		loaded := &storeProperties{}
+		if err = store.Load("driver.quark", loaded); err != nil || loaded.Pus != "pus" || loaded.ChunkSize != 10 {
+			t.Error(name, "load saved config fail", loaded, err)
+		}
+		if err = store.Delete("driver.quark"); err != nil {
+			t.Error(name, err)
+		}
+		deleted := &storeProperties{Pus: "default"}
+		if err = store.Load("driver.quark", deleted); err != nil {
+			t.Error(name, err)
+		}
+		if name == "env" {
+			if deleted.Pus != "env_pus" || deleted.ChunkSize != 1024 {
+				t.Error("env store should fallback to environment", deleted)
+			}
+		} else if deleted.Pus != "default" {
+			t.Error(name, "deleted config should not be loaded", deleted)
+		}
+	}
+	entries, _ := os.ReadDir(dir)
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".") {
+			t.Error("temp file should be renamed", entry.Name())
+		}
+	}
+}
+
+type accountProperties struct {
+	Id  string `mapstructure:"id"`
+	Pus string `mapstructure:"pus" secret:"true"`
+}
+
+func (p *accountProperties) OnlyImportProperties() {}
+
+func (p *accountProperties) GetId() string {
+	return p.Id
+}
+
+func (p *accountProperties) GetDriverType() pan.DriverType {
+	return "fake"
+}
+
+func TestMultiAccount(t *testing.T) {
+	layouts := map[string]interface{}{
+		"single": map[string]interface{}{"id": "a", "pus": "pa"},
+		"list":   []interface{}{map[string]interface{}{"id": "a", "pus": "pa"}, map[string]interface{}{"id": "b", "pus": "pb"}},
+		"map":    map[string]interface{}{"a": map[string]interface{}{"pus": "pa"}, "b": map[string]interface{}{"pus": "pb"}},
+	}
+	for name, layout := range layouts {
+		client, err := New(Options{Settings: map[string]interface{}{
+			"driver": map[string]interface{}{"fake": layout},
+		}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		env := client.registry.Env()
+		read := func(id string) *pan.PropertiesOperate[*accountProperties] {
+			op := &pan.PropertiesOperate[*accountProperties]{Properties: &accountProperties{Id: id}, AccountId: id, DriverType: "fake", Env: env}
+			if err := op.ReadConfig(); err != nil {
+				t.Error(name, err)
+			}
+			return op
+		}
+		if op := read(""); op.Properties.Id != "a" || op.Properties.Pus != "pa" {
+			t.Error(name, "default account should be the first one", op.Properties)
+		}
+		b := read("b")
+		b.Properties.Pus = "pb2"
+		if err = b.WriteConfig(); err != nil {
+			t.Error(name, err)
+		}
+		if op := read("a"); op.Properties.Pus != "pa" {
+			t.Error(name, "write b should not change a", op.Properties)
+		}
+		if op := read("b"); op.Properties.Pus != "pb2" {
+			t.Error(name, "b should be written", op.Properties)
+		}
+		if err = b.DeleteConfig(); err != nil {
+			t.Error(name, err)
+		}
+		if op := read("b"); op.Properties.Pus != "" {
+			t.Error(name, "b should be deleted", op.Properties)
+		}
+		if op := read("a"); op.Properties.Pus != "pa" {
+			t.Error(name, "delete b should not delete a", op.Properties)
+		}
+		client.Close()
+	}
+}
+
+func TestDirStoreAccounts(t *testing.T) {
+	dir := t.TempDir()
+	client, err := New(Options{Store: pan.NewDirStore(dir, "yaml")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	env := client.registry.Env()
+	read := func(id string) *pan.PropertiesOperate[*accountProperties] {
+		op := &pan.PropertiesOperate[*accountProperties]{Properties: &accountProperties{Id: id}, AccountId: id, DriverType: "fake", Env: env}
+		if err := op.ReadConfig(); err != nil {
+			t.Error(err)
+		}
+		return op
+	}
+	write := func(id, pus string) {
+		op := read(id)
+		op.Properties.Id, op.Properties.Pus = id, pus
+		if err := op.WriteConfig(); err != nil {
+			t.Error(err)
+		}
+	}
+	write("a", "1")
+	write("b", "1")
+	write("a", "2")
+	if op := read("a"); op.Properties.Pus != "2" {
+		t.Error("a should be updated", op.Properties)
+	}
+	if op := read(""); op.Properties.Id != "a" || op.Properties.Pus != "2" {
+		t.Error("default account should read the updated a", op.Properties)
+	}
+	if _, err = os.Stat(dir + "/driver.fake.a.yaml"); err != nil {
+		t.Error("a should be saved to its own file", err)
+	}
+	if data, _ := os.ReadFile(dir + "/driver.fake.yaml"); strings.Contains(string(data), "pus") {
+		t.Error("parent file should only keep the ids", string(data))
+	}
+	if err = read("a").DeleteConfig(); err != nil {
+		t.Error(err)
+	}
+	if op := read("a"); op.Properties.Pus != "" {
+		t.Error("deleted a should not come back", op.Properties)
+	}
+	if op := read(""); op.Properties.Id != "b" || op.Properties.Pus != "1" {
+		t.Error("b should be the default account after a is deleted", op.Properties)
+	}
+}
+
+func TestSecret(t *testing.T) {
+	configFile := t.TempDir() + "/pan-client.yaml"
+	read := func(key string) (*pan.PropertiesOperate[*accountProperties], error) {
+		client, err := New(Options{ConfigFile: configFile, SecretKey: key})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+		op := &pan.PropertiesOperate[*accountProperties]{Properties: &accountProperties{Id: "a"}, AccountId: "a", DriverType: pan.Quark, Env: client.registry.Env()}
+		return op, op.ReadConfig()
+	}
+	op, _ := read("k1")
+	op.Properties.Pus = "plain_pus"
+	if err := op.WriteConfig(); err != nil {
+		t.Fatal(err)
+	}
+	content, _ := os.ReadFile(configFile)
+	if strings.Contains(string(content), "plain_pus") || !strings.Contains(string(content), "enc:v1:") {
+		t.Error("secret field should be encrypted", string(content))
+	}
+	if op, err := read("k1"); err != nil || op.Properties.Pus != "plain_pus" {
+		t.Error("secret field should be decrypted", err)
+	}
+	if err := RekeyConfig(configFile, "k1", "k2"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := read("k1"); err == nil {
+		t.Error("old key should not decrypt after rekey")
+	}
+	if op, err := read("k2"); err != nil || op.Properties.Pus != "plain_pus" {
+		t.Error("new key should decrypt after rekey", err)
+	}
+}
+
+type reloadDriver struct {
+	pan.Driver
+	config   *pan.PropertiesOperate[*accountProperties]
+	reloaded chan string
+}
+
+func (d *reloadDriver) InitByCustom(id string, read pan.ConfigRW, write pan.ConfigRW) (string, error) {
+	return "a", d.config.ReadConfig()
+}
+
+func (d *reloadDriver) Reload(ctx context.Context) error {
+	properties, changed, err := d.config.ReloadConfig()
+	if err != nil || !changed {
+		return err
+	}
+	d.config.SetProperties(properties)
+	d.reloaded <- properties.Pus
+	return nil
+}
+
+func (d *reloadDriver) Drop() error {
+	return nil
+}
+
+func TestWatchConfig(t *testing.T) {
+	configFile := t.TempDir() + "/pan-client.yaml"
+	if err := os.WriteFile(configFile, []byte("server:\n  watch_config: true\ndriver:\n  fake:\n    id: a\n    pus: p1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	client, err := New(Options{ConfigFile: configFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	reloaded := make(chan string, 1)
+	client.registry.RegisterDriver("fake", func(env *pan.Env) pan.Driver {
+		return &reloadDriver{
+			config:   &pan.PropertiesOperate[*accountProperties]{Properties: &accountProperties{Id: "a"}, AccountId: "a", DriverType: "fake", Env: env},
+			reloaded: reloaded,
+		}
+	})
+	if _, err = client.GetClient("fake"); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(configFile, []byte("server:\n  watch_config: true\ndriver:\n  fake:\n    id: a\n    pus: p2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case pus := <-reloaded:
+		if pus != "p2" {
+			t.Error("reloaded pus should be p2, got", pus)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("driver should be reloaded after config file changed")
+	}
+}
+
+func TestPropertiesSnapshot(t *testing.T) {
+	op := &pan.PropertiesOperate[*accountProperties]{Properties: &accountProperties{Id: "a", Pus: "p0"}}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			// 复制后整个替换，不修改已经发布的配置
+			properties := *op.Props()
+			properties.Pus = fmt.Sprint("p", i)
+			op.SetProperties(&properties)
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		if properties := op.Props(); properties.Id != "a" || properties.Pus == "" {
+			t.Error("snapshot should be complete", properties)
+		}
+	}
+	wg.Wait()
+	if op.Props().Pus != "p99" || op.GetId() != "a" {
+		t.Error("last properties should be kept", op.Props())
+	}
+}
+
+func TestValidateConfig(t *testing.T) {
+	client, err := New(Options{Settings: map[string]interface{}{
+		"driver": map[string]interface{}{
+			"cloudreve": map[string]interface{}{"url": "pan.example.com", "type": "unknown", "session": "s", "chunk_size": 0},
+		},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	_, err = client.GetClient(pan.Cloudreve)
+	var validationErr *pan.ValidationError
+	if !errors.Is(err, pan.ErrInvalidConfig) || !errors.As(err, &validationErr) {
+		t.Fatal("should be invalid config", err)
+	}
+	fields := make([]string, 0)
+	for _, field := range validationErr.Fields {
+		fields = append(fields, field.Field)
+	}
+	if strings.Join(fields, ",") != "url,type,chunk_size" {
+		t.Error("invalid fields should be url,type,chunk_size, got", fields, err)
+	}
+
+	schema, err := client.registry.ConfigSchema(pan.Cloudreve)
+	if err != nil {
+		t.Fatal(err)
+	}
+	properties := schema["properties"].(map[string]interface{})
+	if enum, ok := properties["type"].(map[string]interface{})["enum"].([]interface{}); !ok || len(enum) != 6 {
+		t.Error("type should have 6 options", properties["type"])
+	}
+	if session := properties["session"].(map[string]interface{}); session["writeOnly"] != true {
+		t.Error("session should be write only", session)
+	}
+	if required, _ := schema["required"].([]string); strings.Join(required, ",") != "url,session" {
+		t.Error("url and session should be required", schema["required"])
+	}
+	if _, err = json.Marshal(schema); err != nil {
+		t.Error(err)
+	}
+
+	type defaults struct {
+		Tags    []string          `default:"a,b"`
+		Cookies map[string]string `default:"{\"k\":\"v\"}"`
+	}
+	d := &defaults{}
+	internal.SetDefaultByTag(d)
+	if len(d.Tags) != 2 || d.Cookies["k"] != "v" {
+		t.Error("slice and map defaults should be set", d)
+	}
+}
+
+func TestPreallocateDownload(t *testing.T) {
+	content := make([]byte, 100*1024+123)
+	for i := range content {
+		content[i] = byte(i % 251)
+	}
+	var failing atomic.Bool
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		// 第三个分片之后的请求失败，模拟下载中断
+		if failing.Load() && !strings.HasPrefix(r.Header.Get("Range"), "bytes=0-") && !strings.HasPrefix(r.Header.Get("Range"), "bytes=10240-") {
+			time.Sleep(50 * time.Millisecond)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		http.ServeContent(w, r, "file", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	output := t.TempDir() + "/file"
+	scheduler := internal.NewDownloadScheduler(&internal.ServerConfig{DownloadMaxThread: 2}, logger.New())
+	download := func() error {
+		return internal.NewChunkDownload(server.URL, req.C()).
+			SetFileSize(int64(len(content))).
+			SetChunkSize(10 * 1024).
+			SetConcurrency(2).
+			SetOutputFile(output).
+			SetPreallocate(true).
+			SetScheduler(scheduler).
+			Do()
+	}
+	failing.Store(true)
+	if err := download(); err == nil {
+		t.Fatal("download should fail")
+	}
+	if !internal.DownloadPending(output) {
+		t.Fatal("ranges file should be kept for resume")
+	}
+	if info, _ := os.Stat(output); info == nil || info.Size() != int64(len(content)) {
+		t.Fatal("output file should be preallocated")
+	}
+	// 等待上一次下载中还在进行的请求结束
+	time.Sleep(200 * time.Millisecond)
+	failing.Store(false)
+	atomic.StoreInt32(&requests, 0)
+	if err := download(); err != nil {
+		t.Fatal(err)
+	}
+	if requests := atomic.LoadInt32(&requests); requests != 9 {
+		t.Error("only 9 unfinished ranges should be downloaded, got", requests)
+	}
+	if internal.DownloadPending(output) {
+		t.Error("ranges file should be removed")
+	}
+	if got, _ := os.ReadFile(output); !bytes.Equal(got, content) {
+		t.Error("downloaded content mismatch")
+	}
+}
+
+func TestDownloadVerifyHash(t *testing.T) {
+	content := []byte(strings.Repeat("pan-client", 10000))
+	sum := md5.Sum(content)
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		http.ServeContent(w, r, "file", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+	client, err := New(Options{Settings: map[string]interface{}{
+		"server": map[string]interface{}{"download_tmp_path": t.TempDir()},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	base := &pan.BaseOperate{Env: client.registry.Env()}
+	localPath := t.TempDir()
+	download := func(md5Hash string, skipByHash bool) error {
+		atomic.StoreInt32(&requests, 0)
+		object := &pan.PanObj{Name: "file", Path: "/", Type: "file", Size: int64(len(content)), Hashes: map[string]string{pan.HashMd5: md5Hash}}
+		return base.BaseDownloadFile(context.Background(), pan.DownloadFileReq{RemoteFile: object, LocalPath: localPath, SkipByHash: skipByHash, VerifyHash: true}, req.C(),
+			func(ctx context.Context, req pan.DownloadFileReq) (string, error) {
+				return server.URL, nil
+			})
+	}
+	if err = download(strings.ToUpper(hex.EncodeToString(sum[:])), false); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(localPath + "/file"); !bytes.Equal(got, content) {
+		t.Error("downloaded content mismatch")
+	}
+
+	// 大小相同但内容被改过，按hash判断时重新下载
+	corrupted := bytes.ToUpper(content)
+	_ = os.WriteFile(localPath+"/file", corrupted, 0644)
+	if err = download(base64.StdEncoding.EncodeToString(sum[:]), true); err != nil || atomic.LoadInt32(&requests) == 0 {
+		t.Error("corrupted file should be downloaded again", err)
+	}
+	if err = download(hex.EncodeToString(sum[:]), true); err != nil || atomic.LoadInt32(&requests) != 0 {
+		t.Error("same file should be skipped", err)
+	}
+
+	_ = os.Remove(localPath + "/file")
+	err = download("00000000000000000000000000000000", false)
+	var checksumErr *pan.ChecksumError
+	if !errors.Is(err, pan.ErrChecksumMismatch) || !errors.As(err, &checksumErr) || checksumErr.HashType != pan.HashMd5 {
+		t.Error("should be checksum error", err)
+	}
+	if atomic.LoadInt32(&requests) != 2 {
+		t.Error("mismatched file should be downloaded again once, got", requests)
+	}
+
+	// 默认不校验，获取链接时记录的hash不影响传入的对象
+	_ = os.Remove(localPath + "/file")
+	object := &pan.PanObj{Name: "file", Path: "/", Type: "file", Size: int64(len(content)), Hashes: map[string]string{pan.HashMd5: "00000000000000000000000000000000"}}
+	err = base.BaseDownloadFile(context.Background(), pan.DownloadFileReq{RemoteFile: object, LocalPath: localPath}, req.C(),
+		func(ctx context.Context, req pan.DownloadFileReq) (string, error) {
+			req.RemoteFile.SetHash(pan.HashSha1, "0000000000000000000000000000000000000000")
+			return server.URL, nil
+		})
+	if err != nil {
+		t.Error("download without VerifyHash should not verify", err)
+	}
+	if _, ok := object.Hashes[pan.HashSha1]; ok {
+		t.Error("download url should not change the remote file")
+	}
+}
+
+func TestDownloadUrlRefresh(t *testing.T) {
+	content := make([]byte, 100*1024)
+	for i := range content {
+		content[i] = byte(i % 253)
+	}
+	var oldRequests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// 旧链接在三次请求后过期
+		if r.URL.Path == "/old" && atomic.AddInt32(&oldRequests, 1) > 3 {
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte("<Error><Code>AccessDenied</Code><Message>Request has expired</Message></Error>"))
+			return
+		}
+		http.ServeContent(w, r, "file", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	scheduler := internal.NewDownloadScheduler(&internal.ServerConfig{DownloadMaxThread: 2, DownloadMaxRetry: 3}, logger.New())
+	for _, preallocate := range []bool{false, true} {
+		atomic.StoreInt32(&oldRequests, 0)
+		var refreshed int32
+		output := t.TempDir() + "/file"
+		err := internal.NewChunkDownload(server.URL+"/old", req.C()).
+			SetFileSize(int64(len(content))).
+			SetChunkSize(10 * 1024).
+			SetConcurrency(2).
+			SetOutputFile(output).
+			SetTempRootDir(t.TempDir()).
+			SetPreallocate(preallocate).
+			SetScheduler(scheduler).
+			SetUrlRefresher(func(ctx context.Context) (string, error) {
+				atomic.AddInt32(&refreshed, 1)
+				return server.URL + "/new", nil
+			}).
+			Do()
+		if err != nil {
+			t.Fatal(preallocate, err)
+		}
+		if refreshed := atomic.LoadInt32(&refreshed); refreshed != 1 {
+			t.Error(preallocate, "url should be refreshed once, got", refreshed)
+		}
+		if got, _ := os.ReadFile(output); !bytes.Equal(got, content) {
+			t.Error(preallocate, "downloaded content mismatch")
+		}
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	content := make([]byte, 100*1024)
+	for i := range content {
+		content[i] = byte(i % 251)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "file", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	// 全局200KB/s，不限速的实例不影响，100KB约0.5秒
+	scheduler := internal.NewDownloadScheduler(&internal.ServerConfig{DownloadMaxThread: 4, DownloadMaxRetry: 3}, logger.New())
+	output := t.TempDir() + "/file"
+	start := time.Now()
+	err := internal.NewChunkDownload(server.URL, req.C()).
+		SetFileSize(int64(len(content))).
+		SetChunkSize(10*1024).
+		SetConcurrency(4).
+		SetOutputFile(output).
+		SetTempRootDir(t.TempDir()).
+		SetScheduler(scheduler).
+		SetRateLimiters(internal.NewRateLimiter(200*1024), internal.NewRateLimiter(0), internal.TransferLimiter(0)).
+		Do()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+		t.Error("download should be limited, took", elapsed)
+	}
+	if got, _ := os.ReadFile(output); !bytes.Equal(got, content) {
+		t.Error("downloaded content mismatch")
+	}
+
+	limiter := internal.NewRateLimiter(128 * 1024)
+	reader := internal.LimitReader(context.Background(), bytes.NewReader(content), limiter)
+	if _, ok := reader.(io.Seeker); !ok {
+		t.Error("limited reader should keep Seek")
+	}
+	start = time.Now()
+	if _, err = io.CopyN(io.Discard, reader, 64*1024); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+		t.Error("upload should be limited, took", elapsed)
+	}
+	// 运行中取消限速，剩余部分立即读完
+	limiter.SetLimit(0)
+	start = time.Now()
+	if _, err = io.Copy(io.Discard, reader); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 200*time.Millisecond {
+		t.Error("limit should be removed at runtime, took", elapsed)
+	}
+
+	// 等待中取消ctx时立即返回
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err = internal.NewRateLimiter(1024).WaitN(ctx, 10*1024); !errors.Is(err, context.DeadlineExceeded) {
+		t.Error("wait should stop when context is done, got", err)
+	}
+}
+
+type progressRecorder struct {
+	mu     sync.Mutex
+	events []pan.ProgressEvent
+}
+
+func (r *progressRecorder) OnProgress(event pan.ProgressEvent) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, event)
+}
+
+func (r *progressRecorder) take() []pan.ProgressEvent {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	events := r.events
+	r.events = nil
+	return events
+}
+
+func countEvents(events []pan.ProgressEvent, eventType pan.ProgressEventType) int {
+	count := 0
+	for _, event := range events {
+		if event.Type == eventType {
+			count++
+		}
+	}
+	return count
+}
+
+func TestProgressEvents(t *testing.T) {
+	content := make([]byte, 100*1024)
+	for i := range content {
+		content[i] = byte(i % 247)
+	}
+	var failed int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// 第三个分片第一次请求失败
+		if r.Header.Get("Range") == "bytes=20480-30719" && atomic.AddInt32(&failed, 1) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		http.ServeContent(w, r, "file", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+	client, err := New(Options{Settings: map[string]interface{}{
+		"server": map[string]interface{}{"download_tmp_path": t.TempDir()},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	global := &progressRecorder{}
+	remove := client.AddProgressListener(global)
+	base := &pan.BaseOperate{Env: client.registry.Env()}
+
+	recorder := &progressRecorder{}
+	object := &pan.PanObj{Name: "file", Path: "/remote", Type: "file", Size: int64(len(content))}
+	err = base.BaseDownloadFile(context.Background(), pan.DownloadFileReq{
+		RemoteFile:  object,
+		LocalPath:   t.TempDir(),
+		ChunkSize:   10 * 1024,
+		Concurrency: 2,
+		Progress:    recorder,
+	}, req.C(), func(ctx context.Context, req pan.DownloadFileReq) (string, error) {
+		return server.URL, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := recorder.take()
+	if len(events) == 0 || events[0].Type != pan.ProgressStart || events[len(events)-1].Type != pan.ProgressFinished {
+		t.Fatal("download should start and finish", events)
+	}
+	last := events[len(events)-1]
+	if last.Direction != pan.DirectionDownload || last.Remote != "/remote/file" || last.Bytes != last.Total || last.Total != int64(len(content)) {
+		t.Error("unexpected finished event", last)
+	}
+	if chunks := countEvents(events, pan.ProgressChunk); chunks != 10 {
+		t.Error("should report 10 chunks, got", chunks)
+	}
+	if retries := countEvents(events, pan.ProgressRetry); retries != 1 {
+		t.Error("should report 1 retry, got", retries)
+	}
+	if len(global.take()) != len(events) {
+		t.Error("global listener should receive the same events")
+	}
+
+	upload := func(fail error) []pan.ProgressEvent {
+		err := base.BaseUploadStream(context.Background(), pan.UploadStreamReq{
+			Reader:     bytes.NewReader(content),
+			Size:       int64(len(content)),
+			Name:       "file",
+			RemotePath: "/up/",
+			Progress:   recorder,
+		}, func(ctx context.Context, req pan.UploadStreamReq) error {
+			pr, e := base.NewStreamProgressReader(base.UploadReader(ctx, req), req.Name, req.Size, 40*1024, 0)
+			if e != nil {
+				return e
+			}
+			pr.SetProgress(req.ProgressTracker())
+			for !pr.IsFinish() {
+				pr.NextChunk()
+				if _, err := io.Copy(io.Discard, pr); err != nil {
+					return err
+				}
+			}
+			return fail
+		})
+		if !errors.Is(err, fail) {
+			t.Error("upload should return", fail, "got", err)
+		}
+		return recorder.take()
+	}
+	events = upload(nil)
+	last = events[len(events)-1]
+	if events[0].Type != pan.ProgressStart || last.Type != pan.ProgressFinished || last.Direction != pan.DirectionUpload || last.Remote != "/up/file" {
+		t.Error("unexpected upload events", events)
+	}
+	if chunks := countEvents(events, pan.ProgressChunk); chunks != 3 {
+		t.Error("should report 3 upload chunks, got", chunks)
+	}
+	if bytesEvents := countEvents(events, pan.ProgressBytes); bytesEvents == 0 || last.Bytes != int64(len(content)) {
+		t.Error("should report uploaded bytes", events)
+	}
+
+	// 读取后回退，再从已上传的位置续传，进度不能重复计算
+	err = base.BaseUploadStream(context.Background(), pan.UploadStreamReq{
+		Reader:     bytes.NewReader(content),
+		Size:       int64(len(content)),
+		Name:       "file",
+		RemotePath: "/up/",
+		Progress:   recorder,
+	}, func(ctx context.Context, req pan.UploadStreamReq) error {
+		reader := base.UploadReader(ctx, req)
+		if _, err := io.CopyN(io.Discard, reader, 10*1024); err != nil {
+			return err
+		}
+		if _, err := reader.(io.Seeker).Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		pr, e := base.NewStreamProgressReader(reader, req.Name, req.Size, 40*1024, 40*1024)
+		if e != nil {
+			return e
+		}
+		pr.SetProgress(req.ProgressTracker())
+		for !pr.IsFinish() {
+			pr.NextChunk()
+			if _, err := io.Copy(io.Discard, pr); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	events = recorder.take()
+	var lastBytes pan.ProgressEvent
+	for _, event := range events {
+		if event.Type == pan.ProgressBytes {
+			lastBytes = event
+		}
+	}
+	if lastBytes.Bytes != int64(len(content)) {
+		t.Error("seek should adjust uploaded bytes, got", lastBytes.Bytes)
+	}
+	if chunks := countEvents(events, pan.ProgressChunk); chunks != 2 {
+		t.Error("should report 2 resumed upload chunks, got", chunks)
+	}
+
+	remove()
+	global.take()
+	uploadErr := errors.New("upload fail")
+	events = upload(uploadErr)
+	if last = events[len(events)-1]; last.Type != pan.ProgressFailed || !errors.Is(last.Err, uploadErr) {
+		t.Error("upload should fail", last)
+	}
+	if len(global.take()) != 0 {
+		t.Error("removed listener should not receive events")
+	}
+}
+
+func TestBindContext(t *testing.T) {
+	c := &pan.CommonOperate{}
+	ctx, cancel := c.BindContext(context.Background())
+	defer cancel()
+	c.CancelAll()
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Error("bound context should be canceled after CancelAll")
+	}
+	after, cancelAfter := c.BindContext(context.Background())
+	defer cancelAfter()
+	if after.Err() == nil {
+		t.Error("context bound after CancelAll should be canceled")
+	}
+}
+
 func TestOfflineDownload(t *testing.T) {
 	defer GracefulExist()
+	ctx := context.Background()
 	client, err := GetClient(pan.ThunderBrowser)
 	if err != nil {
 		t.Error(err)
 		return
 	}
-	downloadTask, err := client.OfflineDownload(pan.OfflineDownloadReq{
+	downloadTask, err := client.OfflineDownload(ctx, pan.OfflineDownloadReq{
 		RemotePath: "/tmpdownload",
 		Url:        "magnet:?xt=urn:btih:bd28bedb444fc8293ba86ea8989bfe9e8ff2bf6e&dn=TVBOXNOW+%E6%88%80%E6%84%9B%E8%87%AA%E7%94%B1%E5%BC%8F&tr=udp%3A%2F%2Ftracker.publicbt.com%3A80%2Fannounce&tr=udp%3A%2F%2Ftracker.openbittorrent.com%3A80%2Fannounce&tr=udp%3A%2F%2Fpublic.popcorn-tracker.org%3A6969%2Fannounce&tr=http%3A%2F%2F104.28.1.30%3A8080%2Fannounce&tr=http%3A%2F%2F104.28.16.69%2Fannounce&tr=http%3A%2F%2F107.150.14.110%3A6969%2Fannounce&tr=http%3A%2F%2F109.121.134.121%3A1337%2Fannounce&tr=http%3A%2F%2F114.55.113.60%3A6969%2Fannounce&tr=http%3A%2F%2F125.227.35.196%3A6969%2Fannounce&tr=http%3A%2F%2F128.199.70.66%3A5944%2Fannounce&tr=http%3A%2F%2F157.7.202.64%3A8080%2Fannounce&tr=http%3A%2F%2F158.69.146.212%3A7777%2Fannounce&tr=http%3A%2F%2F173.254.204.71%3A1096%2Fannounce&tr=http%3A%2F%2F178.175.143.27%2Fannounce&tr=http%3A%2F%2F178.33.73.26%3A2710%2Fannounce&tr=http%3A%2F%2F182.176.139.129%3A6969%2Fannounce&tr=http%3A%2F%2F185.5.97.139%3A8089%2Fannounce&tr=http%3A%2F%2F188.165.253.109%3A1337%2Fannounce&tr=http%3A%2F%2F194.106.216.222%2Fannounce&tr=http%3A%2F%2F195.123.209.37%3A1337%2Fannounce&tr=http%3A%2F%2F210.244.71.25%3A6969%2Fannounce&tr=http%3A%2F%2F210.244.71.26%3A6969%2Fannounce&tr=http%3A%2F%2F213.159.215.198%3A6970%2Fannounce&tr=http%3A%2F%2F213.163.67.56%3A1337%2Fannounce&tr=http%3A%2F%2F37.19.5.139%3A6969%2Fannounce&tr=http%3A%2F%2F37.19.5.155%3A6881%2Fannounce&tr=http%3A%2F%2F46.4.109.148%3A6969%2Fannounce&tr=http%3A%2F%2F5.79.249.77%3A6969%2Fannounce&tr=http%3A%2F%2F5.79.83.193%3A2710%2Fannounce&tr=http%3A%2F%2F51.254.244.161%3A6969%2Fannounce&tr=http%3A%2F%2F59.36.96.77%3A6969%2Fannounce&tr=http%3A%2F%2F74.82.52.209%3A6969%2Fannounce&tr=http%3A%2F%2F80.246.243.18%3A6969%2Fannounce&tr=http%3A%2F%2F81.200.2.231%2Fannounce&tr=http%3A%2F%2F85.17.19.180%2Fannounce&tr=http%3A%2F%2F87.248.186.252%3A8080%2Fannounce&tr=http%3A%2F%2F87.253.152.137%2Fannounce&tr=http%3A%2F%2F91.216.110.47%2Fannounce&tr=http%3A%2F%2F91.217.91.21%3A3218%2Fannounce&tr=http%3A%2F%2F91.218.230.81%3A6969%2Fannounce&tr=http%3A%2F%2F93.92.64.5%2Fannounce&tr=http%3A%2F%2Fatrack.pow7.com%2Fannounce&tr=http%3A%2F%2Fbt.henbt.com%3A2710%2Fannounce&tr=http%3A%2F%2Fbt.pusacg.org%3A8080%2Fannounce&tr=http%3A%2F%2Fbt2.careland.com.cn%3A6969%2Fannounce&tr=http%3A%2F%2Fexplodie.org%3A6969%2Fannounce&tr=http%3A%2F%2Fmgtracker.org%3A2710%2Fannounce&tr=http%3A%2F%2Fmgtracker.org%3A6969%2Fannounce&tr=http%3A%2F%2Fopen.acgtracker.com%3A1096%2Fannounce&tr=http%3A%2F%2Fopen.lolicon.eu%3A7777%2Fannounce&tr=http%3A%2F%2Fopen.touki.ru%2Fannounce.php&tr=http%3A%2F%2Fp4p.arenabg.ch%3A1337%2Fannounce&tr=http%3A%2F%2Fp4p.arenabg.com%3A1337%2Fannounce&tr=http%3A%2F%2Fpow7.com%2Fannounce&tr=http%3A%2F%2Fretracker.gorcomnet.ru%2Fannounce&tr=http%3A%2F%2Fretracker.krs-ix.ru%2Fannounce&tr=http%3A%2F%2Fsecure.pow7.com%2Fannounce&tr=http%3A%2F%2Ft1.pow7.com%2Fannounce&tr=http%3A%2F%2Ft2.pow7.com%2Fannounce&tr=http%3A%2F%2Fthetracker.org%2Fannounce&tr=http%3A%2F%2Ftorrent.gresille.org%2Fannounce&tr=http%3A%2F%2Ftorrentsmd.com%3A8080%2Fannounce&tr=http%3A%2F%2Ftracker.aletorrenty.pl%3A2710%2Fannounce&tr=http%3A%2F%2Ftracker.baravik.org%3A6970%2Fannounce&tr=http%3A%2F%2Ftracker.bittor.pw%3A1337%2Fannounce&tr=http%3A%2F%2Ftracker.bittorrent.am%2Fannounce&tr=http%3A%2F%2Ftracker.calculate.ru%3A6969%2Fannounce&tr=http%3A%2F%2Ftracker.dler.org%3A6969%2Fannounce&tr=http%3A%2F%2Ftracker.dutchtracking.com%2Fannounce&tr=http%3A%2F%2Ftracker.dutchtracking.nl%2Fannounce&tr=http%3A%2F%2Ftracker.edoardocolombo.eu%3A6969%2Fannounce&tr=http%3A%2F%2Ftracker.ex.ua%2Fannounce&tr=http%3A%2F%2Ftracker.filetracker.pl%3A8089%2Fannounce&tr=http%3A%2F%2Ftracker.flashtorrents.org%3A6969%2Fannounce&tr=http%3A%2F%2Ftracker.grepler.com%3A6969%2Fannounce&tr=http%3A%2F%2Ftracker.internetwarriors.net%3A1337%2Fannounce&tr=http%3A%2F%2Ftracker.kicks-ass.net%2Fannounce&tr=http%3A%2F%2Ftracker.kuroy.me%3A5944%2Fannounce&tr=http%3A%2F%2Ftracker.mg64.net%3A6881%2Fannounce&tr=http%3A%2F%2Ftracker.opentrackr.org%3A1337%2Fannounce&tr=http%3A%2F%2Ftracker.skyts.net%3A6969%2Fannounce&tr=http%3A%2F%2Ftracker.tfile.me%2Fannounce&tr=http%3A%2F%2Ftracker.tiny-vps.com%3A6969%2Fannounce&tr=http%3A%2F%2Ftracker.tvunderground.org.ru%3A3218%2Fannounce&tr=http%3A%2F%2Ftracker.yoshi210.com%3A6969%2Fannounce&tr=http%3A%2F%2Ftracker1.wasabii.com.tw%3A6969%2Fannounce&tr=http%3A%2F%2Ftracker2.itzmx.com%3A6961%2Fannounce&tr=http%3A%2F%2Ftracker2.wasabii.com.tw%3A6969%2Fannounce&tr=http%3A%2F%2Fwww.wareztorrent.com%2Fannounce&tr=https%3A%2F%2F104.28.17.69%2Fannounce&tr=https%3A%2F%2Fwww.wareztorrent.com%2Fannounce&tr=udp%3A%2F%2F107.150.14.110%3A6969%2Fannounce&tr=udp%3A%2F%2F109.121.134.121%3A1337%2Fannounce&tr=udp%3A%2F%2F114.55.113.60%3A6969%2Fannounce&tr=udp%3A%2F%2F128.199.70.66%3A5944%2Fannounce&tr=udp%3A%2F%2F151.80.120.114%3A2710%2Fannounce&tr=udp%3A%2F%2F168.235.67.63%3A6969%2Fannounce&tr=udp%3A%2F%2F178.33.73.26%3A2710%2Fannounce&tr=udp%3A%2F%2F182.176.139.129%3A6969%2Fannounce&tr=udp%3A%2F%2F185.5.97.139%3A8089%2Fannounce&tr=udp%3A%2F%2F185.86.149.205%3A1337%2Fannounce&tr=udp%3A%2F%2F188.165.253.109%3A1337%2Fannounce&tr=udp%3A%2F%2F191.101.229.236%3A1337%2Fannounce&tr=udp%3A%2F%2F194.106.216.222%3A80%2Fannounce&tr=udp%3A%2F%2F195.123.209.37%3A1337%2Fannounce&tr=udp%3A%2F%2F195.123.209.40%3A80%2Fannounce&tr=udp%3A%2F%2F208.67.16.113%3A8000%2Fannounce&tr=udp%3A%2F%2F213.163.67.56%3A1337%2Fannounce&tr=udp%3A%2F%2F37.19.5.155%3A2710%2Fannounce&tr=udp%3A%2F%2F46.4.109.148%3A6969%2Fannounce&tr=udp%3A%2F%2F5.79.249.77%3A6969%2Fannounce&tr=udp%3A%2F%2F5.79.83.193%3A6969%2Fannounce&tr=udp%3A%2F%2F51.254.244.161%3A6969%2Fannounce&tr=udp%3A%2F%2F62.138.0.158%3A6969%2Fannounce&tr=udp%3A%2F%2F62.212.85.66%3A2710%2Fannounce&tr=udp%3A%2F%2F74.82.52.209%3A6969%2Fannounce&tr=udp%3A%2F%2F85.17.19.180%3A80%2Fannounce&tr=udp%3A%2F%2F89.234.156.205%3A80%2Fannounce&tr=udp%3A%2F%2F9.rarbg.com%3A2710%2Fannounce&tr=udp%3A%2F%2F9.rarbg.me%3A2780%2Fannounce&tr=udp%3A%2F%2F9.rarbg.to%3A2730%2Fannounce&tr=udp%3A%2F%2F91.218.230.81%3A6969%2Fannounce&tr=udp%3A%2F%2F94.23.183.33%3A6969%2Fannounce&tr=udp%3A%2F%2Fbt.xxx-tracker.com%3A2710%2Fannounce&tr=udp%3A%2F%2Feddie4.nl%3A6969%2Fannounce&tr=udp%3A%2F%2Fexplodie.org%3A6969%2Fannounce&tr=udp%3A%2F%2Fmgtracker.org%3A2710%2Fannounce&tr=udp%3A%2F%2Fopen.stealth.si%3A80%2Fannounce&tr=udp%3A%2F%2Fp4p.arenabg.com%3A1337%2Fannounce&tr=udp%3A%2F%2Fshadowshq.eddie4.nl%3A6969%2Fannounce&tr=udp%3A%2F%2Fshadowshq.yi.org%3A6969%2Fannounce&tr=udp%3A%2F%2Ftorrent.gresille.org%3A80%2Fannounce&tr=udp%3A%2F%2Ftracker.aletorrenty.pl%3A2710%2Fannounce&tr=udp%3A%2F%2Ftracker.bittor.pw%3A1337%2Fannounce&tr=udp%3A%2F%2Ftracker.coppersurfer.tk%3A6969%2Fannounce&tr=udp%3A%2F%2Ftracker.eddie4.nl%3A6969%2Fannounce&tr=udp%3A%2F%2Ftracker.ex.ua%3A80%2Fannounce&tr=udp%3A%2F%2Ftracker.filetracker.pl%3A8089%2Fannounce&tr=udp%3A%2F%2Ftracker.flashtorrents.org%3A6969%2Fannounce&tr=udp%3A%2F%2Ftracker.grepler.com%3A6969%2Fannounce&tr=udp%3A%2F%2Ftracker.ilibr.org%3A80%2Fannounce&tr=udp%3A%2F%2Ftracker.internetwarriors.net%3A1337%2Fannounce&tr=udp%3A%2F%2Ftracker.kicks-ass.net%3A80%2Fannounce&tr=udp%3A%2F%2Ftracker.kuroy.me%3A5944%2Fannounce&tr=udp%3A%2F%2Ftracker.leechers-paradise.org%3A6969%2Fannounce&tr=udp%3A%2F%2Ftracker.mg64.net%3A2710%2Fannounce&tr=udp%3A%2F%2Ftracker.mg64.net%3A6969%2Fannounce&tr=udp%3A%2F%2Ftracker.opentrackr.org%3A1337%2Fannounce&tr=udp%3A%2F%2Ftracker.piratepublic.com%3A1337%2Fannounce&tr=udp%3A%2F%2Ftracker.sktorrent.net%3A6969%2Fannounce&tr=udp%3A%2F%2Ftracker.skyts.net%3A6969%2Fannounce&tr=udp%3A%2F%2Ftracker.tiny-vps.com%3A6969%2Fannounce&tr=udp%3A%2F%2Ftracker.yoshi210.com%3A6969%2Fannounce&tr=udp%3A%2F%2Ftracker2.indowebster.com%3A6969%2Fannounce&tr=udp%3A%2F%2Ftracker4.piratux.com%3A6969%2Fannounce&tr=udp%3A%2F%2Fzer0day.ch%3A1337%2Fannounce&tr=udp%3A%2F%2Fzer0day.to%3A1337%2Fannounce",
 	})
@@ -168,7 +1505,7 @@ func TestOfflineDownload(t *testing.T) {
 		return
 	}
 	if downloadTask.Phase != thunder_browser.PhaseTypeComplete {
-		taskResp, err := client.TaskList(pan.TaskListReq{
+		taskResp, err := client.TaskList(ctx, pan.TaskListReq{
 			Ids: []string{downloadTask.Id},
 		})
 		if err != nil {
@@ -186,19 +1523,20 @@ func TestOfflineDownload(t *testing.T) {
 
 func TestShare(t *testing.T) {
 	defer GracefulExist()
+	ctx := context.Background()
 	client, err := GetClient(pan.Quark)
 	if err != nil {
 		t.Error(err)
 		return
 	}
-	dir, err := client.Mkdir(pan.MkdirReq{
+	dir, err := client.Mkdir(ctx, pan.MkdirReq{
 		NewPath: "/影视/僵",
 	})
 	if err != nil {
 		t.Error(err)
 		return
 	}
-	share, err := client.NewShare(pan.NewShareReq{
+	share, err := client.NewShare(ctx, pan.NewShareReq{
 		Fids:         []string{dir.Id},
 		Title:        "我的分享",
 		NeedPassCode: false,
@@ -208,7 +1546,7 @@ func TestShare(t *testing.T) {
 		t.Error(err)
 		return
 	}
-	shareList, err := client.ShareList(pan.ShareListReq{
+	shareList, err := client.ShareList(ctx, pan.ShareListReq{
 		ShareIds: []string{share.ShareId},
 	})
 	if err != nil {
@@ -221,7 +1559,7 @@ func TestShare(t *testing.T) {
 		return
 	}
 	fmt.Println(string(marshal))
-	err = client.DeleteShare(pan.DelShareReq{
+	err = client.DeleteShare(ctx, pan.DelShareReq{
 		ShareIds: []string{share.ShareId},
 	})
 	if err != nil {
@@ -232,12 +1570,13 @@ func TestShare(t *testing.T) {
 
 func TestShareRestore(t *testing.T) {
 	defer GracefulExist()
+	ctx := context.Background()
 	client, err := GetClient(pan.Quark)
 	if err != nil {
 		t.Error(err)
 		return
 	}
-	//err = client.ShareRestore(pan.ShareRestoreReq{
+	//err = client.ShareRestore(ctx, pan.ShareRestoreReq{
 	//	ShareUrl:  "https://pan.xunlei.com/s/VOESxSgsp_Zg1E4WDWxx689sA1?pwd=jab2",
 	//	TargetDir: "/tmpdata",
 	//})
@@ -245,7 +1584,7 @@ func TestShareRestore(t *testing.T) {
 	//	t.Error(err)
 	//	return
 	//}
-	err = client.ShareRestore(pan.ShareRestoreReq{
+	err = client.ShareRestore(ctx, pan.ShareRestoreReq{
 		ShareUrl:  "https://pan.quark.cn/s/83dae5e77944",
 		PassCode:  "8uSJ",
 		TargetDir: "/tmpdata",
@@ -258,20 +1597,20 @@ func TestShareRestore(t *testing.T) {
 
 func TestDirectLink(t *testing.T) {
 	defer GracefulExist()
+	ctx := context.Background()
 	//client, err := GetClient(pan.Cloudreve)
 	client, err := GetClientByRw("c3695b6f-6566-400c-bf11-7b08e2c72762", pan.Cloudreve, func(config pan.Properties) error {
 		internal.SetDefaultByTag(config)
-		return internal.Viper.UnmarshalKey(pan.ViperDriverPrefix+string(pan.Cloudreve), config)
+		return internal.DefaultEnv().Store.Load(pan.ViperDriverPrefix+string(pan.Cloudreve), config)
 
 	}, func(config pan.Properties) error {
-		internal.Viper.Set(pan.ViperDriverPrefix+string(pan.Cloudreve), config)
-		return internal.Viper.WriteConfig()
+		return internal.DefaultEnv().Store.Save(pan.ViperDriverPrefix+string(pan.Cloudreve), config)
 	})
 	if err != nil {
 		t.Error(err)
 		return
 	}
-	list, err := client.List(pan.ListReq{Dir: &pan.PanObj{
+	list, err := client.List(ctx, pan.ListReq{Dir: &pan.PanObj{
 		Path: "/",
 		Name: "test1",
 	}, Reload: true})
@@ -288,7 +1627,7 @@ func TestDirectLink(t *testing.T) {
 			})
 		}
 	}
-	link, err := client.DirectLink(pan.DirectLinkReq{List: links})
+	link, err := client.DirectLink(ctx, pan.DirectLinkReq{List: links})
 	if err != nil {
 		t.Error(err)
 		return
@@ -1,12 +1,14 @@
 package internal
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
 	"github.com/imroc/req/v3"
-	logger "github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus"
 	"io"
+	"net/http"
 	urlpkg "net/url"
 	"os"
 	"path/filepath"
@@ -17,37 +19,90 @@ import (
 	"time"
 )
 
-var shutdown = false
-var runningMap = make(map[*ChunkDownload]bool)
-var downloadMaxChan chan struct{}
+// DownloadScheduler 限制同时下载的分片数量，关闭时等待正在进行的下载结束
+type DownloadScheduler struct {
+	mu       sync.Mutex
+	shutdown bool
+	running  map[*ChunkDownload]bool
+	sem      chan struct{}
+	config   *ServerConfig
+	logger   *logrus.Logger
+}
 
-func InitChunkDownload() {
-	downloadMaxChan = make(chan struct{}, Config.Server.DownloadMaxThread)
-	go func() {
-		for {
-			// 判断是否已经关闭
-			if shutdown {
-				// 等待所有的下载器完成
-				i := len(runningMap)
-				if i == 0 {
-					ExitWaitGroup.Done()
-					break
-				}
-				// 休眠
-				time.Sleep(500 * time.Millisecond)
-				continue
-			}
-			select {
-			case <-ChunkExitChan:
-				shutdown = true
-			}
+func NewDownloadScheduler(config *ServerConfig, logger *logrus.Logger) *DownloadScheduler {
+	maxThread := config.DownloadMaxThread
+	if maxThread <= 0 {
+		maxThread = 1
+	}
+	return &DownloadScheduler{
+		running: make(map[*ChunkDownload]bool),
+		sem:     make(chan struct{}, maxThread),
+		config:  config,
+		logger:  logger,
+	}
+}
+
+func (s *DownloadScheduler) IsShutdown() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.shutdown
+}
+
+// Shutdown 不再接受新的下载，并等待所有的下载器完成
+func (s *DownloadScheduler) Shutdown() {
+	s.mu.Lock()
+	s.shutdown = true
+	s.mu.Unlock()
+	for {
+		s.mu.Lock()
+		i := len(s.running)
+		s.mu.Unlock()
+		if i == 0 {
+			return
 		}
-	}()
+		// 休眠
+		time.Sleep(500 * time.Millisecond)
+	}
+}
+
+func (s *DownloadScheduler) start(pd *ChunkDownload) {
+	s.mu.Lock()
+	s.running[pd] = true
+	s.mu.Unlock()
+}
+
+func (s *DownloadScheduler) done(pd *ChunkDownload) {
+	s.mu.Lock()
+	delete(s.running, pd)
+	s.mu.Unlock()
+}
+
+// UrlRefresher 下载链接过期时重新获取
+type UrlRefresher func(ctx context.Context) (string, error)
+
+// LinkExpired 根据分片请求的状态码和响应体开头判断下载链接是否过期
+type LinkExpired func(status int, body []byte) bool
+
+// DefaultLinkExpired 签名链接过期时一般返回403或410，部分网盘返回400或401并在响应体中说明
+func DefaultLinkExpired(status int, body []byte) bool {
+	switch status {
+	case http.StatusForbidden, http.StatusGone:
+		return true
+	case http.StatusBadRequest, http.StatusUnauthorized:
+		return bytes.Contains(bytes.ToLower(body), []byte("expire"))
+	}
+	return false
 }
 
 type ChunkDownload struct {
 	url             string
+	urlMu           sync.RWMutex
+	refresher       UrlRefresher
+	linkExpired     LinkExpired
+	limiters        []*RateLimiter
+	progress        *ProgressTracker
 	client          *req.Client
+	ctx             context.Context
 	concurrency     int
 	output          io.Writer
 	filename        string
@@ -66,6 +121,12 @@ type ChunkDownload struct {
 	mu              sync.Mutex
 	lastIndex       int
 	pw              *progressWriter
+	scheduler       *DownloadScheduler
+	// 预分配模式：分片直接写入输出文件的对应位置，不再使用临时文件
+	preallocate bool
+	fileMu      sync.RWMutex
+	file        *os.File
+	bitmap      *rangeBitmap
 }
 
 func NewChunkDownload(url string, client *req.Client) *ChunkDownload {
@@ -87,6 +148,14 @@ func (pd *ChunkDownload) completeTask(task *downloadTask) {
 	}()
 }
 
+// fail 上报错误，下载已结束时直接丢弃
+func (pd *ChunkDownload) fail(err error) {
+	select {
+	case pd.errCh <- err:
+	case <-pd.doneCh:
+	}
+}
+
 func (pd *ChunkDownload) popTask(index int) *downloadTask {
 	pd.mu.Lock()
 	if task, ok := pd.taskMap[index]; ok {
@@ -96,12 +165,16 @@ func (pd *ChunkDownload) popTask(index int) *downloadTask {
 	}
 	pd.mu.Unlock()
 	for {
-		task := <-pd.taskNotifyCh
-		if task.index == index {
-			pd.mu.Lock()
-			delete(pd.taskMap, index)
-			pd.mu.Unlock()
-			return task
+		select {
+		case task := <-pd.taskNotifyCh:
+			if task.index == index {
+				pd.mu.Lock()
+				delete(pd.taskMap, index)
+				pd.mu.Unlock()
+				return task
+			}
+		case <-pd.doneCh:
+			return nil
 		}
 	}
 }
@@ -114,19 +187,21 @@ func (pd *ChunkDownload) ensure() error {
 	if pd.chunkSize <= 0 {
 		pd.chunkSize = 1024 * 1024 * 10 // 10MB
 	}
-	if pd.tempRootDir == "" {
-		pd.tempRootDir = os.TempDir()
-		//pd.tempRootDir = "./tmp"
-	}
-	fullPath, err := filepath.Abs(pd.filename)
-	if err != nil {
-		return err
-	}
-	pd.tempDir = filepath.Join(pd.tempRootDir, Md5HashStr(fullPath))
+	if !pd.direct() {
+		if pd.tempRootDir == "" {
+			pd.tempRootDir = os.TempDir()
+			//pd.tempRootDir = "./tmp"
+		}
+		fullPath, err := filepath.Abs(pd.filename)
+		if err != nil {
+			return err
+		}
+		pd.tempDir = filepath.Join(pd.tempRootDir, Md5HashStr(fullPath))
 
-	err = os.MkdirAll(pd.tempDir, os.ModePerm)
-	if err != nil {
-		return err
+		err = os.MkdirAll(pd.tempDir, os.ModePerm)
+		if err != nil {
+			return err
+		}
 	}
 
 	pd.taskCh = make(chan *downloadTask)
@@ -137,6 +212,7 @@ func (pd *ChunkDownload) ensure() error {
 	pd.taskNotifyCh = make(chan *downloadTask)
 
 	pd.pw = &progressWriter{
+		logger:    pd.scheduler.logger,
 		totalSize: pd.totalBytes,
 		fileName:  pd.filename,
 		startTime: time.Now(),
@@ -144,6 +220,53 @@ func (pd *ChunkDownload) ensure() error {
 	return nil
 }
 
+// SetScheduler 未设置时使用默认环境的调度器
+func (pd *ChunkDownload) SetScheduler(scheduler *DownloadScheduler) *ChunkDownload {
+	pd.scheduler = scheduler
+	return pd
+}
+
+// SetPreallocate 预先创建完整大小的输出文件(稀疏文件)，各分片用WriteAt直接写入，磁盘读写和占用空间都只有一份
+// 已完成的分片记录在输出文件旁的.ranges文件中，中断后重新下载时只下载未完成的分片，设置了SetOutput时无效
+func (pd *ChunkDownload) SetPreallocate(preallocate bool) *ChunkDownload {
+	pd.preallocate = preallocate
+	return pd
+}
+
+func (pd *ChunkDownload) direct() bool {
+	return pd.preallocate && pd.output == nil
+}
+
+// SetUrlRefresher 分片请求返回链接过期时调用，获取新的链接后继续下载剩余的分片，未设置时按普通错误重试
+func (pd *ChunkDownload) SetUrlRefresher(refresher UrlRefresher) *ChunkDownload {
+	pd.refresher = refresher
+	return pd
+}
+
+// SetLinkExpired 未设置时使用DefaultLinkExpired
+func (pd *ChunkDownload) SetLinkExpired(linkExpired LinkExpired) *ChunkDownload {
+	pd.linkExpired = linkExpired
+	return pd
+}
+
+// SetRateLimiters 所有分片的写入依次受这些限速，nil会被忽略
+func (pd *ChunkDownload) SetRateLimiters(limiters ...*RateLimiter) *ChunkDownload {
+	pd.limiters = activeLimiters(limiters)
+	return pd
+}
+
+// SetProgress 上报传输的字节、完成的分片和重试，开始和结束由调用方上报
+func (pd *ChunkDownload) SetProgress(progress *ProgressTracker) *ChunkDownload {
+	pd.progress = progress
+	return pd
+}
+
+func (pd *ChunkDownload) currentUrl() string {
+	pd.urlMu.RLock()
+	defer pd.urlMu.RUnlock()
+	return pd.url
+}
+
 func (pd *ChunkDownload) SetChunkSize(chunkSize int64) *ChunkDownload {
 	pd.chunkSize = chunkSize
 	return pd
@@ -210,70 +333,177 @@ type downloadTask struct {
 	rangeStart, rangeEnd, totalSize int64
 	tempFilename                    string
 	completed                       bool
-	tempFile                        *os.File
 	retry                           int
+	// 因链接过期而重新获取链接的次数，不计入retry
+	refreshed int
+	// 最近一次请求已计入进度的字节，重试时扣除
+	written int64
 }
 
-func (pd *ChunkDownload) handleTask(t *downloadTask, ctx ...context.Context) {
+func (pd *ChunkDownload) handleTask(t *downloadTask) {
 	pd.wg.Add(1)
 	defer pd.wg.Done()
-	if shutdown {
+	if pd.scheduler.IsShutdown() {
 		return
 	}
 	if t.completed {
 		pd.pw.updateDownloaded(t.totalSize)
+		pd.progress.Resume(t.totalSize)
 		pd.completeTask(t)
 		return
 	}
 
-	file, eo := os.OpenFile(t.tempFilename, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
-	if eo != nil {
-		pd.errCh <- eo
-		return
+	var output io.Writer
+	var file *os.File
+	var rw *rangeWriter
+	if pd.direct() {
+		rw = &rangeWriter{pd: pd, offset: t.rangeStart, limit: t.totalSize}
+		output = rw
+	} else {
+		var eo error
+		file, eo = os.OpenFile(t.tempFilename, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+		if eo != nil {
+			pd.fail(eo)
+			return
+		}
+		output = file
 	}
 	cpr := &chunkProgressWriter{
+		logger:    pd.scheduler.logger,
 		startTime: time.Now(),
 		fileName:  t.tempFilename,
 	}
+	// 出错时响应体也会写入output，记录开头用于判断原因
+	hw := &headWriter{Writer: output}
+	pw := &progressCountWriter{Writer: hw, task: t, progress: pd.progress}
+	t.written = 0
+	url := pd.currentUrl()
 	resp, er := pd.client.R().
+		SetContext(pd.ctx).
 		SetHeader("Range", fmt.Sprintf("bytes=%d-%d", t.rangeStart, t.rangeEnd)).
-		SetOutput(file).
+		SetOutput(LimitWriter(pd.ctx, pw, pd.limiters...)).
 		SetDownloadCallback(cpr.downloadCallback).
-		Get(pd.url)
+		Get(url)
+	if file != nil {
+		// output经过包装，req不会关闭文件，所有情况下都在这里关闭
+		if ec := file.Close(); ec != nil && er == nil {
+			er = ec
+		}
+	}
+	if pd.ctx.Err() == nil && resp != nil && resp.Response != nil && resp.IsErrorState() && pd.linkExpired(resp.StatusCode, hw.head) {
+		go pd.refreshUrl(t, url, fmt.Errorf("download url expired, status %d: %s", resp.StatusCode, hw.head))
+		return
+	}
 	if er != nil {
+		if pd.ctx.Err() != nil {
+			pd.fail(pd.ctx.Err())
+			return
+		}
 		go pd.retry(t, er)
 		return
 	}
 	if resp.IsErrorState() {
-		go pd.retry(t, fmt.Errorf("request error: %s", resp.String()))
+		go pd.retry(t, fmt.Errorf("request error, status %d: %s", resp.StatusCode, hw.head))
 		return
 	}
-	t.tempFile = file
+	if rw != nil {
+		// 服务端可能忽略Range返回整个文件，或者提前断开
+		if rw.written != t.totalSize {
+			go pd.retry(t, fmt.Errorf("range %d-%d got %d bytes", t.rangeStart, t.rangeEnd, rw.written))
+			return
+		}
+		if err := pd.markChunk(t.index); err != nil {
+			pd.fail(err)
+			return
+		}
+	}
 	pd.pw.updateDownloading(t.totalSize)
+	pd.progress.Chunk(t.index)
 	pd.completeTask(t)
 }
 
 func (pd *ChunkDownload) retry(t *downloadTask, err error) {
-	if t.retry < Config.Server.DownloadMaxThread {
-		logger.WithError(err).Errorf("task %s exist error:%s", t.tempFilename, err)
+	if t.retry < pd.scheduler.config.DownloadMaxThread {
+		pd.scheduler.logger.WithError(err).Errorf("task %s exist error:%s", t.tempFilename, err)
 		t.retry += 1
-		pd.taskCh <- t
+		pd.progress.Retry(t.index, t.written, err)
+		select {
+		case pd.taskCh <- t:
+		case <-pd.doneCh:
+		}
 	} else {
-		pd.errCh <- err
+		pd.fail(err)
+	}
+}
+
+// refreshUrl 多个分片同时发现过期时只重新获取一次，之后这些分片都用新的链接重新下载
+func (pd *ChunkDownload) refreshUrl(t *downloadTask, expiredUrl string, err error) {
+	if pd.refresher == nil || t.refreshed >= max(pd.scheduler.config.DownloadMaxRetry, 1) {
+		pd.retry(t, err)
+		return
+	}
+	pd.urlMu.Lock()
+	if pd.url == expiredUrl {
+		url, e := pd.refresher(pd.ctx)
+		if e != nil {
+			pd.urlMu.Unlock()
+			pd.fail(fmt.Errorf("refresh download url fail: %w", e))
+			return
+		}
+		pd.url = url
+		pd.scheduler.logger.Infof("download url of %s expired, refreshed", pd.filename)
+	}
+	pd.urlMu.Unlock()
+	t.refreshed += 1
+	pd.progress.Retry(t.index, t.written, err)
+	select {
+	case pd.taskCh <- t:
+	case <-pd.doneCh:
+	}
+}
+
+// headWriter 记录写入内容的开头
+type headWriter struct {
+	io.Writer
+	head []byte
+}
+
+func (w *headWriter) Write(p []byte) (int, error) {
+	if n := 1024 - len(w.head); n > 0 {
+		w.head = append(w.head, p[:min(n, len(p))]...)
 	}
+	return w.Writer.Write(p)
 }
 
-func (pd *ChunkDownload) startWorker(ctx ...context.Context) {
+// progressCountWriter 把写入的字节计入进度，同时记录在分片上用于重试时扣除
+type progressCountWriter struct {
+	io.Writer
+	task     *downloadTask
+	progress *ProgressTracker
+}
+
+func (w *progressCountWriter) Write(p []byte) (int, error) {
+	n, err := w.Writer.Write(p)
+	w.task.written += int64(n)
+	w.progress.Add(int64(n))
+	return n, err
+}
+
+func (pd *ChunkDownload) startWorker() {
 	for {
-		if shutdown {
-			pd.errCh <- errors.New("service is shutdown")
+		if pd.scheduler.IsShutdown() {
+			pd.fail(errors.New("service is shutdown"))
 			return
 		}
 		select {
 		case t := <-pd.taskCh:
-			downloadMaxChan <- struct{}{}
-			pd.handleTask(t, ctx...)
-			<-downloadMaxChan
+			select {
+			case pd.scheduler.sem <- struct{}{}:
+			case <-pd.doneCh:
+				return
+			}
+			pd.handleTask(t)
+			<-pd.scheduler.sem
 		case <-pd.doneCh:
 			return
 		}
@@ -284,23 +514,26 @@ func (pd *ChunkDownload) mergeFile() {
 	defer pd.wg.Done()
 	file, err := pd.getOutputFile()
 	if err != nil {
-		pd.errCh <- err
+		pd.fail(err)
 		return
 	}
 	for i := 0; ; i++ {
-		if shutdown {
+		if pd.scheduler.IsShutdown() {
 			return
 		}
 		task := pd.popTask(i)
+		if task == nil {
+			return
+		}
 		tempFile, eo := os.Open(task.tempFilename)
 		if eo != nil {
-			pd.errCh <- eo
+			pd.fail(eo)
 			return
 		}
 		_, eo = io.Copy(file, tempFile)
 		tempFile.Close()
 		if eo != nil {
-			pd.errCh <- eo
+			pd.fail(eo)
 			return
 		}
 		// 合并完成则进行移除
@@ -313,31 +546,39 @@ func (pd *ChunkDownload) mergeFile() {
 
 	err = os.RemoveAll(pd.tempDir)
 	if err != nil {
-		pd.errCh <- err
-	}
-}
-
-func (pd *ChunkDownload) interruptSignalWaiter() {
-	select {
-	case <-ChunkExitChan:
-		shutdown = true
+		pd.fail(err)
 	}
 }
 
+// Do 开始下载，ctx取消时会中断所有分片请求并返回ctx的错误
 func (pd *ChunkDownload) Do(ctx ...context.Context) error {
-	if shutdown {
+	if pd.scheduler == nil {
+		pd.scheduler = DefaultEnv().Scheduler
+	}
+	if pd.scheduler.IsShutdown() {
 		return errors.New("service is shutdown")
 	}
+	if pd.linkExpired == nil {
+		pd.linkExpired = DefaultLinkExpired
+	}
+	pd.ctx = context.Background()
+	if len(ctx) > 0 && ctx[0] != nil {
+		pd.ctx = ctx[0]
+	}
 
 	err := pd.ensure()
 	if err != nil {
 		return err
 	}
+	if pd.output == nil {
+		// 合并和计算分片都会用到，在启动协程前确定
+		pd.filename = calFileName(pd.filename, pd.outputDirectory, pd.url)
+	}
 	for i := 0; i < pd.concurrency; i++ {
-		go pd.startWorker(ctx...)
+		go pd.startWorker()
 	}
 	if pd.totalBytes == 0 {
-		resp := pd.client.Head(pd.url).Do(ctx...)
+		resp := pd.client.Head(pd.url).Do(pd.ctx)
 		if resp.Err != nil {
 			return resp.Err
 		}
@@ -345,12 +586,23 @@ func (pd *ChunkDownload) Do(ctx ...context.Context) error {
 			return fmt.Errorf("bad content length: %d", resp.ContentLength)
 		}
 		pd.totalBytes = resp.ContentLength
+		pd.progress.SetTotal(pd.totalBytes)
+	}
+	if pd.direct() {
+		if err = pd.prepareFile(); err != nil {
+			return err
+		}
 	}
 
-	runningMap[pd] = true
+	pd.scheduler.start(pd)
+	defer pd.scheduler.done(pd)
 
 	pd.wg.Add(1)
-	go pd.mergeFile()
+	if pd.direct() {
+		go pd.waitChunks()
+	} else {
+		go pd.mergeFile()
+	}
 	go func() {
 		pd.wg.Wait()
 		close(pd.wgDoneCh)
@@ -361,14 +613,140 @@ func (pd *ChunkDownload) Do(ctx ...context.Context) error {
 	select {
 	case <-pd.wgDoneCh:
 		close(pd.doneCh)
-		delete(runningMap, pd)
 	case err := <-pd.errCh:
-		delete(runningMap, pd)
+		close(pd.doneCh)
+		pd.closeFile()
+		return err
+	case <-pd.ctx.Done():
+		close(pd.doneCh)
+		pd.closeFile()
+		return pd.ctx.Err()
+	}
+	return nil
+}
+
+// prepareFile 打开或创建输出文件和分片记录，并把输出文件扩展到完整大小
+func (pd *ChunkDownload) prepareFile() error {
+	if err := os.MkdirAll(filepath.Dir(pd.filename), os.ModePerm); err != nil {
+		return err
+	}
+	fileInfo, err := IsExistFile(pd.filename)
+	if err != nil {
+		return err
+	}
+	pending := DownloadPending(pd.filename)
+	bitmap, resumed, err := openRangeBitmap(pd.filename+DownloadBitmapSuffix, pd.totalBytes, pd.chunkSize)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(pd.filename, os.O_RDWR|os.O_CREATE, os.ModePerm)
+	if err != nil {
+		_ = bitmap.close()
+		return err
+	}
+	if !resumed && fileInfo != nil {
+		if !pending && fileInfo.Size() <= pd.totalBytes {
+			// 没有分片记录时与临时文件模式一致，已有的文件视为已下载的部分
+			for i := 0; i < bitmap.chunks; i++ {
+				if int64(i+1)*pd.chunkSize <= fileInfo.Size() || fileInfo.Size() == pd.totalBytes {
+					err = bitmap.set(i)
+				}
+			}
+		} else {
+			// 记录与当前下载不一致，已写入的内容不可信
+			err = file.Truncate(0)
+		}
+	}
+	// 扩展为稀疏文件，不实际占用磁盘空间
+	if err == nil {
+		err = file.Truncate(pd.totalBytes)
+	}
+	if err != nil {
+		_ = file.Close()
+		_ = bitmap.close()
 		return err
 	}
+	pd.file = file
+	pd.bitmap = bitmap
 	return nil
 }
 
+// markChunk 分片落盘后再记录为已完成
+func (pd *ChunkDownload) markChunk(index int) error {
+	pd.fileMu.RLock()
+	defer pd.fileMu.RUnlock()
+	if pd.file == nil {
+		return os.ErrClosed
+	}
+	if err := pd.file.Sync(); err != nil {
+		return err
+	}
+	return pd.bitmap.set(index)
+}
+
+// closeFile 下载中断时关闭文件，保留分片记录用于续传，之后仍在进行的分片写入会失败
+func (pd *ChunkDownload) closeFile() {
+	pd.fileMu.Lock()
+	defer pd.fileMu.Unlock()
+	if pd.file == nil {
+		return
+	}
+	_ = pd.file.Close()
+	_ = pd.bitmap.close()
+	pd.file = nil
+}
+
+// waitChunks 预分配模式下没有合并，等待所有分片完成后删除分片记录
+func (pd *ChunkDownload) waitChunks() {
+	defer pd.wg.Done()
+	for i := 0; ; i++ {
+		if pd.scheduler.IsShutdown() {
+			return
+		}
+		if task := pd.popTask(i); task == nil {
+			return
+		}
+		if i >= pd.lastIndex {
+			break
+		}
+	}
+	pd.fileMu.Lock()
+	defer pd.fileMu.Unlock()
+	if pd.file == nil {
+		return
+	}
+	err := pd.file.Close()
+	pd.file = nil
+	if err == nil {
+		err = pd.bitmap.remove()
+	}
+	if err != nil {
+		pd.fail(err)
+	}
+}
+
+// rangeWriter 把分片的响应写入输出文件的对应位置，超出分片大小时报错
+type rangeWriter struct {
+	pd      *ChunkDownload
+	offset  int64
+	limit   int64
+	written int64
+}
+
+func (w *rangeWriter) Write(p []byte) (int, error) {
+	if w.written+int64(len(p)) > w.limit {
+		return 0, fmt.Errorf("response is longer than range size %d", w.limit)
+	}
+	w.pd.fileMu.RLock()
+	defer w.pd.fileMu.RUnlock()
+	if w.pd.file == nil {
+		return 0, os.ErrClosed
+	}
+	n, err := w.pd.file.WriteAt(p, w.offset+w.written)
+	w.written += int64(n)
+	return n, err
+}
+
 type Range struct {
 	start     int64
 	end       int64
@@ -377,14 +755,20 @@ type Range struct {
 }
 
 func (pd *ChunkDownload) calTask() {
-	ranges, err := pd.CalRange()
+	var ranges []Range
+	var err error
+	if pd.direct() {
+		ranges = pd.calDirectRange()
+	} else {
+		ranges, err = pd.CalRange()
+	}
 	if err != nil {
-		pd.errCh <- err
+		pd.fail(err)
 		return
 	}
 	pd.lastIndex = len(ranges) - 1
 	for i, r := range ranges {
-		if shutdown {
+		if pd.scheduler.IsShutdown() {
 			break
 		}
 		task := &downloadTask{
@@ -395,7 +779,11 @@ func (pd *ChunkDownload) calTask() {
 			completed:    r.completed,
 			totalSize:    r.end - r.start + 1,
 		}
-		pd.taskCh <- task
+		select {
+		case pd.taskCh <- task:
+		case <-pd.doneCh:
+			return
+		}
 	}
 }
 
@@ -432,7 +820,7 @@ func (pd *ChunkDownload) CalRange() ([]Range, error) {
 	var start int64 = 0
 	// 由于合并后会移除临时文件，所以判断文件是否存在，用其大小作为开始下载的分片
 	if pd.output == nil {
-		fileInfo, _ := IsExistFile(calFileName(pd.filename, pd.outputDirectory, pd.url))
+		fileInfo, _ := IsExistFile(pd.filename)
 		if fileInfo != nil {
 			start = fileInfo.Size()
 		}
@@ -456,6 +844,22 @@ func (pd *ChunkDownload) CalRange() ([]Range, error) {
 	return ranges, nil
 }
 
+// calDirectRange 按分片记录划分，每个分片的下标与记录中的位一一对应
+func (pd *ChunkDownload) calDirectRange() []Range {
+	ranges := make([]Range, 0, pd.bitmap.chunks)
+	for i := 0; i < pd.bitmap.chunks; i++ {
+		start := int64(i) * pd.chunkSize
+		end := min(start+pd.chunkSize, pd.totalBytes) - 1
+		ranges = append(ranges, Range{
+			start:     start,
+			end:       end,
+			completed: pd.bitmap.isSet(i),
+			fileName:  fmt.Sprintf("%s[%d-%d]", pd.filename, start, end),
+		})
+	}
+	return ranges
+}
+
 func (pd *ChunkDownload) addRange(start int64, maxEnd int64, ranges []Range) (int64, []Range) {
 	for {
 		end := start + (pd.chunkSize - 1)
@@ -478,7 +882,6 @@ func (pd *ChunkDownload) getOutputFile() (io.Writer, error) {
 	if outputFile != nil {
 		return outputFile, nil
 	}
-	pd.filename = calFileName(pd.filename, pd.outputDirectory, pd.url)
 	err := os.MkdirAll(filepath.Dir(pd.filename), os.ModePerm)
 	if err != nil {
 		return nil, err
@@ -511,6 +914,7 @@ func calFileName(filename, outputDirectory, url string) string {
 }
 
 type progressWriter struct {
+	logger         *logrus.Logger
 	downloaded     int64
 	thisDownloaded int64
 	totalSize      int64
@@ -535,10 +939,11 @@ func (p *progressWriter) updateDownloaded(downloaded int64) {
 }
 
 func (p *progressWriter) log() {
-	LogProgress("downloading", p.fileName, p.startTime, p.thisDownloaded, p.downloaded, p.totalSize, true)
+	LogProgress(p.logger, "downloading", p.fileName, p.startTime, p.thisDownloaded, p.downloaded, p.totalSize, true)
 }
 
 type chunkProgressWriter struct {
+	logger     *logrus.Logger
 	downloaded int64
 	totalSize  int64
 	startTime  time.Time
@@ -546,7 +951,7 @@ type chunkProgressWriter struct {
 }
 
 func (c *chunkProgressWriter) log() {
-	LogProgress("downloading", c.fileName, c.startTime, c.downloaded, c.downloaded, c.totalSize, false)
+	LogProgress(c.logger, "downloading", c.fileName, c.startTime, c.downloaded, c.downloaded, c.totalSize, false)
 }
 
 func (c *chunkProgressWriter) downloadCallback(info req.DownloadInfo) {
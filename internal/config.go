@@ -1,19 +1,35 @@
 package internal
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/spf13/viper"
+	"io/fs"
 	"reflect"
 	"strconv"
+	"strings"
 )
 
 type ServerConfig struct {
 	Debug             bool   `mapstructure:"debug" json:"debug" yaml:"debug" default:"false"`
 	CacheFile         string `mapstructure:"cache_file" json:"cache_file"  yaml:"cache_file" default:"cache.dat"`
 	DownloadTmpPath   string `mapstructure:"download_tmp_path" json:"download_tmp_path"  yaml:"download_tmp_path"  default:"./download_tmp"`
+	UploadTmpPath     string `mapstructure:"upload_tmp_path" json:"upload_tmp_path"  yaml:"upload_tmp_path"  default:"./upload_tmp"`
 	DownloadMaxThread int    `mapstructure:"download_max_thread" json:"download_max_thread"  yaml:"download_max_thread"  default:"50"`
 	DownloadMaxRetry  int    `mapstructure:"download_max_retry" json:"download_max_retry"  yaml:"download_max_retry"  default:"3"`
+	// 分片直接写入预分配的输出文件，不使用临时文件，大文件可以省一半的磁盘读写和空间
+	DownloadPreallocate bool `mapstructure:"download_preallocate" json:"download_preallocate" yaml:"download_preallocate" default:"false"`
+	// 所有下载完成后都按网盘返回的hash校验，不一致时重新下载一次，需要再读一遍文件，默认只校验请求了VerifyHash的
+	DownloadVerifyHash bool `mapstructure:"download_verify_hash" json:"download_verify_hash" yaml:"download_verify_hash" default:"false"`
+	// 全局下载限速，单位字节/秒，0为不限速
+	DownloadRateLimit int64 `mapstructure:"download_rate_limit" json:"download_rate_limit" yaml:"download_rate_limit" default:"0"`
+	// 全局上传限速，单位字节/秒，0为不限速
+	UploadRateLimit int64 `mapstructure:"upload_rate_limit" json:"upload_rate_limit" yaml:"upload_rate_limit" default:"0"`
+	// 加密凭证的密钥文件，为空且没有设置环境变量时凭证以明文保存
+	SecretKeyFile string `mapstructure:"secret_key_file" json:"secret_key_file" yaml:"secret_key_file"`
+	// 监听配置存储的变化并重新加载已经初始化的实例
+	WatchConfig bool `mapstructure:"watch_config" json:"watch_config" yaml:"watch_config" default:"false"`
 }
 
 type LogConfig struct {
@@ -30,25 +46,23 @@ type RootConfig struct {
 	Log    *LogConfig    `mapstructure:"log" json:"log" yaml:"log"`
 }
 
-var Config RootConfig
-var Viper *viper.Viper
-
-func InitConfig() {
+// loadDefaultConfig 旧用法的配置：从运行目录或当前目录读取pan-client.yaml，不存在时在当前目录生成
+func loadDefaultConfig() (*viper.Viper, *RootConfig) {
 	configName := "pan-client"
+	config := &RootConfig{}
 	// 添加运行目录
 	v := viper.New()
-	Viper = v
 	v.AddConfigPath(GetProcessPath())
 
 	// 添加当前目录
 	v.AddConfigPath(GetWorkPath())
 	v.SetConfigName(configName)
-	SetDefaultByTag(&Config)
+	SetDefaultByTag(config)
 	if err := v.ReadInConfig(); err != nil { // 读取配置文件
 		// 使用类型断言检查是否为 *os.PathError 类型
 		var pathErr viper.ConfigFileNotFoundError
 		if errors.As(err, &pathErr) {
-			val := reflect.ValueOf(Config)
+			val := reflect.ValueOf(*config)
 			for i := 0; i < val.NumField(); i++ {
 				// 获取字段名
 				name := val.Type().Field(i).Tag.Get("mapstructure")
@@ -68,9 +82,33 @@ func InitConfig() {
 		}
 	}
 
-	if err := v.Unmarshal(&Config); err != nil { // 解码配置文件到结构体
+	if err := v.Unmarshal(config); err != nil { // 解码配置文件到结构体
 		panic(err)
 	}
+	return v, config
+}
+
+// loadConfig 库模式的配置：configFile为空时只在内存中，settings的结构与配置文件相同
+func loadConfig(configFile string, settings map[string]interface{}) (*viper.Viper, *RootConfig, error) {
+	config := &RootConfig{}
+	SetDefaultByTag(config)
+	v := viper.New()
+	if configFile != "" {
+		v.SetConfigFile(configFile)
+		// 文件不存在时在第一次写配置时创建
+		if err := v.ReadInConfig(); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, nil, err
+		}
+	}
+	if settings != nil {
+		if err := v.MergeConfigMap(settings); err != nil {
+			return nil, nil, err
+		}
+	}
+	if err := v.Unmarshal(config); err != nil {
+		return nil, nil, err
+	}
+	return v, config, nil
 }
 
 // SetDefaultByTag 根据结构体字段的tag设置默认值，包括嵌套对象和指针
@@ -117,33 +155,36 @@ func setDefaults(v reflect.Value) {
 
 		// 如果有默认值，则设置
 		if defaultValue != "" {
-			switch field.Kind() {
-			case reflect.String:
-				field.SetString(defaultValue)
-			case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-				val, err := strconv.ParseInt(defaultValue, 10, 64)
-				if err == nil {
-					field.SetInt(val)
-				}
-			case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
-				val, err := strconv.ParseUint(defaultValue, 10, 64)
-				if err == nil {
-					field.SetUint(val)
-				}
-			case reflect.Float32, reflect.Float64:
-				val, err := strconv.ParseFloat(defaultValue, 64)
-				if err == nil {
-					field.SetFloat(val)
-				}
-			case reflect.Bool:
-				val, err := strconv.ParseBool(defaultValue)
-				if err == nil {
-					field.SetBool(val)
-				}
-			default:
-				// 其他类型暂不支持
-				fmt.Printf("Unsupported type for field %s\n", v.Type().Field(i).Name)
+			// 解析失败时保持零值
+			if value, err := parseDefault(field.Type(), defaultValue); err == nil {
+				field.Set(reflect.ValueOf(value).Convert(field.Type()))
+			}
+		}
+	}
+}
+
+// parseDefault 把default标签的值转为字段的类型，切片和map使用json格式，字符串切片也可以用,分隔
+func parseDefault(t reflect.Type, defaultValue string) (interface{}, error) {
+	switch t.Kind() {
+	case reflect.String:
+		return defaultValue, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.ParseInt(defaultValue, 10, 64)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.ParseUint(defaultValue, 10, 64)
+	case reflect.Float32, reflect.Float64:
+		return strconv.ParseFloat(defaultValue, 64)
+	case reflect.Bool:
+		return strconv.ParseBool(defaultValue)
+	case reflect.Slice, reflect.Map:
+		ptr := reflect.New(t)
+		if err := json.Unmarshal([]byte(defaultValue), ptr.Interface()); err != nil {
+			if t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.String {
+				return strings.Split(defaultValue, ","), nil
 			}
+			return nil, err
 		}
+		return ptr.Elem().Interface(), nil
 	}
+	return nil, fmt.Errorf("unsupported default value type %s", t)
 }
@@ -0,0 +1,394 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"github.com/mitchellh/mapstructure"
+	"github.com/spf13/viper"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// ConfigStore 驱动配置的存储，key为以.分隔的路径，如driver.quark，实现需要并发安全
+type ConfigStore interface {
+	// Load 把key对应的配置解码到out，不存在时不修改out
+	Load(key string, out interface{}) error
+	// Save 保存key对应的配置
+	Save(key string, value interface{}) error
+	// Delete 删除key对应的配置，不存在时忽略
+	Delete(key string) error
+}
+
+// DecodeSettings 与viper的Unmarshal相同的解码方式
+func DecodeSettings(input interface{}, out interface{}) error {
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		Result:           out,
+		WeaklyTypedInput: true,
+		DecodeHook: mapstructure.ComposeDecodeHookFunc(
+			mapstructure.StringToTimeDurationHookFunc(),
+			mapstructure.StringToSliceHookFunc(","),
+		),
+	})
+	if err != nil {
+		return err
+	}
+	return decoder.Decode(input)
+}
+
+// ToSettings 按mapstructure的tag把结构体转为map，切片和map中的结构体也会转换
+// 保存时各种格式的字段名都与读取时一致，也避免保存的是调用方的指针
+func ToSettings(value interface{}) (interface{}, error) {
+	rv := reflect.ValueOf(value)
+	for rv.Kind() == reflect.Ptr || rv.Kind() == reflect.Interface {
+		if rv.IsNil() {
+			return nil, nil
+		}
+		rv = rv.Elem()
+	}
+	switch rv.Kind() {
+	case reflect.Struct:
+		settings := make(map[string]interface{})
+		if err := mapstructure.Decode(rv.Interface(), &settings); err != nil {
+			return nil, err
+		}
+		return settings, nil
+	case reflect.Slice, reflect.Array:
+		if rv.Type().Elem().Kind() == reflect.Uint8 {
+			return rv.Interface(), nil
+		}
+		list := make([]interface{}, rv.Len())
+		for i := range list {
+			item, err := ToSettings(rv.Index(i).Interface())
+			if err != nil {
+				return nil, err
+			}
+			list[i] = item
+		}
+		return list, nil
+	case reflect.Map:
+		if rv.Type().Key().Kind() != reflect.String {
+			return rv.Interface(), nil
+		}
+		settings := make(map[string]interface{}, rv.Len())
+		iter := rv.MapRange()
+		for iter.Next() {
+			item, err := ToSettings(iter.Value().Interface())
+			if err != nil {
+				return nil, err
+			}
+			settings[iter.Key().String()] = item
+		}
+		return settings, nil
+	default:
+		return rv.Interface(), nil
+	}
+}
+
+// writeConfigAtomic 先写入同目录的临时文件再重命名，写到一半退出也不会损坏原文件
+func writeConfigAtomic(v *viper.Viper, filename string) error {
+	dir := filepath.Dir(filename)
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return err
+	}
+	// 保留后缀，viper按后缀决定格式
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(filename)+".*"+filepath.Ext(filename))
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+	_ = tmp.Close()
+	if err = v.WriteConfigAs(tmpName); err != nil {
+		_ = os.Remove(tmpName)
+		return err
+	}
+	if err = os.Rename(tmpName, filename); err != nil {
+		_ = os.Remove(tmpName)
+		return err
+	}
+	return nil
+}
+
+// FileStore 单个配置文件，格式由后缀决定，支持yaml、json、toml等，没有文件时只保存在内存中
+type FileStore struct {
+	mu sync.RWMutex
+	v  *viper.Viper
+}
+
+// NewFileStore 文件不存在时在第一次保存时创建
+func NewFileStore(filename string) (*FileStore, error) {
+	v := viper.New()
+	v.SetConfigFile(filename)
+	if err := v.ReadInConfig(); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	return &FileStore{v: v}, nil
+}
+
+func newFileStore(v *viper.Viper) *FileStore {
+	return &FileStore{v: v}
+}
+
+func (s *FileStore) Load(key string, out interface{}) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	// viper的Get在Set过子key后只返回Set的部分，所以从合并后的配置中读取
+	value, ok := lookupSettings(s.v.AllSettings(), strings.ToLower(key))
+	if !ok {
+		return nil
+	}
+	return DecodeSettings(value, out)
+}
+
+func (s *FileStore) Save(key string, value interface{}) error {
+	settings, err := ToSettings(value)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// viper的Set会与原有的配置合并，无法整个替换，只能修改后重建
+	all := s.v.AllSettings()
+	setSettings(all, strings.ToLower(key), settings)
+	return s.rebuild(all)
+}
+
+// Delete viper不支持删除key，只能去掉后重建再写回配置文件
+func (s *FileStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	settings := s.v.AllSettings()
+	if !deleteSettings(settings, strings.ToLower(key)) {
+		return nil
+	}
+	return s.rebuild(settings)
+}
+
+func (s *FileStore) rebuild(settings map[string]interface{}) error {
+	v := viper.New()
+	v.SetConfigFile(s.v.ConfigFileUsed())
+	if err := v.MergeConfigMap(settings); err != nil {
+		return err
+	}
+	if err := s.write(v); err != nil {
+		return err
+	}
+	s.v = v
+	return nil
+}
+
+func (s *FileStore) write(v *viper.Viper) error {
+	if v.ConfigFileUsed() == "" {
+		return nil
+	}
+	return writeConfigAtomic(v, v.ConfigFileUsed())
+}
+
+// MemoryStore 只保存在内存中，settings的结构与配置文件相同
+type MemoryStore struct {
+	mu       sync.RWMutex
+	settings map[string]interface{}
+}
+
+func NewMemoryStore(settings map[string]interface{}) *MemoryStore {
+	if settings == nil {
+		settings = make(map[string]interface{})
+	}
+	return &MemoryStore{settings: settings}
+}
+
+func (s *MemoryStore) Load(key string, out interface{}) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	value, ok := lookupSettings(s.settings, key)
+	if !ok {
+		return nil
+	}
+	return DecodeSettings(value, out)
+}
+
+func (s *MemoryStore) Save(key string, value interface{}) error {
+	settings, err := ToSettings(value)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	setSettings(s.settings, key, settings)
+	return nil
+}
+
+func (s *MemoryStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	deleteSettings(s.settings, key)
+	return nil
+}
+
+func (s *MemoryStore) has(key string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, ok := lookupSettings(s.settings, key)
+	return ok
+}
+
+// EnvStore 从环境变量读取，如driver.quark的pus对应PAN_DRIVER_QUARK_PUS
+// 环境变量是只读的，保存的配置(如刷新后的token)只保存在内存中，并优先于环境变量
+type EnvStore struct {
+	prefix string
+	saved  *MemoryStore
+}
+
+// NewEnvStore prefix为空时使用PAN
+func NewEnvStore(prefix string) *EnvStore {
+	if prefix == "" {
+		prefix = "PAN"
+	}
+	return &EnvStore{prefix: strings.ToUpper(prefix), saved: NewMemoryStore(nil)}
+}
+
+func (s *EnvStore) Load(key string, out interface{}) error {
+	if s.saved.has(key) {
+		return s.saved.Load(key, out)
+	}
+	envPrefix := s.prefix + "_" + strings.ToUpper(strings.ReplaceAll(key, ".", "_")) + "_"
+	settings := make(map[string]interface{})
+	for _, kv := range os.Environ() {
+		name, value, ok := strings.Cut(kv, "=")
+		if !ok || !strings.HasPrefix(name, envPrefix) {
+			continue
+		}
+		settings[strings.ToLower(strings.TrimPrefix(name, envPrefix))] = value
+	}
+	if len(settings) == 0 {
+		return nil
+	}
+	return DecodeSettings(settings, out)
+}
+
+func (s *EnvStore) Save(key string, value interface{}) error {
+	return s.saved.Save(key, value)
+}
+
+func (s *EnvStore) Delete(key string) error {
+	return s.saved.Delete(key)
+}
+
+// SplitStore key与key.<子key>分开保存的存储，保存子key不会修改key中的内容，如DirStore
+type SplitStore interface {
+	SplitKeys() bool
+}
+
+// DirStore 每个key一个文件，如<dir>/driver.quark.yaml，保存某个账号时不会重写其他账号的文件
+type DirStore struct {
+	mu  sync.RWMutex
+	dir string
+	ext string
+}
+
+// NewDirStore ext为文件格式，为空时使用yaml
+func NewDirStore(dir, ext string) *DirStore {
+	ext = strings.TrimPrefix(ext, ".")
+	if ext == "" {
+		ext = "yaml"
+	}
+	return &DirStore{dir: dir, ext: ext}
+}
+
+func (s *DirStore) SplitKeys() bool {
+	return true
+}
+
+func (s *DirStore) filename(key string) string {
+	return filepath.Join(s.dir, key+"."+s.ext)
+}
+
+func (s *DirStore) Load(key string, out interface{}) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	v := viper.New()
+	v.SetConfigFile(s.filename(key))
+	if err := v.ReadInConfig(); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	return v.Unmarshal(out)
+}
+
+func (s *DirStore) Save(key string, value interface{}) error {
+	settings, err := ToSettings(value)
+	if err != nil {
+		return err
+	}
+	m, ok := settings.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("%s is not an object, can not save to a single file", key)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v := viper.New()
+	if err = v.MergeConfigMap(m); err != nil {
+		return err
+	}
+	return writeConfigAtomic(v, s.filename(key))
+}
+
+func (s *DirStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := os.Remove(s.filename(key)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func lookupSettings(settings map[string]interface{}, key string) (interface{}, bool) {
+	var value interface{} = settings
+	for _, part := range strings.Split(key, ".") {
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if value, ok = m[part]; !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+func setSettings(settings map[string]interface{}, key string, value interface{}) {
+	parts := strings.Split(key, ".")
+	m := settings
+	for _, part := range parts[:len(parts)-1] {
+		next, ok := m[part].(map[string]interface{})
+		if !ok {
+			next = make(map[string]interface{})
+			m[part] = next
+		}
+		m = next
+	}
+	m[parts[len(parts)-1]] = value
+}
+
+// deleteSettings 返回key是否存在
+func deleteSettings(settings map[string]interface{}, key string) bool {
+	parts := strings.Split(key, ".")
+	m := settings
+	for _, part := range parts[:len(parts)-1] {
+		next, ok := m[part].(map[string]interface{})
+		if !ok {
+			return false
+		}
+		m = next
+	}
+	if _, ok := m[parts[len(parts)-1]]; !ok {
+		return false
+	}
+	delete(m, parts[len(parts)-1])
+	return true
+}
@@ -0,0 +1,113 @@
+package internal
+
+import (
+	"errors"
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/viper"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ConfigWatcher 可以监听外部修改的存储，如手动编辑了配置文件
+type ConfigWatcher interface {
+	// Watch 配置变化时调用onChange，返回的stop用于停止监听
+	Watch(onChange func()) (stop func(), err error)
+}
+
+// 编辑器保存时通常会产生多个事件，合并后只通知一次
+const watchDebounce = 200 * time.Millisecond
+
+// watchDir 监听目录下match的文件，监听目录而不是文件，是因为原子写入和很多编辑器会替换掉原文件
+func watchDir(dir string, match func(name string) bool, onChange func()) (func(), error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	if err = watcher.Add(dir); err != nil {
+		_ = watcher.Close()
+		return nil, err
+	}
+	done := make(chan struct{})
+	go func() {
+		var mu sync.Mutex
+		var timer *time.Timer
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				name := filepath.Base(event.Name)
+				// 跳过原子写入时的临时文件
+				if strings.HasPrefix(name, ".") || !match(name) {
+					continue
+				}
+				if !event.Has(fsnotify.Write) && !event.Has(fsnotify.Create) && !event.Has(fsnotify.Rename) && !event.Has(fsnotify.Remove) {
+					continue
+				}
+				mu.Lock()
+				if timer != nil {
+					timer.Stop()
+				}
+				timer = time.AfterFunc(watchDebounce, func() {
+					select {
+					case <-done:
+					default:
+						onChange()
+					}
+				})
+				mu.Unlock()
+			case _, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(done)
+			_ = watcher.Close()
+		})
+	}, nil
+}
+
+// Watch 文件变化时重新读取，读取失败(如编辑器只写了一半)时忽略这次变化
+func (s *FileStore) Watch(onChange func()) (func(), error) {
+	s.mu.RLock()
+	filename := s.v.ConfigFileUsed()
+	s.mu.RUnlock()
+	if filename == "" {
+		return nil, errors.New("config store has no file to watch")
+	}
+	abs, err := filepath.Abs(filename)
+	if err != nil {
+		return nil, err
+	}
+	base := filepath.Base(abs)
+	return watchDir(filepath.Dir(abs), func(name string) bool {
+		return name == base
+	}, func() {
+		v := viper.New()
+		v.SetConfigFile(filename)
+		if err := v.ReadInConfig(); err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.v = v
+		s.mu.Unlock()
+		onChange()
+	})
+}
+
+// Watch DirStore每次读取都直接读文件，只需要通知
+func (s *DirStore) Watch(onChange func()) (func(), error) {
+	return watchDir(s.dir, func(name string) bool {
+		return strings.HasSuffix(name, "."+s.ext)
+	}, onChange)
+}
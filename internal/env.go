@@ -0,0 +1,135 @@
+package internal
+
+import (
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"sync"
+)
+
+// Env 一套独立的运行环境，不同的Env之间配置、日志、缓存和下载调度互不影响
+type Env struct {
+	Config    *RootConfig
+	Viper     *viper.Viper
+	Logger    *logrus.Logger
+	Cache     *MemCache
+	Scheduler *DownloadScheduler
+	// Store 驱动配置的存储
+	Store ConfigStore
+	// Cipher 加密配置中的凭证，为空时不加密
+	Cipher *Cipher
+	// DownloadLimiter、UploadLimiter 所有实例共享的限速
+	DownloadLimiter *RateLimiter
+	UploadLimiter   *RateLimiter
+	// Progress 全局的进度监听，所有实例的上传下载都会通知
+	Progress *ProgressListeners
+	// err 初始化时的错误，不为空时不能读写驱动配置
+	err       error
+	closeOnce sync.Once
+}
+
+type EnvOptions struct {
+	// 配置文件，为空时配置只保存在内存中
+	ConfigFile string
+	// 初始配置，结构与配置文件相同，会覆盖配置文件中的同名配置
+	Settings map[string]interface{}
+	// 为空时新建一个logrus.Logger，级别由server.debug决定
+	Logger *logrus.Logger
+	// 缓存持久化文件，为空时缓存只保存在内存中
+	CacheFile string
+	// 驱动配置的存储，为空时驱动配置与其他配置一起保存在ConfigFile中
+	Store ConfigStore
+	// 加密凭证的密钥，为空时从环境变量或server.secret_key_file读取
+	SecretKey string
+}
+
+func NewEnv(opts EnvOptions) (*Env, error) {
+	v, config, err := loadConfig(opts.ConfigFile, opts.Settings)
+	if err != nil {
+		return nil, err
+	}
+	logger := opts.Logger
+	if logger == nil {
+		logger = logrus.New()
+		setLog(logger, config)
+	}
+	store := opts.Store
+	if store == nil {
+		store = newFileStore(v)
+	}
+	var secret *Cipher
+	if opts.SecretKey != "" {
+		secret, err = NewCipher(opts.SecretKey)
+	} else {
+		secret, err = LoadCipher(config.Server.SecretKeyFile)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Env{
+		Config:    config,
+		Viper:     v,
+		Logger:    logger,
+		Cache:     NewMemCache(opts.CacheFile, logger),
+		Scheduler: NewDownloadScheduler(config.Server, logger),
+		Store:     store,
+		Cipher:    secret,
+
+		DownloadLimiter: NewRateLimiter(config.Server.DownloadRateLimit),
+		UploadLimiter:   NewRateLimiter(config.Server.UploadRateLimit),
+		Progress:        NewProgressListeners(),
+	}, nil
+}
+
+// SetRateLimit 修改全局的上传下载限速，单位字节/秒，0为不限速，对正在进行的传输也生效
+func (e *Env) SetRateLimit(download, upload int64) {
+	e.Config.Server.DownloadRateLimit = download
+	e.Config.Server.UploadRateLimit = upload
+	e.DownloadLimiter.SetLimit(download)
+	e.UploadLimiter.SetLimit(upload)
+}
+
+// Err 初始化时的错误，如旧用法的全局环境读取密钥失败
+func (e *Env) Err() error {
+	return e.err
+}
+
+// Close 等待正在进行的下载结束并保存缓存，可重复调用
+func (e *Env) Close() {
+	e.closeOnce.Do(func() {
+		e.Scheduler.Shutdown()
+		e.Cache.Save()
+	})
+}
+
+var defaultEnv *Env
+var defaultEnvOnce sync.Once
+
+// DefaultEnv 旧用法的全局环境，第一次使用时才读取运行目录下的配置文件并初始化全局logrus
+// 读取密钥失败时错误保存在Err中，由获取驱动和读取配置时返回
+func DefaultEnv() *Env {
+	defaultEnvOnce.Do(func() {
+		v, config := loadDefaultConfig()
+		secret, err := LoadCipher(config.Server.SecretKeyFile)
+		logger := logrus.StandardLogger()
+		initLog(logger, config)
+		cacheFile := ""
+		if config.Server.CacheFile != "" {
+			cacheFile = GetProcessPath() + "/" + config.Server.CacheFile
+		}
+		defaultEnv = &Env{
+			Config:    config,
+			Viper:     v,
+			Logger:    logger,
+			Cache:     NewMemCache(cacheFile, logger),
+			Scheduler: NewDownloadScheduler(config.Server, logger),
+			Store:     newFileStore(v),
+			Cipher:    secret,
+
+			DownloadLimiter: NewRateLimiter(config.Server.DownloadRateLimit),
+			UploadLimiter:   NewRateLimiter(config.Server.UploadRateLimit),
+			Progress:        NewProgressListeners(),
+			err:             err,
+		}
+	})
+	return defaultEnv
+}
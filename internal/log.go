@@ -8,20 +8,21 @@ import (
 	"path/filepath"
 )
 
-func InitLog() {
+// initLog 旧用法的日志：设置全局logrus，按配置输出到文件
+func initLog(l *logrus.Logger, config *RootConfig) {
 	formatter := logrus.TextFormatter{
 		ForceColors:               true,
 		EnvironmentOverrideColors: true,
 		TimestampFormat:           "2006-01-02 15:04:05",
 		FullTimestamp:             true,
 	}
-	logrus.SetFormatter(&formatter)
-	setLog(logrus.StandardLogger())
-	logConfig := Config.Log
+	l.SetFormatter(&formatter)
+	setLog(l, config)
+	logConfig := config.Log
 	if logConfig.Enable {
 		process, _ := os.Executable()
 		LogBaseDir := filepath.Dir(process)
-		if Config.Server.Debug {
+		if config.Server.Debug {
 			LogBaseDir, _ = os.Getwd()
 		}
 		var w io.Writer = &lumberjack.Logger{
@@ -32,12 +33,12 @@ func InitLog() {
 			Compress:   logConfig.Compress, // disabled by default
 		}
 		w = io.MultiWriter(os.Stdout, w)
-		logrus.SetOutput(w)
+		l.SetOutput(w)
 	}
 }
 
-func setLog(l *logrus.Logger) {
-	if Config.Server.Debug {
+func setLog(l *logrus.Logger, config *RootConfig) {
+	if config.Server.Debug {
 		l.SetLevel(logrus.DebugLevel)
 		l.SetReportCaller(true)
 	} else {
@@ -2,30 +2,22 @@ package internal
 
 import (
 	"github.com/patrickmn/go-cache"
-	logger "github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus"
 	"os"
+	"strings"
 	"time"
 )
 
-var Cache *MemCache
-
 type MemCache struct {
 	*cache.Cache
 	localFilePath string
+	logger        *logrus.Logger
 }
 
-func NewClient(localFile string) *MemCache {
+// NewMemCache localFile为空时不持久化，否则立即加载，Save时写回
+func NewMemCache(localFile string, logger *logrus.Logger) *MemCache {
 	memCache := cache.New(12*time.Hour, 30*time.Minute)
-	// Wait for SIGINT (interrupt) signal.
-	m := &MemCache{Cache: memCache, localFilePath: localFile}
-
-	go func() {
-		select {
-		case <-CacheExitChan:
-			m.save()
-			ExitWaitGroup.Done()
-		}
-	}()
+	m := &MemCache{Cache: memCache, localFilePath: localFile, logger: logger}
 	m.load()
 	return m
 }
@@ -33,32 +25,58 @@ func (m *MemCache) load() {
 	if m.localFilePath != "" {
 		if _, err := os.Stat(m.localFilePath); err != nil {
 			if !os.IsNotExist(err) {
-				logger.Errorf("cache load file %s err: %v", m.localFilePath, err)
+				m.logger.Errorf("cache load file %s err: %v", m.localFilePath, err)
 				return
 			}
 		}
 		err := m.LoadFile(m.localFilePath)
 		if err != nil {
-			logger.Errorf("cache load file %s err: %v", m.localFilePath, err)
+			m.logger.Errorf("cache load file %s err: %v", m.localFilePath, err)
 		}
 	}
 }
 
-func (m *MemCache) save() {
+func (m *MemCache) Save() {
 	if m.localFilePath != "" {
 		m.DeleteExpired()
 		err := m.SaveFile(m.localFilePath)
 		if err != nil {
-			logger.Errorf("cache save file err: %v", err)
+			m.logger.Errorf("cache save file err: %v", err)
+		}
+		m.logger.Infof("cache save file %s success", m.localFilePath)
+	}
+}
+
+// MovePrefix 把oldPrefix开头的key改为newPrefix开头，保留原有的过期时间，返回移动的数量
+func (m *MemCache) MovePrefix(oldPrefix, newPrefix string) int {
+	count := 0
+	now := time.Now().UnixNano()
+	for key, item := range m.Items() {
+		if !strings.HasPrefix(key, oldPrefix) {
+			continue
+		}
+		m.Delete(key)
+		if item.Expiration > 0 && item.Expiration <= now {
+			continue
 		}
-		logger.Infof("cache save file %s success", m.localFilePath)
+		d := cache.NoExpiration
+		if item.Expiration > 0 {
+			d = time.Duration(item.Expiration - now)
+		}
+		m.Set(newPrefix+strings.TrimPrefix(key, oldPrefix), item.Object, d)
+		count++
 	}
+	return count
 }
 
-func InitCache() {
-	localFile := ""
-	if Config.Server.CacheFile != "" {
-		localFile = GetProcessPath() + "/" + Config.Server.CacheFile
+// DeletePrefix 删除prefix开头的key，返回删除的数量
+func (m *MemCache) DeletePrefix(prefix string) int {
+	count := 0
+	for key := range m.Items() {
+		if strings.HasPrefix(key, prefix) {
+			m.Delete(key)
+			count++
+		}
 	}
-	Cache = NewClient(localFile)
+	return count
 }
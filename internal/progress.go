@@ -0,0 +1,291 @@
+package internal
+
+import (
+	"sync"
+	"time"
+)
+
+// ProgressEventType 进度事件的类型
+type ProgressEventType string
+
+const (
+	ProgressStart ProgressEventType = "start"
+	// ProgressBytes 传输了新的字节，频繁时按progressInterval合并
+	ProgressBytes ProgressEventType = "bytes"
+	// ProgressChunk 一个分片传输完成
+	ProgressChunk ProgressEventType = "chunk"
+	// ProgressRetry 分片或整个文件重新传输，已传输的字节会扣除
+	ProgressRetry    ProgressEventType = "retry"
+	ProgressFinished ProgressEventType = "finished"
+	ProgressFailed   ProgressEventType = "failed"
+)
+
+// TransferDirection 传输方向
+type TransferDirection string
+
+const (
+	DirectionUpload   TransferDirection = "upload"
+	DirectionDownload TransferDirection = "download"
+)
+
+// ProgressEvent 传输进度，Bytes包括续传前已完成的部分，Speed只按本次传输的字节计算
+type ProgressEvent struct {
+	Type      ProgressEventType
+	Direction TransferDirection
+	// File 本地文件，上传流时为文件名
+	File string
+	// Remote 网盘中的路径
+	Remote string
+	Bytes  int64
+	Total  int64
+	// Speed 字节/秒
+	Speed float64
+	// ETA 按当前速度剩余的时间，速度为0时为0
+	ETA time.Duration
+	// Chunk 分片序号，chunk和retry事件有效，整个文件重试时为-1
+	Chunk int
+	// Err retry和failed事件的原因
+	Err  error
+	Time time.Time
+}
+
+// ProgressListener 在传输的协程中同步调用，不要阻塞
+type ProgressListener interface {
+	OnProgress(event ProgressEvent)
+}
+
+// ProgressFunc 函数形式的ProgressListener
+type ProgressFunc func(event ProgressEvent)
+
+func (f ProgressFunc) OnProgress(event ProgressEvent) {
+	f(event)
+}
+
+// ProgressListeners 全局的监听器，可以在运行中添加和移除
+type ProgressListeners struct {
+	mu        sync.RWMutex
+	next      int
+	listeners map[int]ProgressListener
+}
+
+func NewProgressListeners() *ProgressListeners {
+	return &ProgressListeners{listeners: make(map[int]ProgressListener)}
+}
+
+// Add 返回的remove用于移除该监听器
+func (p *ProgressListeners) Add(listener ProgressListener) (remove func()) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	id := p.next
+	p.next++
+	p.listeners[id] = listener
+	return func() {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		delete(p.listeners, id)
+	}
+}
+
+func (p *ProgressListeners) OnProgress(event ProgressEvent) {
+	p.mu.RLock()
+	listeners := make([]ProgressListener, 0, len(p.listeners))
+	for _, listener := range p.listeners {
+		listeners = append(listeners, listener)
+	}
+	p.mu.RUnlock()
+	for _, listener := range listeners {
+		listener.OnProgress(event)
+	}
+}
+
+// bytes事件的最小间隔
+const progressInterval = 200 * time.Millisecond
+
+// ProgressTracker 汇总一个文件的传输进度并通知监听器，可以并发调用，nil时不做任何事
+type ProgressTracker struct {
+	mu          sync.Mutex
+	direction   TransferDirection
+	file        string
+	remote      string
+	total       int64
+	done        int64
+	transferred int64
+	startTime   time.Time
+	lastBytes   time.Time
+	ended       bool
+	listeners   []ProgressListener
+}
+
+// NewProgressTracker 没有监听器时返回nil
+func NewProgressTracker(direction TransferDirection, file, remote string, total int64, listeners ...ProgressListener) *ProgressTracker {
+	active := make([]ProgressListener, 0, len(listeners))
+	for _, listener := range listeners {
+		if listener != nil {
+			active = append(active, listener)
+		}
+	}
+	if len(active) == 0 {
+		return nil
+	}
+	return &ProgressTracker{
+		direction: direction,
+		file:      file,
+		remote:    remote,
+		total:     total,
+		startTime: time.Now(),
+		listeners: active,
+	}
+}
+
+// event 需持有锁
+func (p *ProgressTracker) event(eventType ProgressEventType, chunk int, err error) ProgressEvent {
+	now := time.Now()
+	event := ProgressEvent{
+		Type:      eventType,
+		Direction: p.direction,
+		File:      p.file,
+		Remote:    p.remote,
+		Bytes:     p.done,
+		Total:     p.total,
+		Chunk:     chunk,
+		Err:       err,
+		Time:      now,
+	}
+	if elapsed := now.Sub(p.startTime).Seconds(); elapsed > 0 && p.transferred > 0 {
+		event.Speed = float64(p.transferred) / elapsed
+		if left := p.total - p.done; left > 0 {
+			event.ETA = time.Duration(float64(left) / event.Speed * float64(time.Second))
+		}
+	}
+	return event
+}
+
+func (p *ProgressTracker) emit(event ProgressEvent) {
+	for _, listener := range p.listeners {
+		listener.OnProgress(event)
+	}
+}
+
+// SetTotal 开始时不知道大小的，得到大小后设置
+func (p *ProgressTracker) SetTotal(total int64) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	p.total = total
+	p.mu.Unlock()
+}
+
+func (p *ProgressTracker) Start() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	p.startTime = time.Now()
+	event := p.event(ProgressStart, 0, nil)
+	p.mu.Unlock()
+	p.emit(event)
+}
+
+// Add 本次传输的字节
+func (p *ProgressTracker) Add(n int64) {
+	p.add(n, true)
+}
+
+// Resume 续传时之前已完成的字节，不计入速度
+func (p *ProgressTracker) Resume(n int64) {
+	p.add(n, false)
+}
+
+// Move 流从from跳转到to，向后跳过的视为续传，回退的从已传输中扣除
+func (p *ProgressTracker) Move(from, to int64) {
+	p.add(to-from, to < from)
+}
+
+func (p *ProgressTracker) add(n int64, transferred bool) {
+	if p == nil || n == 0 {
+		return
+	}
+	p.mu.Lock()
+	if p.ended {
+		p.mu.Unlock()
+		return
+	}
+	p.done += n
+	if transferred {
+		// 回退时可能超过本次传输的字节
+		p.transferred = max(p.transferred+n, 0)
+	}
+	now := time.Now()
+	if p.done < p.total && now.Sub(p.lastBytes) < progressInterval {
+		p.mu.Unlock()
+		return
+	}
+	p.lastBytes = now
+	event := p.event(ProgressBytes, 0, nil)
+	p.mu.Unlock()
+	p.emit(event)
+}
+
+func (p *ProgressTracker) Chunk(index int) {
+	p.notify(ProgressChunk, index, 0, nil)
+}
+
+// Retry lost为这次失败中已经计入的字节，重新传输时会再次计入
+func (p *ProgressTracker) Retry(index int, lost int64, err error) {
+	p.notify(ProgressRetry, index, lost, err)
+}
+
+func (p *ProgressTracker) notify(eventType ProgressEventType, index int, lost int64, err error) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	if p.ended {
+		p.mu.Unlock()
+		return
+	}
+	p.done -= lost
+	// 整个文件重试时lost可能包括续传的部分
+	p.transferred = max(p.transferred-lost, 0)
+	event := p.event(eventType, index, err)
+	p.mu.Unlock()
+	p.emit(event)
+}
+
+// Finish 跳过或秒传时也视为全部完成
+func (p *ProgressTracker) Finish() {
+	p.end(ProgressFinished, nil)
+}
+
+func (p *ProgressTracker) Fail(err error) {
+	p.end(ProgressFailed, err)
+}
+
+// end finished和failed只通知一次，之后的事件都忽略
+func (p *ProgressTracker) end(eventType ProgressEventType, err error) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	if p.ended {
+		p.mu.Unlock()
+		return
+	}
+	p.ended = true
+	if err == nil && p.total > 0 {
+		p.done = p.total
+	}
+	event := p.event(eventType, 0, err)
+	p.mu.Unlock()
+	p.emit(event)
+}
+
+// Done 按err结束
+func (p *ProgressTracker) Done(err error) {
+	if err != nil {
+		p.Fail(err)
+		return
+	}
+	p.Finish()
+}
@@ -0,0 +1,99 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"sync"
+)
+
+// DownloadBitmapSuffix 预分配下载时记录已完成分片的文件后缀，存在该文件说明下载未完成
+const DownloadBitmapSuffix = ".ranges"
+
+// 文件头：魔数、文件大小、分片大小，与当前下载不一致时重新下载
+var bitmapMagic = []byte("PCRB")
+
+const bitmapHeaderSize = 4 + 8 + 8
+
+// DownloadPending 预分配下载的文件大小一开始就是完整的，需要根据记录文件判断是否下载完成
+func DownloadPending(filename string) bool {
+	info, _ := IsExistFile(filename + DownloadBitmapSuffix)
+	return info != nil
+}
+
+// rangeBitmap 每个分片一位，分片写入并落盘后才置位，崩溃后最多重新下载未置位的分片
+type rangeBitmap struct {
+	mu     sync.Mutex
+	file   *os.File
+	bits   []byte
+	chunks int
+}
+
+func chunkCount(totalBytes, chunkSize int64) int {
+	return int((totalBytes + chunkSize - 1) / chunkSize)
+}
+
+// openRangeBitmap 文件头与totalBytes、chunkSize一致时resumed为true，否则清空重建
+func openRangeBitmap(name string, totalBytes, chunkSize int64) (*rangeBitmap, bool, error) {
+	file, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		return nil, false, err
+	}
+	chunks := chunkCount(totalBytes, chunkSize)
+	header := make([]byte, bitmapHeaderSize)
+	copy(header, bitmapMagic)
+	binary.BigEndian.PutUint64(header[4:], uint64(totalBytes))
+	binary.BigEndian.PutUint64(header[12:], uint64(chunkSize))
+	b := &rangeBitmap{file: file, bits: make([]byte, (chunks+7)/8), chunks: chunks}
+
+	content, err := io.ReadAll(file)
+	if err != nil {
+		_ = file.Close()
+		return nil, false, err
+	}
+	if len(content) == bitmapHeaderSize+len(b.bits) && bytes.Equal(content[:bitmapHeaderSize], header) {
+		copy(b.bits, content[bitmapHeaderSize:])
+		return b, true, nil
+	}
+	if err = file.Truncate(0); err == nil {
+		_, err = file.WriteAt(append(header, b.bits...), 0)
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	if err != nil {
+		_ = file.Close()
+		return nil, false, err
+	}
+	return b, false, nil
+}
+
+func (b *rangeBitmap) isSet(index int) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.bits[index/8]&(1<<(index%8)) != 0
+}
+
+// set 只写入该位所在的字节
+func (b *rangeBitmap) set(index int) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.bits[index/8] |= 1 << (index % 8)
+	_, err := b.file.WriteAt(b.bits[index/8:index/8+1], int64(bitmapHeaderSize+index/8))
+	return err
+}
+
+func (b *rangeBitmap) close() error {
+	return b.file.Close()
+}
+
+// remove 下载完成后删除记录文件
+func (b *rangeBitmap) remove() error {
+	_ = b.file.Close()
+	if err := os.Remove(b.file.Name()); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
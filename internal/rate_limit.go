@@ -0,0 +1,231 @@
+package internal
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// 单次读写等待的最大字节数，限速较低时也能平滑地传输
+const rateLimitPiece = 32 * 1024
+
+// RateLimiter 令牌桶限速，单位为字节/秒，小于等于0时不限速
+// 桶容量为1秒的流量，限速可以在传输过程中修改，nil时不限速
+type RateLimiter struct {
+	mu     sync.Mutex
+	limit  int64
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter limit小于等于0时不限速
+func NewRateLimiter(limit int64) *RateLimiter {
+	return &RateLimiter{limit: limit, last: time.Now()}
+}
+
+// SetLimit 修改限速，对正在进行的传输也生效
+func (l *RateLimiter) SetLimit(limit int64) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.advance(time.Now())
+	l.limit = limit
+	if limit > 0 && l.tokens > float64(limit) {
+		l.tokens = float64(limit)
+	}
+}
+
+// Limit 当前的限速，0为不限速
+func (l *RateLimiter) Limit() int64 {
+	if l == nil {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.limit < 0 {
+		return 0
+	}
+	return l.limit
+}
+
+// advance 按经过的时间补充令牌，不超过桶容量
+func (l *RateLimiter) advance(now time.Time) {
+	if l.limit > 0 {
+		l.tokens += now.Sub(l.last).Seconds() * float64(l.limit)
+		if l.tokens > float64(l.limit) {
+			l.tokens = float64(l.limit)
+		}
+	}
+	l.last = now
+}
+
+// WaitN 取走n个令牌，不够时等待，令牌可以预支，后来的调用者排在后面等待
+func (l *RateLimiter) WaitN(ctx context.Context, n int) error {
+	if l == nil || n <= 0 {
+		return nil
+	}
+	l.mu.Lock()
+	if l.limit <= 0 {
+		l.mu.Unlock()
+		return nil
+	}
+	l.advance(time.Now())
+	l.tokens -= float64(n)
+	var wait time.Duration
+	if l.tokens < 0 {
+		wait = time.Duration(-l.tokens / float64(l.limit) * float64(time.Second))
+	}
+	l.mu.Unlock()
+	if wait <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func waitAll(ctx context.Context, limiters []*RateLimiter, n int) error {
+	for _, limiter := range limiters {
+		if err := limiter.WaitN(ctx, n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// activeLimiters 去掉nil，全部为nil时不需要包装
+func activeLimiters(limiters []*RateLimiter) []*RateLimiter {
+	result := make([]*RateLimiter, 0, len(limiters))
+	for _, limiter := range limiters {
+		if limiter != nil {
+			result = append(result, limiter)
+		}
+	}
+	return result
+}
+
+type limitedReader struct {
+	ctx      context.Context
+	r        io.Reader
+	limiters []*RateLimiter
+	progress *ProgressTracker
+	// pos 当前读取的位置，Seek时据此调整progress
+	pos int64
+}
+
+func (r *limitedReader) Read(p []byte) (int, error) {
+	if len(p) > rateLimitPiece {
+		p = p[:rateLimitPiece]
+	}
+	n, err := r.r.Read(p)
+	if e := waitAll(r.ctx, r.limiters, n); e != nil && err == nil {
+		err = e
+	}
+	r.pos += int64(n)
+	r.progress.Add(int64(n))
+	return n, err
+}
+
+type limitedReadSeeker struct {
+	*limitedReader
+	s io.Seeker
+}
+
+func (r *limitedReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	pos, err := r.s.Seek(offset, whence)
+	if err != nil {
+		return pos, err
+	}
+	r.progress.Move(r.pos, pos)
+	r.pos = pos
+	return pos, nil
+}
+
+type limitedReadCloser struct {
+	*limitedReader
+	c io.Closer
+}
+
+func (r *limitedReadCloser) Close() error {
+	return r.c.Close()
+}
+
+// LimitReader 读取时依次受所有limiter限速，r可以Seek时返回的reader也可以Seek
+func LimitReader(ctx context.Context, r io.Reader, limiters ...*RateLimiter) io.Reader {
+	return TransferReader(ctx, r, nil, limiters...)
+}
+
+// TransferReader 同LimitReader，读取的字节同时计入progress
+func TransferReader(ctx context.Context, r io.Reader, progress *ProgressTracker, limiters ...*RateLimiter) io.Reader {
+	limiters = activeLimiters(limiters)
+	if len(limiters) == 0 && progress == nil {
+		return r
+	}
+	lr := &limitedReader{ctx: ctx, r: r, limiters: limiters, progress: progress}
+	if s, ok := r.(io.Seeker); ok {
+		// 流可能不是从头开始读
+		lr.pos, _ = s.Seek(0, io.SeekCurrent)
+		return &limitedReadSeeker{limitedReader: lr, s: s}
+	}
+	return lr
+}
+
+// LimitReadCloser 同LimitReader，关闭时关闭rc
+func LimitReadCloser(ctx context.Context, rc io.ReadCloser, limiters ...*RateLimiter) io.ReadCloser {
+	limiters = activeLimiters(limiters)
+	if len(limiters) == 0 {
+		return rc
+	}
+	return &limitedReadCloser{limitedReader: &limitedReader{ctx: ctx, r: rc, limiters: limiters}, c: rc}
+}
+
+type limitedWriter struct {
+	ctx      context.Context
+	w        io.Writer
+	limiters []*RateLimiter
+}
+
+func (w *limitedWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		piece := p
+		if len(piece) > rateLimitPiece {
+			piece = piece[:rateLimitPiece]
+		}
+		if err := waitAll(w.ctx, w.limiters, len(piece)); err != nil {
+			return written, err
+		}
+		n, err := w.w.Write(piece)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// LimitWriter 写入时依次受所有limiter限速
+func LimitWriter(ctx context.Context, w io.Writer, limiters ...*RateLimiter) io.Writer {
+	limiters = activeLimiters(limiters)
+	if len(limiters) == 0 {
+		return w
+	}
+	return &limitedWriter{ctx: ctx, w: w, limiters: limiters}
+}
+
+// TransferLimiter 单次传输的限速，limit小于等于0时返回nil
+func TransferLimiter(limit int64) *RateLimiter {
+	if limit <= 0 {
+		return nil
+	}
+	return NewRateLimiter(limit)
+}
@@ -0,0 +1,152 @@
+package internal
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"os"
+	"reflect"
+	"strings"
+)
+
+const (
+	// SecretKeyEnv 加密凭证的密钥，优先于密钥文件
+	SecretKeyEnv = "PAN_CLIENT_SECRET_KEY"
+	// SecretKeyFileEnv 密钥文件，优先于配置中的server.secret_key_file
+	SecretKeyFileEnv = "PAN_CLIENT_SECRET_KEY_FILE"
+	// 加密后的值的前缀，没有该前缀的按明文处理，兼容旧的配置
+	secretPrefix = "enc:v1:"
+)
+
+var ErrSecretKeyMissing = errors.New("config contains encrypted value but secret key is not set")
+
+// Cipher 使用AES-GCM加密配置中的凭证，为空时不加密
+type Cipher struct {
+	aead cipher.AEAD
+}
+
+// NewCipher key为base64编码的32字节密钥时直接使用，否则使用其sha256作为密钥
+func NewCipher(key string) (*Cipher, error) {
+	key = strings.TrimSpace(key)
+	if key == "" {
+		return nil, errors.New("secret key is empty")
+	}
+	raw, err := base64.StdEncoding.DecodeString(key)
+	if err != nil || len(raw) != 32 {
+		sum := sha256.Sum256([]byte(key))
+		raw = sum[:]
+	}
+	block, err := aes.NewCipher(raw)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Cipher{aead: aead}, nil
+}
+
+// LoadCipher 依次从环境变量PAN_CLIENT_SECRET_KEY、PAN_CLIENT_SECRET_KEY_FILE指定的文件和keyFile读取密钥，都没有时返回nil
+func LoadCipher(keyFile string) (*Cipher, error) {
+	if key := os.Getenv(SecretKeyEnv); key != "" {
+		return NewCipher(key)
+	}
+	if file := os.Getenv(SecretKeyFileEnv); file != "" {
+		keyFile = file
+	}
+	if keyFile == "" {
+		return nil, nil
+	}
+	key, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	return NewCipher(string(key))
+}
+
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, secretPrefix)
+}
+
+// Encrypt c为空时原样返回
+func (c *Cipher) Encrypt(plain string) (string, error) {
+	if c == nil || plain == "" || IsEncrypted(plain) {
+		return plain, nil
+	}
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := c.aead.Seal(nonce, nonce, []byte(plain), nil)
+	return secretPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt 明文原样返回，c为空而值已加密时返回ErrSecretKeyMissing
+func (c *Cipher) Decrypt(value string) (string, error) {
+	if !IsEncrypted(value) {
+		return value, nil
+	}
+	if c == nil {
+		return "", ErrSecretKeyMissing
+	}
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, secretPrefix))
+	if err != nil {
+		return "", err
+	}
+	nonceSize := c.aead.NonceSize()
+	if len(sealed) < nonceSize {
+		return "", errors.New("encrypted value is too short")
+	}
+	plain, err := c.aead.Open(nil, sealed[:nonceSize], sealed[nonceSize:], nil)
+	if err != nil {
+		return "", errors.New("decrypt value fail, secret key may be wrong")
+	}
+	return string(plain), nil
+}
+
+// SecretFields 结构体中带secret:"true"标签的字段在配置中的名字
+func SecretFields(t reflect.Type) []string {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil
+	}
+	fields := make([]string, 0)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Tag.Get("secret") != "true" {
+			continue
+		}
+		name, _, _ := strings.Cut(field.Tag.Get("mapstructure"), ",")
+		if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+		fields = append(fields, name)
+	}
+	return fields
+}
+
+// TransformSecrets 复制一份settings，对其中fields对应的字符串值执行transform
+func TransformSecrets(settings map[string]interface{}, fields []string, transform func(string) (string, error)) (map[string]interface{}, error) {
+	result := make(map[string]interface{}, len(settings))
+	for k, v := range settings {
+		result[k] = v
+	}
+	for _, field := range fields {
+		value, ok := result[field].(string)
+		if !ok || value == "" {
+			continue
+		}
+		transformed, err := transform(value)
+		if err != nil {
+			return nil, err
+		}
+		result[field] = transformed
+	}
+	return result, nil
+}
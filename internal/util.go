@@ -1,10 +1,11 @@
 package internal
 
 import (
+	"context"
 	"crypto/md5"
 	"crypto/sha1"
 	"encoding/hex"
-	logger "github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus"
 	"io"
 	"math/rand"
 	"mime"
@@ -119,6 +120,29 @@ func GetFileGcid(filename string) (string, error) {
 	return gcidStr, nil
 }
 
+// SpoolToTemp 将流写入dir下的临时文件，同时写入writers(一般用于计算hash)，返回的文件已回到开头，由调用方关闭并删除
+func SpoolToTemp(reader io.Reader, dir string, writers ...io.Writer) (*os.File, int64, error) {
+	err := os.MkdirAll(dir, os.ModePerm)
+	if err != nil {
+		return nil, 0, err
+	}
+	file, err := os.CreateTemp(dir, "spool_*.tmp")
+	if err != nil {
+		return nil, 0, err
+	}
+	buffer := make([]byte, 1024*1024) // 1MB buffer
+	written, err := io.CopyBuffer(io.MultiWriter(append([]io.Writer{file}, writers...)...), reader, buffer)
+	if err == nil {
+		_, err = file.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		_ = file.Close()
+		_ = os.Remove(file.Name())
+		return nil, 0, err
+	}
+	return file, written, nil
+}
+
 var extraMimeTypes = map[string]string{
 	".apk": "application/vnd.android.package-archive",
 }
@@ -137,7 +161,8 @@ func GetMimeType(name string) string {
 
 // Log
 
-func LogProgress(prefix, fileName string, startTime time.Time, thisOperated, operated, totalSize int64, mustLog bool) {
+// LogProgress l为空时使用全局的logrus
+func LogProgress(l *logrus.Logger, prefix, fileName string, startTime time.Time, thisOperated, operated, totalSize int64, mustLog bool) {
 	elapsed := time.Since(startTime).Seconds()
 	var speed float64
 	if elapsed == 0 {
@@ -148,11 +173,14 @@ func LogProgress(prefix, fileName string, startTime time.Time, thisOperated, ope
 
 	// 计算进度百分比
 	percent := float64(operated) / float64(totalSize) * 100
-	if Config.Server.Debug {
-		logger.Debugf("%s %s: %.2f%% (%d/%d bytes, %.2f KB/s)", prefix, fileName, percent, operated, totalSize, speed)
+	if l == nil {
+		l = logrus.StandardLogger()
+	}
+	if l.IsLevelEnabled(logrus.DebugLevel) {
+		l.Debugf("%s %s: %.2f%% (%d/%d bytes, %.2f KB/s)", prefix, fileName, percent, operated, totalSize, speed)
 	}
 	if mustLog {
-		logger.Infof("%s %s: %.2f%% (%d/%d bytes, %.2f KB/s)", prefix, fileName, percent, operated, totalSize, speed)
+		l.Infof("%s %s: %.2f%% (%d/%d bytes, %.2f KB/s)", prefix, fileName, percent, operated, totalSize, speed)
 	}
 	if operated == totalSize {
 		// 完成时就重新拿已操作数据来算速度了
@@ -161,10 +189,39 @@ func LogProgress(prefix, fileName string, startTime time.Time, thisOperated, ope
 		} else {
 			speed = float64(operated) / 1024 / elapsed // KB/s
 		}
-		logger.Infof("%s %s: %.2f%% (%d/%d bytes, %.2f KB/s), cost %.2f s", prefix, fileName, percent, operated, totalSize, speed, elapsed)
+		l.Infof("%s %s: %.2f%% (%d/%d bytes, %.2f KB/s), cost %.2f s", prefix, fileName, percent, operated, totalSize, speed, elapsed)
+	}
+}
+
+// SleepContext 休眠指定时间，ctx取消时提前返回
+func SleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
 	}
 }
 
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r *contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
+
+// ContextReader ctx取消后读取返回ctx的错误
+func ContextReader(ctx context.Context, r io.Reader) io.Reader {
+	return &contextReader{ctx: ctx, r: r}
+}
+
 // GenRandomWord 生成一个4位随机字谜
 func GenRandomWord() string {
 	const letters = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
@@ -0,0 +1,280 @@
+package internal
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// 配置结构体通过validate标签声明校验规则，多个规则用,分隔：
+//
+//	required                   不能为空
+//	required_without=<field>   field为空时不能为空，如账号密码和token二选一
+//	url                        http或https的地址
+//	oneof=<a> <b>              只能是其中之一，用空格分隔
+//	min=<n>                    数字不能小于n，字符串长度不能小于n
+//
+// desc标签为字段的说明，用于生成schema
+
+// FieldError 单个字段的校验错误，Field为配置中的字段名
+type FieldError struct {
+	Field string
+	Rule  string
+	Param string
+	Msg   string
+}
+
+func (e FieldError) Error() string {
+	return e.Field + " " + e.Msg
+}
+
+// ValidationError 配置校验不通过，Fields为所有不通过的字段
+type ValidationError struct {
+	// Prefix 配置的位置，如driver.quark
+	Prefix string
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, 0, len(e.Fields))
+	for _, field := range e.Fields {
+		name := field.Field
+		if e.Prefix != "" {
+			name = e.Prefix + "." + name
+		}
+		msgs = append(msgs, name+" "+field.Msg)
+	}
+	return "invalid config: " + strings.Join(msgs, "; ")
+}
+
+type fieldRule struct {
+	name  string
+	param string
+}
+
+func parseRules(tag string) []fieldRule {
+	if tag == "" {
+		return nil
+	}
+	rules := make([]fieldRule, 0)
+	for _, part := range strings.Split(tag, ",") {
+		name, param, _ := strings.Cut(strings.TrimSpace(part), "=")
+		if name != "" {
+			rules = append(rules, fieldRule{name: name, param: param})
+		}
+	}
+	return rules
+}
+
+// fieldName 字段在配置中的名字，与SecretFields一致
+func fieldName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("mapstructure"), ",")
+	if name == "" {
+		name = strings.ToLower(field.Name)
+	}
+	return name
+}
+
+// Validate 按validate标签校验结构体，prefix用于错误信息中标明配置的位置，全部通过时返回nil
+func Validate(obj interface{}, prefix string) error {
+	v := reflect.ValueOf(obj)
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return nil
+	}
+	t := v.Type()
+	names := make(map[string]int, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		names[fieldName(t.Field(i))] = i
+	}
+	fields := make([]FieldError, 0)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		value := v.Field(i)
+		for _, rule := range parseRules(field.Tag.Get("validate")) {
+			msg := checkRule(value, rule, func(name string) (reflect.Value, bool) {
+				index, ok := names[name]
+				if !ok {
+					return reflect.Value{}, false
+				}
+				return v.Field(index), true
+			})
+			if msg != "" {
+				fields = append(fields, FieldError{Field: fieldName(field), Rule: rule.name, Param: rule.param, Msg: msg})
+				// 同一个字段只报第一个错误
+				break
+			}
+		}
+	}
+	if len(fields) == 0 {
+		return nil
+	}
+	return &ValidationError{Prefix: prefix, Fields: fields}
+}
+
+func checkRule(value reflect.Value, rule fieldRule, other func(name string) (reflect.Value, bool)) string {
+	switch rule.name {
+	case "required":
+		if value.IsZero() {
+			return "is required"
+		}
+	case "required_without":
+		if o, ok := other(rule.param); ok && o.IsZero() && value.IsZero() {
+			return "is required when " + rule.param + " is empty"
+		}
+	case "url":
+		// 空值由required校验
+		if value.Kind() != reflect.String || value.String() == "" {
+			return ""
+		}
+		u, err := url.Parse(value.String())
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Sprintf("must be a http or https url like https://example.com, got %q", value.String())
+		}
+	case "oneof":
+		if value.Kind() != reflect.String || value.String() == "" {
+			return ""
+		}
+		options := strings.Fields(rule.param)
+		for _, option := range options {
+			if value.String() == option {
+				return ""
+			}
+		}
+		return fmt.Sprintf("must be one of %s, got %q", strings.Join(options, ", "), value.String())
+	case "min":
+		limit, err := strconv.ParseFloat(rule.param, 64)
+		if err != nil {
+			return ""
+		}
+		switch value.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			if float64(value.Int()) < limit {
+				return fmt.Sprintf("must be at least %s, got %d", rule.param, value.Int())
+			}
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			if float64(value.Uint()) < limit {
+				return fmt.Sprintf("must be at least %s, got %d", rule.param, value.Uint())
+			}
+		case reflect.Float32, reflect.Float64:
+			if value.Float() < limit {
+				return fmt.Sprintf("must be at least %s, got %v", rule.param, value.Float())
+			}
+		case reflect.String, reflect.Slice, reflect.Map:
+			if float64(value.Len()) < limit {
+				return fmt.Sprintf("length must be at least %s", rule.param)
+			}
+		}
+	}
+	return ""
+}
+
+// JSONSchema 按mapstructure、default、validate、desc和secret标签生成结构体的JSON Schema(draft-07)
+func JSONSchema(t reflect.Type) map[string]interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	schema := typeSchema(t)
+	if t.Kind() != reflect.Struct {
+		return schema
+	}
+	schema["$schema"] = "http://json-schema.org/draft-07/schema#"
+	schema["title"] = t.Name()
+	return schema
+}
+
+func typeSchema(t reflect.Type) map[string]interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": typeSchema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": typeSchema(t.Elem())}
+	case reflect.Struct:
+		properties := make(map[string]interface{}, t.NumField())
+		required := make([]string, 0)
+		alternatives := make([]interface{}, 0)
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			name := fieldName(field)
+			property := typeSchema(field.Type)
+			if desc := field.Tag.Get("desc"); desc != "" {
+				property["description"] = desc
+			}
+			if def, ok := field.Tag.Lookup("default"); ok {
+				if value, err := parseDefault(field.Type, def); err == nil {
+					property["default"] = value
+				}
+			}
+			if field.Tag.Get("secret") == "true" {
+				// 表单中按密码框展示，读取配置时不回显
+				property["format"] = "password"
+				property["writeOnly"] = true
+			}
+			for _, rule := range parseRules(field.Tag.Get("validate")) {
+				switch rule.name {
+				case "required":
+					required = append(required, name)
+				case "url":
+					property["format"] = "uri"
+					property["pattern"] = "^https?://"
+				case "oneof":
+					enum := make([]interface{}, 0)
+					for _, option := range strings.Fields(rule.param) {
+						enum = append(enum, option)
+					}
+					property["enum"] = enum
+				case "min":
+					limit, err := strconv.ParseFloat(rule.param, 64)
+					if err != nil {
+						continue
+					}
+					switch property["type"] {
+					case "integer", "number":
+						property["minimum"] = limit
+					case "string":
+						property["minLength"] = int(limit)
+					case "array":
+						property["minItems"] = int(limit)
+					}
+				case "required_without":
+					alternatives = append(alternatives, map[string]interface{}{"anyOf": []interface{}{
+						map[string]interface{}{"required": []string{name}},
+						map[string]interface{}{"required": []string{rule.param}},
+					}})
+				}
+			}
+			properties[name] = property
+		}
+		schema := map[string]interface{}{"type": "object", "properties": properties}
+		if len(required) > 0 {
+			schema["required"] = required
+		}
+		if len(alternatives) > 0 {
+			schema["allOf"] = alternatives
+		}
+		return schema
+	}
+	return map[string]interface{}{}
+}
@@ -0,0 +1,263 @@
+package pan
+
+import (
+	"fmt"
+	"github.com/hefeiyu2025/pan-client/internal"
+	"sort"
+)
+
+// 配置中每种驱动可以有多个账号，支持三种写法:
+//
+//	driver.quark: {id: a, pus: ...}                  单个账号(旧格式)
+//	driver.quark: [{id: a, pus: ...}, {id: b, ...}]  账号列表
+//	driver.quark: {a: {pus: ...}, b: {pus: ...}}     以id为key
+//
+// 以id为key时每个账号单独读写，配合DirStore时每个账号一个文件(driver.quark.a.yaml)，
+// driver.quark.yaml中只保留{a: {id: a}}用于查找默认账号
+
+type accountLayout int
+
+const (
+	layoutNone accountLayout = iota
+	layoutSingle
+	layoutList
+	layoutMap
+)
+
+// accountRef 账号在配置中的位置
+type accountRef struct {
+	layout accountLayout
+	// 以id为key时的key
+	mapKey string
+}
+
+func driverConfigKey(driverType DriverType) string {
+	return ViperDriverPrefix + string(driverType)
+}
+
+func loadRaw(store internal.ConfigStore, key string) (interface{}, error) {
+	var raw interface{}
+	if err := store.Load(key, &raw); err != nil {
+		return nil, err
+	}
+	return raw, nil
+}
+
+func accountId(account map[string]interface{}) string {
+	if id, ok := account["id"]; ok && id != nil {
+		return fmt.Sprint(id)
+	}
+	return ""
+}
+
+// isAccountMap 所有的值都是对象时认为是以id为key的写法
+func isAccountMap(m map[string]interface{}) bool {
+	if len(m) == 0 {
+		return false
+	}
+	for _, v := range m {
+		if _, ok := v.(map[string]interface{}); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// splitKeys key.<id>与key分开保存时，以id为key的账号只保存在key.<id>中
+func splitKeys(store internal.ConfigStore) bool {
+	s, ok := store.(internal.SplitStore)
+	return ok && s.SplitKeys()
+}
+
+// withId 以id为key的写法中账号可以不写id，复制一份补上
+func withId(account map[string]interface{}, id string) map[string]interface{} {
+	result := make(map[string]interface{}, len(account)+1)
+	for k, v := range account {
+		result[k] = v
+	}
+	if accountId(account) == "" {
+		result["id"] = id
+	}
+	return result
+}
+
+// readAccount 读取账号的配置，id为空时返回第一个账号，没有找到时account为空
+func readAccount(store internal.ConfigStore, driverType DriverType, id string) (map[string]interface{}, accountRef, error) {
+	key := driverConfigKey(driverType)
+	if id != "" {
+		// 以id为key时直接读取该账号，不需要读取其他账号
+		raw, err := loadRaw(store, key+"."+id)
+		if err != nil {
+			return nil, accountRef{}, err
+		}
+		if account, ok := raw.(map[string]interface{}); ok {
+			return withId(account, id), accountRef{layout: layoutMap, mapKey: id}, nil
+		}
+	}
+	raw, err := loadRaw(store, key)
+	if err != nil {
+		return nil, accountRef{}, err
+	}
+	switch v := raw.(type) {
+	case []interface{}:
+		for _, item := range v {
+			account, ok := item.(map[string]interface{})
+			if ok && (id == "" || accountId(account) == id) {
+				return account, accountRef{layout: layoutList}, nil
+			}
+		}
+		return nil, accountRef{layout: layoutList}, nil
+	case map[string]interface{}:
+		if !isAccountMap(v) {
+			if id == "" || accountId(v) == "" || accountId(v) == id {
+				return v, accountRef{layout: layoutSingle}, nil
+			}
+			return nil, accountRef{layout: layoutSingle}, nil
+		}
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			account := v[k].(map[string]interface{})
+			if id == "" || k == id || accountId(account) == id {
+				if splitKeys(store) {
+					// key中只有用于查找的id，账号在key.<k>中
+					raw, err := loadRaw(store, key+"."+k)
+					if err != nil {
+						return nil, accountRef{}, err
+					}
+					if own, ok := raw.(map[string]interface{}); ok {
+						account = own
+					}
+				}
+				return withId(account, k), accountRef{layout: layoutMap, mapKey: k}, nil
+			}
+		}
+		return nil, accountRef{layout: layoutMap}, nil
+	}
+	return nil, accountRef{}, nil
+}
+
+// writeAccount 保存账号的配置，不影响同类型的其他账号
+// 旧格式中已经有其他账号时，转为以id为key的写法
+func writeAccount(store internal.ConfigStore, driverType DriverType, ref accountRef, id string, settings map[string]interface{}) error {
+	key := driverConfigKey(driverType)
+	mapKey := ref.mapKey
+	if mapKey == "" {
+		mapKey = id
+	}
+	raw, err := loadRaw(store, key)
+	if err != nil {
+		return err
+	}
+	switch v := raw.(type) {
+	case []interface{}:
+		list := make([]interface{}, 0, len(v)+1)
+		replaced := false
+		for _, item := range v {
+			if account, ok := item.(map[string]interface{}); ok && accountId(account) == id {
+				item = settings
+				replaced = true
+			}
+			list = append(list, item)
+		}
+		if !replaced {
+			list = append(list, settings)
+		}
+		return store.Save(key, list)
+	case map[string]interface{}:
+		if isAccountMap(v) {
+			if splitKeys(store) {
+				return saveSplitAccount(store, key, v, mapKey, id, settings)
+			}
+			return store.Save(key+"."+mapKey, settings)
+		}
+		if oldId := accountId(v); oldId != "" && oldId != id {
+			if splitKeys(store) {
+				return saveSplitAccount(store, key, map[string]interface{}{oldId: v}, mapKey, id, settings)
+			}
+			return store.Save(key, map[string]interface{}{oldId: v, id: settings})
+		}
+		return store.Save(key, settings)
+	}
+	if ref.layout == layoutMap {
+		if splitKeys(store) {
+			return saveSplitAccount(store, key, nil, mapKey, id, settings)
+		}
+		return store.Save(key+"."+mapKey, settings)
+	}
+	return store.Save(key, settings)
+}
+
+// saveSplitAccount 账号保存到key.<mapKey>，key中的该账号替换为只有id的索引，不会留下过期的副本
+func saveSplitAccount(store internal.ConfigStore, key string, accounts map[string]interface{}, mapKey, id string, settings map[string]interface{}) error {
+	if err := store.Save(key+"."+mapKey, settings); err != nil {
+		return err
+	}
+	if index, ok := accounts[mapKey].(map[string]interface{}); ok && len(index) == 1 && accountId(index) == id {
+		return nil
+	}
+	index := make(map[string]interface{}, len(accounts)+1)
+	for k, account := range accounts {
+		index[k] = account
+	}
+	index[mapKey] = map[string]interface{}{"id": id}
+	return store.Save(key, index)
+}
+
+// deleteAccount 删除账号的配置，不影响同类型的其他账号
+func deleteAccount(store internal.ConfigStore, driverType DriverType, ref accountRef, id string) error {
+	key := driverConfigKey(driverType)
+	mapKey := ref.mapKey
+	if mapKey == "" {
+		mapKey = id
+	}
+	raw, err := loadRaw(store, key)
+	if err != nil {
+		return err
+	}
+	switch v := raw.(type) {
+	case []interface{}:
+		list := make([]interface{}, 0, len(v))
+		for _, item := range v {
+			if account, ok := item.(map[string]interface{}); ok && accountId(account) == id {
+				continue
+			}
+			list = append(list, item)
+		}
+		if len(list) == 0 {
+			return store.Delete(key)
+		}
+		return store.Save(key, list)
+	case map[string]interface{}:
+		if isAccountMap(v) {
+			if err = store.Delete(key + "." + mapKey); err != nil || !splitKeys(store) {
+				return err
+			}
+			// 同时删除key中的索引
+			if _, ok := v[mapKey]; !ok {
+				return nil
+			}
+			rest := make(map[string]interface{}, len(v))
+			for k, account := range v {
+				if k != mapKey {
+					rest[k] = account
+				}
+			}
+			if len(rest) == 0 {
+				return store.Delete(key)
+			}
+			return store.Save(key, rest)
+		}
+		if oldId := accountId(v); oldId == "" || oldId == id {
+			return store.Delete(key)
+		}
+		return nil
+	}
+	if ref.layout == layoutMap {
+		return store.Delete(key + "." + mapKey)
+	}
+	return nil
+}
@@ -0,0 +1,22 @@
+package pan
+
+// Capabilities 驱动支持的功能，不支持的功能调用时返回的异常满足errors.Is(err, ErrNotSupported)
+type Capabilities struct {
+	// 秒传，UploadReq.OnlyFast可用
+	FastUpload bool `json:"fastUpload"`
+	// 断点续传，UploadReq.Resumable可用
+	ResumableUpload bool `json:"resumableUpload"`
+	OfflineDownload bool `json:"offlineDownload"`
+	TaskList        bool `json:"taskList"`
+	// ShareList、NewShare、DeleteShare
+	Share        bool `json:"share"`
+	ShareRestore bool `json:"shareRestore"`
+	DirectLink   bool `json:"directLink"`
+	Copy         bool `json:"copy"`
+	Search       bool `json:"search"`
+	// ListPage只请求对应的页，否则是基于整个目录切分
+	NativeListPage bool `json:"nativeListPage"`
+	// Stat直接按路径查询，否则是逐级遍历目录
+	NativeStat bool `json:"nativeStat"`
+	Drop       bool `json:"drop"`
+}
@@ -0,0 +1,109 @@
+package pan
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"strings"
+)
+
+// 校验下载文件时优先使用的hash，gcid需要按块计算，放在最后
+var verifyHashTypes = []string{HashMd5, HashSha1, HashGcid}
+
+// ChecksumError 下载的文件与网盘返回的hash不一致
+type ChecksumError struct {
+	File     string
+	HashType string
+	Expected string
+	Actual   string
+}
+
+func (e *ChecksumError) Error() string {
+	return fmt.Sprintf("%s %s mismatch, expected %s, got %s", e.File, e.HashType, e.Expected, e.Actual)
+}
+
+// ChecksumMismatch errors.Is(err, ErrChecksumMismatch)为true，errors.As可以取出*ChecksumError
+func ChecksumMismatch(err *ChecksumError) DriverErrorInterface {
+	return KindCodeMsgErrorData(ErrChecksumMismatch, UNKNOWN, "", err, nil)
+}
+
+// SetHash 记录网盘返回的hash，空值忽略
+func (p *PanObj) SetHash(hashType, value string) {
+	if value == "" {
+		return
+	}
+	if p.Hashes == nil {
+		p.Hashes = make(map[string]string)
+	}
+	p.Hashes[hashType] = value
+}
+
+// clone Hashes单独复制，获取下载链接时记录的hash不会写入列表缓存中共享的对象
+func (p *PanObj) clone() *PanObj {
+	obj := *p
+	obj.Hashes = maps.Clone(p.Hashes)
+	return &obj
+}
+
+// VerifyHash 可用于校验的hash，网盘没有返回时ok为false
+func (p *PanObj) VerifyHash() (hashType string, value string, ok bool) {
+	for _, hashType = range verifyHashTypes {
+		if value = p.Hashes[hashType]; value != "" {
+			return hashType, value, true
+		}
+	}
+	return "", "", false
+}
+
+// HashFile 计算本地文件的hash，结果为小写的hex
+func HashFile(filename string, hashType string) (string, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return "", err
+	}
+	hasher, e := newHasher(hashType, info.Size())
+	if e != nil {
+		return "", e
+	}
+	buffer := make([]byte, 1024*1024) // 1MB buffer
+	if _, err = io.CopyBuffer(hasher, file, buffer); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// normalizeHash 网盘返回的hash可能是大写或base64，统一转为小写的hex
+func normalizeHash(value string) string {
+	value = strings.TrimSpace(value)
+	if _, err := hex.DecodeString(value); err == nil {
+		return strings.ToLower(value)
+	}
+	if raw, err := base64.StdEncoding.DecodeString(value); err == nil {
+		return hex.EncodeToString(raw)
+	}
+	return strings.ToLower(value)
+}
+
+// checkFileHash 按网盘返回的hash校验本地文件，没有可用的hash时checked为false
+func checkFileHash(filename string, object *PanObj) (bool, error) {
+	hashType, expected, ok := object.VerifyHash()
+	if !ok {
+		return false, nil
+	}
+	actual, err := HashFile(filename, hashType)
+	if err != nil {
+		return true, err
+	}
+	if actual != normalizeHash(expected) {
+		return true, ChecksumMismatch(&ChecksumError{File: filename, HashType: hashType, Expected: expected, Actual: actual})
+	}
+	return true, nil
+}
@@ -1,19 +1,26 @@
 package pan
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"github.com/hefeiyu2025/pan-client/internal"
 	"github.com/imroc/req/v3"
-	logger "github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus"
 	"io"
+	"net/http"
 	"os"
+	"path"
 	"path/filepath"
+	"reflect"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 )
 
-type DriverConstructor func() Driver
+// DriverConstructor env为实例所在注册中心的运行环境
+type DriverConstructor func(env *Env) Driver
 
 type DriverType string
 
@@ -41,127 +48,173 @@ type Meta interface {
 	GetId() string
 	Init() (string, error)
 	InitByCustom(id string, read ConfigRW, write ConfigRW) (string, error)
+	// Drop 释放实例的资源：取消进行中的上传下载、清除缓存并从注册中心移除
 	Drop() error
+	// DeleteConfig 删除配置文件中该账号的配置
+	DeleteConfig() error
+	// Capabilities 驱动支持的功能
+	Capabilities() Capabilities
+	// Reload 配置变化后重新读取该账号的配置，凭证变化时重建请求客户端，进行中的传输不受影响
+	Reload(ctx context.Context) error
 	ReadConfig() error
 	WriteConfig() error
+	// ValidateConfig 按Properties的validate标签校验当前的配置
+	ValidateConfig() error
+	// ConfigSchema 账号配置的JSON Schema，用于生成配置表单
+	ConfigSchema() map[string]interface{}
 	Get(key string) (interface{}, bool)
 	GetOrDefault(key string, defFun DefaultFun) (interface{}, bool, error)
 	Set(key string, value interface{})
 	SetDuration(key string, value interface{}, d time.Duration)
 	Del(key string)
+	// ClearCache 清除该实例的所有缓存
+	ClearCache()
+	// SetRateLimit 该实例的上传下载限速，单位字节/秒，0为不限速，同时受全局限速
+	SetRateLimit(download, upload int64)
 }
 
+// Operate 所有方法的ctx都会透传到底层的http请求，取消ctx即中断对应的操作
 type Operate interface {
-	Disk() (*DiskResp, error)
-	List(req ListReq) ([]*PanObj, error)
-	ObjRename(req ObjRenameReq) error
-	BatchRename(req BatchRenameReq) error
-	Mkdir(req MkdirReq) (*PanObj, error)
-	Move(req MovieReq) error
-	Delete(req DeleteReq) error
-	UploadPath(req UploadPathReq) error
-	UploadFile(req UploadFileReq) error
-	DownloadPath(req DownloadPathReq) error
-	DownloadFile(req DownloadFileReq) error
-	OfflineDownload(req OfflineDownloadReq) (*Task, error)
-	TaskList(req TaskListReq) ([]*Task, error)
-	DirectLink(req DirectLinkReq) ([]*DirectLink, error)
+	Disk(ctx context.Context) (*DiskResp, error)
+	List(ctx context.Context, req ListReq) ([]*PanObj, error)
+	// ListPage 分页获取目录，网盘支持分页时只请求对应的页且不写入缓存
+	ListPage(ctx context.Context, req ListPageReq) (*ListPageResp, error)
+	// Stat 获取路径对应的对象，不存在时返回的异常满足errors.Is(err, ErrNotFound)
+	Stat(ctx context.Context, path string) (*PanObj, error)
+	// Search 使用网盘原生的搜索接口，部分网盘的结果只带父目录id，此时Path可能为空
+	Search(ctx context.Context, req SearchReq) ([]*PanObj, error)
+	ObjRename(ctx context.Context, req ObjRenameReq) error
+	BatchRename(ctx context.Context, req BatchRenameReq) error
+	Mkdir(ctx context.Context, req MkdirReq) (*PanObj, error)
+	Move(ctx context.Context, req MovieReq) error
+	Copy(ctx context.Context, req CopyReq) error
+	Delete(ctx context.Context, req DeleteReq) error
+	UploadPath(ctx context.Context, req UploadPathReq) error
+	UploadFile(ctx context.Context, req UploadFileReq) error
+	UploadStream(ctx context.Context, req UploadStreamReq) error
+	DownloadPath(ctx context.Context, req DownloadPathReq) error
+	DownloadFile(ctx context.Context, req DownloadFileReq) error
+	Open(ctx context.Context, req OpenReq) (io.ReadCloser, error)
+	OfflineDownload(ctx context.Context, req OfflineDownloadReq) (*Task, error)
+	TaskList(ctx context.Context, req TaskListReq) ([]*Task, error)
+	DirectLink(ctx context.Context, req DirectLinkReq) ([]*DirectLink, error)
 }
 
 type BaseOperate struct {
+	Env *Env
+	// 实例的限速，第一次使用时创建
+	limitOnce       sync.Once
+	downloadLimiter *internal.RateLimiter
+	uploadLimiter   *internal.RateLimiter
 }
 
-func (b *BaseOperate) BaseUploadPath(req UploadPathReq, UploadFile func(req UploadFileReq) error) error {
+func (b *BaseOperate) logger() *logrus.Logger {
+	return envOf(b.Env).Logger
+}
+
+func (b *BaseOperate) rateLimiters() (*internal.RateLimiter, *internal.RateLimiter) {
+	b.limitOnce.Do(func() {
+		b.downloadLimiter = internal.NewRateLimiter(0)
+		b.uploadLimiter = internal.NewRateLimiter(0)
+	})
+	return b.downloadLimiter, b.uploadLimiter
+}
+
+// SetRateLimit 设置该实例的上传下载限速，单位字节/秒，0为不限速，对进行中的传输也生效
+func (b *BaseOperate) SetRateLimit(download, upload int64) {
+	downloadLimiter, uploadLimiter := b.rateLimiters()
+	downloadLimiter.SetLimit(download)
+	uploadLimiter.SetLimit(upload)
+}
+
+// downloadLimiters 下载依次受全局、实例和单次传输的限速
+func (b *BaseOperate) downloadLimiters(rateLimit int64) []*internal.RateLimiter {
+	downloadLimiter, _ := b.rateLimiters()
+	return []*internal.RateLimiter{envOf(b.Env).DownloadLimiter, downloadLimiter, internal.TransferLimiter(rateLimit)}
+}
+
+func (b *BaseOperate) BaseUploadPath(ctx context.Context, req UploadPathReq, UploadFile func(ctx context.Context, req UploadFileReq) error) error {
 	localPath := req.LocalPath
 	if localPath != "" {
 		fileInfo, err := os.Stat(localPath)
 		if err != nil {
-			logger.Errorf("file %s read error %v", localPath, err)
+			b.logger().Errorf("file %s read error %v", localPath, err)
 			return OnlyError(err)
 		}
 		if !fileInfo.IsDir() {
-			err = UploadFile(UploadFileReq{
+			err = UploadFile(ctx, UploadFileReq{
 				LocalFile:          localPath,
 				RemotePath:         req.RemotePath,
 				Resumable:          req.Resumable,
 				OnlyFast:           req.OnlyFast,
 				SuccessDel:         req.SuccessDel,
+				RateLimit:          req.RateLimit,
+				Progress:           req.Progress,
 				RemotePathTransfer: req.RemotePathTransfer,
 				RemoteNameTransfer: req.RemotePathTransfer,
 			})
 			return err
 		}
-		logger.Infof("start upload dir %s -> %s", localPath, req.RemotePath)
+		b.logger().Infof("start upload dir %s -> %s", localPath, req.RemotePath)
+		filter := Filter{
+			IgnorePaths:      req.IgnorePaths,
+			IgnoreFiles:      req.IgnoreFiles,
+			Extensions:       req.Extensions,
+			IgnoreExtensions: req.IgnoreExtensions,
+		}
 		err = filepath.Walk(localPath, func(path string, info os.FileInfo, err error) error {
 			if err != nil {
 				return err
 			}
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
 			if info.IsDir() {
-				for _, ignorePath := range req.IgnorePaths {
-					if filepath.Base(path) == ignorePath {
-						return filepath.SkipDir
-					}
+				if !filter.DirAllowed(filepath.Base(path)) {
+					return filepath.SkipDir
 				}
 			} else {
 				// 获取相对于root的相对路径
 				relPath, _ := filepath.Rel(localPath, path)
 				relPath = strings.Replace(relPath, "\\", "/", -1)
 				relPath = strings.Replace(relPath, info.Name(), "", 1)
-				NotUpload := false
-				for _, extension := range req.Extensions {
-					if strings.HasSuffix(info.Name(), extension) {
-						NotUpload = false
-						break
-					}
-					NotUpload = true
-				}
-				for _, ignoreFile := range req.IgnoreFiles {
-					if info.Name() == ignoreFile {
-						NotUpload = true
-						break
-					}
-				}
-				for _, extension := range req.IgnoreExtensions {
-					if strings.HasSuffix(info.Name(), extension) {
-						NotUpload = true
-						break
-					}
-				}
-				if !NotUpload {
-					logger.Infof("start upload file %s -> %s", path, strings.TrimRight(req.RemotePath, "/")+"/"+relPath)
-					err = UploadFile(UploadFileReq{
+				if filter.FileAllowed(info.Name()) {
+					b.logger().Infof("start upload file %s -> %s", path, strings.TrimRight(req.RemotePath, "/")+"/"+relPath)
+					err = UploadFile(ctx, UploadFileReq{
 						LocalFile:          path,
 						RemotePath:         strings.TrimRight(req.RemotePath, "/") + "/" + relPath,
 						OnlyFast:           req.OnlyFast,
 						Resumable:          req.Resumable,
 						SuccessDel:         req.SuccessDel,
+						RateLimit:          req.RateLimit,
+						Progress:           req.Progress,
 						RemotePathTransfer: req.RemotePathTransfer,
 						RemoteNameTransfer: req.RemotePathTransfer,
 					})
 					if err == nil {
 						dir := filepath.Dir(path)
-						logger.Infof("uploaded success %s", dir)
+						b.logger().Infof("uploaded success %s", dir)
 						if req.SuccessDel {
 							if dir != "." {
 								empty, _ := internal.IsEmptyDir(dir)
 								if empty {
 									err = os.Remove(dir)
 									if err != nil {
-										logger.Errorf("delete fail %s,%v", dir, err)
+										b.logger().Errorf("delete fail %s,%v", dir, err)
 									} else {
-										logger.Infof("delete success %s", dir)
+										b.logger().Infof("delete success %s", dir)
 									}
 								}
 							}
 						}
 					} else {
-						if !req.SkipFileErr {
+						if !req.SkipFileErr || ctx.Err() != nil {
 							return err
 						} else {
-							logger.Errorf("upload err %v", err)
+							b.logger().Errorf("upload err %v", err)
 						}
 					}
-					logger.Infof("end upload file %s -> %s", path, strings.TrimRight(req.RemotePath, "/")+"/"+relPath)
+					b.logger().Infof("end upload file %s -> %s", path, strings.TrimRight(req.RemotePath, "/")+"/"+relPath)
 				}
 			}
 			return nil
@@ -169,156 +222,273 @@ func (b *BaseOperate) BaseUploadPath(req UploadPathReq, UploadFile func(req Uplo
 		if err != nil {
 			return err
 		}
-		logger.Infof("end upload dir %s -> %s", localPath, req.RemotePath)
+		b.logger().Infof("end upload dir %s -> %s", localPath, req.RemotePath)
 		return nil
 	}
 	// 遍历目录
 	return OnlyMsg("path is empty")
 }
 
-func (b *BaseOperate) BaseDownloadPath(req DownloadPathReq,
-	List func(req ListReq) ([]*PanObj, error),
-	DownloadFile func(req DownloadFileReq) error) error {
+// BaseUploadFile 将本地文件作为流交给UploadStream上传，成功后按需删除本地文件
+func (b *BaseOperate) BaseUploadFile(ctx context.Context, req UploadFileReq, UploadStream func(ctx context.Context, req UploadStreamReq) error) error {
+	file, err := os.Open(req.LocalFile)
+	if err != nil {
+		return OnlyError(err)
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return OnlyError(err)
+	}
+	if stat.IsDir() {
+		_ = file.Close()
+		return OnlyMsg(req.LocalFile + " not a file")
+	}
+	err = UploadStream(ctx, UploadStreamReq{
+		Reader:             file,
+		Size:               stat.Size(),
+		Name:               stat.Name(),
+		ModTime:            stat.ModTime(),
+		RemotePath:         req.RemotePath,
+		OnlyFast:           req.OnlyFast,
+		Resumable:          req.Resumable,
+		RateLimit:          req.RateLimit,
+		Progress:           req.Progress,
+		localFile:          req.LocalFile,
+		RemotePathTransfer: req.RemotePathTransfer,
+		RemoteNameTransfer: req.RemoteNameTransfer,
+	})
+	_ = file.Close()
+	if err != nil {
+		return err
+	}
+	b.logger().Infof("upload success %s", req.LocalFile)
+	// 上传成功则移除文件了
+	if req.SuccessDel {
+		err = os.Remove(req.LocalFile)
+		if err != nil {
+			b.logger().Errorf("delete fail %s,%v", req.LocalFile, err)
+		} else {
+			b.logger().Infof("delete success %s", req.LocalFile)
+		}
+	}
+	return nil
+}
+
+func (b *BaseOperate) BaseDownloadPath(ctx context.Context, req DownloadPathReq,
+	List func(ctx context.Context, req ListReq) ([]*PanObj, error),
+	DownloadFile func(ctx context.Context, req DownloadFileReq) error) error {
 	dir := req.RemotePath
 	remotePathName := strings.Trim(dir.Path, "/") + "/" + dir.Name
-	logger.Infof("start download dir %s -> %s", remotePathName, req.LocalPath)
+	b.logger().Infof("start download dir %s -> %s", remotePathName, req.LocalPath)
 	if dir.Type != "dir" {
 		return OnlyMsg("only support download dir")
 	}
-	objs, err := List(ListReq{
-		Reload: true,
-		Dir:    req.RemotePath,
-	})
-	if err != nil {
-		return err
+	filter := Filter{
+		IgnorePaths:      req.IgnorePaths,
+		IgnoreFiles:      req.IgnoreFiles,
+		Extensions:       req.Extensions,
+		IgnoreExtensions: req.IgnoreExtensions,
 	}
-	for _, object := range objs {
-		NotDownload := false
+	opts := WalkOptions{Reload: true}
+	if req.NotTraverse {
+		opts.MaxDepth = 1
+	}
+	rootPath := ObjPath(dir)
+	// 本地目录，每一级名称都经过RemoteNameTransfer转换
+	localDir := func(remotePath string) string {
+		localPath := strings.TrimRight(req.LocalPath, "/")
+		relDir := strings.Trim(path.Dir(strings.TrimPrefix(remotePath, rootPath)), "/")
+		if relDir == "" || relDir == "." {
+			return localPath
+		}
+		for _, name := range strings.Split(relDir, "/") {
+			if req.RemoteNameTransfer != nil {
+				name = req.RemoteNameTransfer(name)
+			}
+			localPath = localPath + "/" + name
+		}
+		return localPath
+	}
+	err := Walk(ctx, ListFunc(List), dir, func(remotePath string, object *PanObj, err error) error {
+		if err != nil {
+			if object != dir && req.SkipFileErr {
+				b.logger().Errorf("download %s,err: %v", object.Name, err)
+				return SkipDir
+			}
+			return err
+		}
 		objectName := object.Name
 		if req.RemoteNameTransfer != nil {
 			objectName = req.RemoteNameTransfer(objectName)
 		}
 		if object.Type == "dir" {
-			for _, ignorePath := range req.IgnorePaths {
-				if objectName == ignorePath {
-					NotDownload = true
-					break
-				}
-			}
-			if !NotDownload && req.NotTraverse == false {
-				err = b.BaseDownloadPath(DownloadPathReq{
-					RemotePath:         object,
-					LocalPath:          strings.Trim(req.LocalPath, "/") + "/" + objectName,
-					Concurrency:        req.Concurrency,
-					ChunkSize:          req.ChunkSize,
-					OverCover:          req.OverCover,
-					DownloadCallback:   req.DownloadCallback,
-					Extensions:         req.Extensions,
-					IgnorePaths:        req.IgnorePaths,
-					IgnoreExtensions:   req.IgnoreExtensions,
-					IgnoreFiles:        req.IgnoreFiles,
-					RemoteNameTransfer: req.RemoteNameTransfer,
-					SkipFileErr:        req.SkipFileErr,
-				}, List, DownloadFile)
-				if err != nil {
-					if req.SkipFileErr {
-						logger.Errorf("download %s,err: %v", objectName, err)
-					} else {
-						return err
-					}
-				}
-			} else {
-				logger.Infof("dir will skip: %s", objectName)
-			}
-		} else {
-			for _, extension := range req.Extensions {
-				if strings.HasSuffix(objectName, extension) {
-					NotDownload = false
-					break
-				}
-				NotDownload = true
-			}
-			for _, ignoreFile := range req.IgnoreFiles {
-				if objectName == ignoreFile {
-					NotDownload = true
-					break
-				}
+			if !filter.DirAllowed(objectName) {
+				b.logger().Infof("dir will skip: %s", objectName)
+				return SkipDir
 			}
-			for _, extension := range req.IgnoreExtensions {
-				if strings.HasSuffix(objectName, extension) {
-					NotDownload = true
-					break
-				}
-			}
-			if !NotDownload {
-				err = DownloadFile(DownloadFileReq{
-					RemoteFile:       object,
-					LocalPath:        req.LocalPath,
-					Concurrency:      req.Concurrency,
-					ChunkSize:        req.ChunkSize,
-					OverCover:        req.OverCover,
-					DownloadCallback: req.DownloadCallback,
-				})
-				if err != nil {
-					if req.SkipFileErr {
-						logger.Errorf("download %s,err: %v", objectName, err)
-					} else {
-						return err
-					}
-				}
-			} else {
-				logger.Infof("file will skip: %s", objectName)
+			return nil
+		}
+		if !filter.FileAllowed(objectName) {
+			b.logger().Infof("file will skip: %s", objectName)
+			return nil
+		}
+		err = DownloadFile(ctx, DownloadFileReq{
+			RemoteFile:       object,
+			LocalPath:        localDir(remotePath),
+			Concurrency:      req.Concurrency,
+			ChunkSize:        req.ChunkSize,
+			OverCover:        req.OverCover,
+			VerifyHash:       req.VerifyHash,
+			RateLimit:        req.RateLimit,
+			Progress:         req.Progress,
+			DownloadCallback: req.DownloadCallback,
+		})
+		if err != nil {
+			if req.SkipFileErr && ctx.Err() == nil {
+				b.logger().Errorf("download %s,err: %v", objectName, err)
+				return nil
 			}
+			return err
 		}
+		return nil
+	}, opts)
+	if err != nil {
+		return err
 	}
-	logger.Infof("end download dir %s -> %s", remotePathName, req.LocalPath)
+	b.logger().Infof("end download dir %s -> %s", remotePathName, req.LocalPath)
 	return nil
 }
 
-type DownloadUrl func(req DownloadFileReq) (string, error)
+// BaseBatchRename 遍历目录下所有对象，子对象先于所在目录重命名
+func (b *BaseOperate) BaseBatchRename(ctx context.Context, req BatchRenameReq,
+	List func(ctx context.Context, req ListReq) ([]*PanObj, error),
+	ObjRename func(ctx context.Context, req ObjRenameReq) error) error {
+	return Walk(ctx, ListFunc(List), req.Path, func(remotePath string, object *PanObj, err error) error {
+		if err != nil {
+			return err
+		}
+		newName := req.Func(object)
+		if newName != object.Name {
+			return ObjRename(ctx, ObjRenameReq{
+				Obj:     object,
+				NewName: newName,
+			})
+		}
+		return nil
+	}, WalkOptions{Reload: true, PostOrder: true})
+}
+
+// DownloadUrl req.RemoteFile为副本，可以记录获取链接时网盘返回的hash
+type DownloadUrl func(ctx context.Context, req DownloadFileReq) (string, error)
 
-func (b *BaseOperate) BaseDownloadFile(req DownloadFileReq,
+func (b *BaseOperate) BaseDownloadFile(ctx context.Context, req DownloadFileReq,
 	client *req.Client,
 	downloadUrl DownloadUrl) error {
 	object := req.RemoteFile
 	if object.Type != "file" {
 		return OnlyMsg("only support download file")
 	}
+	progress := b.newProgress(DirectionDownload, req.LocalPath+"/"+object.Name, ObjPath(object), object.Size, req.Progress)
+	progress.Start()
+	req.RemoteFile = object.clone()
+	err := b.downloadFile(ctx, req, client, downloadUrl, progress)
+	progress.Done(err)
+	return err
+}
+
+func (b *BaseOperate) downloadFile(ctx context.Context, req DownloadFileReq,
+	client *req.Client,
+	downloadUrl DownloadUrl,
+	progress *internal.ProgressTracker) error {
+	object := req.RemoteFile
 	remoteFileName := strings.Trim(object.Path, "/") + "/" + object.Name
-	logger.Infof("start download file %s", remoteFileName)
+	b.logger().Infof("start download file %s", remoteFileName)
 	outputFile := req.LocalPath + "/" + object.Name
+	server := envOf(b.Env).Config.Server
+	url := ""
+	var err error
+	if req.SkipByHash {
+		if _, _, ok := object.VerifyHash(); !ok {
+			// 部分网盘只在获取下载链接时返回hash
+			if url, err = downloadUrl(ctx, req); err != nil {
+				return err
+			}
+		}
+	}
 	fileInfo, err := internal.IsExistFile(outputFile)
-	if fileInfo != nil && err == nil {
-		if fileInfo.Size() == object.Size {
+	// 预分配下载未完成时文件大小已经是完整的，需要继续下载
+	if fileInfo != nil && err == nil && !internal.DownloadPending(outputFile) {
+		same := fileInfo.Size() == object.Size
+		if same && req.SkipByHash {
+			if _, e := checkFileHash(outputFile, object); e != nil {
+				if !errors.Is(e, ErrChecksumMismatch) {
+					return e
+				}
+				b.logger().WithError(e).Warnf("local file %s changed, download again", outputFile)
+				same = false
+				_ = os.Remove(outputFile)
+			}
+		}
+		if same {
 			if !req.OverCover {
 				if req.DownloadCallback != nil {
 					abs, _ := filepath.Abs(outputFile)
 					req.DownloadCallback(filepath.Dir(abs), abs)
 				}
-				logger.Infof("end download file %s -> %s", remoteFileName, outputFile)
+				b.logger().Infof("end download file %s -> %s", remoteFileName, outputFile)
 				return nil
 			} else {
 				_ = os.Remove(outputFile)
 			}
 		}
 	}
-	url, err := downloadUrl(req)
-	if err != nil {
-		return err
-	}
-	e := internal.NewChunkDownload(url, client).
-		SetFileSize(object.Size).
-		SetChunkSize(req.ChunkSize).
-		SetConcurrency(req.Concurrency).
-		SetOutputFile(outputFile).
-		SetTempRootDir(internal.Config.Server.DownloadTmpPath).
-		Do()
-	if e != nil {
-		logger.WithError(e).Errorf("error download file %s", remoteFileName)
-		return e
+	for retried := false; ; retried = true {
+		if url == "" {
+			if url, err = downloadUrl(ctx, req); err != nil {
+				return err
+			}
+		}
+		e := internal.NewChunkDownload(url, client).
+			SetFileSize(object.Size).
+			SetChunkSize(req.ChunkSize).
+			SetConcurrency(req.Concurrency).
+			SetOutputFile(outputFile).
+			SetTempRootDir(server.DownloadTmpPath).
+			SetPreallocate(server.DownloadPreallocate).
+			SetScheduler(envOf(b.Env).Scheduler).
+			SetRateLimiters(b.downloadLimiters(req.RateLimit)...).
+			SetProgress(progress).
+			SetUrlRefresher(func(ctx context.Context) (string, error) {
+				return downloadUrl(ctx, req)
+			}).
+			Do(ctx)
+		if e != nil {
+			b.logger().WithError(e).Errorf("error download file %s", remoteFileName)
+			return e
+		}
+		if !req.VerifyHash && !server.DownloadVerifyHash {
+			break
+		}
+		checked, e := checkFileHash(outputFile, object)
+		if e == nil {
+			if checked {
+				b.logger().Infof("verify file %s success", outputFile)
+			}
+			break
+		}
+		b.logger().WithError(e).Errorf("verify file %s fail", outputFile)
+		// hash只有整个文件的，无法定位出错的分片，删除后整个重新下载一次
+		if retried || !errors.Is(e, ErrChecksumMismatch) {
+			return e
+		}
+		_ = os.Remove(outputFile)
+		progress.Retry(-1, object.Size, e)
+		// 下载链接可能已经过期，重新获取
+		url = ""
 	}
 
-	logger.Infof("end download file %s -> %s", remoteFileName, outputFile)
+	b.logger().Infof("end download file %s -> %s", remoteFileName, outputFile)
 	if req.DownloadCallback != nil {
 		abs, _ := filepath.Abs(outputFile)
 		req.DownloadCallback(filepath.Dir(abs), abs)
@@ -326,11 +496,56 @@ func (b *BaseOperate) BaseDownloadFile(req DownloadFileReq,
 	return nil
 }
 
+// BaseOpen 获取下载链接后按Range请求，返回响应体，由调用方关闭
+func (b *BaseOperate) BaseOpen(ctx context.Context, req OpenReq,
+	client *req.Client,
+	downloadUrl DownloadUrl) (io.ReadCloser, error) {
+	object := req.RemoteFile
+	if object == nil || object.Type != "file" {
+		return nil, OnlyMsg("only support open file")
+	}
+	if req.Offset < 0 || (object.Size > 0 && req.Offset > object.Size) {
+		return nil, OnlyMsg(fmt.Sprintf("offset %d out of range", req.Offset))
+	}
+	url, err := downloadUrl(ctx, DownloadFileReq{RemoteFile: object.clone()})
+	if err != nil {
+		return nil, err
+	}
+	ranged := req.Offset > 0 || req.Length > 0
+	r := client.R().SetContext(ctx).DisableAutoReadResponse()
+	if ranged {
+		rangeEnd := ""
+		if req.Length > 0 {
+			rangeEnd = strconv.FormatInt(req.Offset+req.Length-1, 10)
+		}
+		r.SetHeader("Range", fmt.Sprintf("bytes=%d-%s", req.Offset, rangeEnd))
+	}
+	resp, err := r.Get(url)
+	if err != nil {
+		return nil, OnlyError(err)
+	}
+	if resp.IsErrorState() {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		_ = resp.Body.Close()
+		return nil, KindCodeMsg(StatusKind(resp.StatusCode), resp.StatusCode, fmt.Sprintf("open %s error: %s", object.Name, string(body)))
+	}
+	body := resp.Body
+	if ranged && resp.StatusCode != http.StatusPartialContent {
+		// 服务端不支持Range，自行跳过和截断
+		rc, e := newRangeReadCloser(resp.Body, req.Offset, req.Length)
+		if e != nil {
+			return nil, e
+		}
+		body = rc
+	}
+	return internal.LimitReadCloser(ctx, body, b.downloadLimiters(req.RateLimit)...), nil
+}
+
 type Share interface {
-	ShareList(req ShareListReq) ([]*ShareData, error)
-	NewShare(req NewShareReq) (*ShareData, error)
-	DeleteShare(req DelShareReq) error
-	ShareRestore(req ShareRestoreReq) error
+	ShareList(ctx context.Context, req ShareListReq) ([]*ShareData, error)
+	NewShare(ctx context.Context, req NewShareReq) (*ShareData, error)
+	DeleteShare(ctx context.Context, req DelShareReq) error
+	ShareRestore(ctx context.Context, req ShareRestoreReq) error
 }
 
 type ConfigRW func(config Properties) error
@@ -338,48 +553,194 @@ type ConfigRW func(config Properties) error
 type PropertiesOperate[T Properties] struct {
 	Properties T
 	DriverType DriverType
-	m          sync.RWMutex
-	Read       ConfigRW
-	Write      ConfigRW
+	// AccountId 要读取的账号，为空时读取该类型的第一个账号
+	AccountId string
+	Read      ConfigRW
+	Write     ConfigRW
+	Env       *Env
+	ref       accountRef
+	propsMu   sync.RWMutex
+}
+
+// Props 当前的配置，Reload或刷新登录信息时整个替换，返回的对象只读，一次操作中只取一次
+func (c *PropertiesOperate[T]) Props() T {
+	c.propsMu.RLock()
+	defer c.propsMu.RUnlock()
+	return c.Properties
+}
+
+// SetProperties 替换当前的配置，修改配置时复制一份修改后再替换，不能修改Props返回的对象
+func (c *PropertiesOperate[T]) SetProperties(properties T) {
+	c.propsMu.Lock()
+	defer c.propsMu.Unlock()
+	c.Properties = properties
 }
 
 func (c *PropertiesOperate[T]) GetId() string {
-	return c.Properties.GetId()
+	return c.Props().GetId()
 }
 
 func (c *PropertiesOperate[T]) ReadConfig() error {
-	internal.SetDefaultByTag(c.Properties)
+	ref, err := c.read(c.Properties, c.AccountId)
+	if err != nil {
+		return err
+	}
+	c.ref = ref
+	return nil
+}
+
+// ReloadConfig 重新读取该账号的配置，与当前的配置不同时changed为true，不修改当前的配置
+func (c *PropertiesOperate[T]) ReloadConfig() (properties T, changed bool, err error) {
+	current := c.Props()
+	properties = reflect.New(reflect.TypeOf(current).Elem()).Interface().(T)
+	id := current.GetId()
+	// 按id读取，保证是同一个账号
+	if err = internal.DecodeSettings(map[string]interface{}{"id": id}, properties); err != nil {
+		return properties, false, err
+	}
+	if _, err = c.read(properties, id); err != nil {
+		return properties, false, err
+	}
+	changed = !reflect.DeepEqual(properties, current)
+	if changed {
+		err = c.validate(properties)
+	}
+	return properties, changed, err
+}
+
+func (c *PropertiesOperate[T]) read(properties T, id string) (accountRef, error) {
+	internal.SetDefaultByTag(properties)
 	if c.Read != nil {
-		return c.Read(c.Properties)
+		return accountRef{}, c.Read(properties)
+	}
+	env := envOf(c.Env)
+	if err := env.Err(); err != nil {
+		return accountRef{}, err
+	}
+	env.accountMu.Lock()
+	defer env.accountMu.Unlock()
+	account, ref, err := readAccount(env.Store, c.DriverType, id)
+	if err != nil {
+		return ref, err
 	}
-	c.m.RLock()
-	defer c.m.RUnlock()
-	return internal.Viper.UnmarshalKey(ViperDriverPrefix+string(c.DriverType), c.Properties)
+	if account == nil {
+		return ref, nil
+	}
+	account, err = internal.TransformSecrets(account, c.SecretFields(), env.Cipher.Decrypt)
+	if err != nil {
+		return ref, MsgError(string(c.DriverType)+" decrypt config", err)
+	}
+	return ref, internal.DecodeSettings(account, properties)
 }
 
 func (c *PropertiesOperate[T]) WriteConfig() error {
+	properties := c.Props()
+	if c.Write != nil {
+		return c.Write(properties)
+	}
+	env := envOf(c.Env)
+	if err := env.Err(); err != nil {
+		// 密钥不可用时不能写入明文的凭证
+		return err
+	}
+	env.accountMu.Lock()
+	defer env.accountMu.Unlock()
+	id := properties.GetId()
+	settings, err := internal.ToSettings(properties)
+	if err != nil {
+		return err
+	}
+	account, err := internal.TransformSecrets(settings.(map[string]interface{}), c.SecretFields(), env.Cipher.Encrypt)
+	if err != nil {
+		return MsgError(string(c.DriverType)+" encrypt config", err)
+	}
+	return writeAccount(env.Store, c.DriverType, c.ref, id, account)
+}
+
+// SecretFields 配置中需要加密保存的字段，即Properties中带secret:"true"标签的字段
+func (c *PropertiesOperate[T]) SecretFields() []string {
+	return internal.SecretFields(reflect.TypeOf(c.Props()))
+}
+
+func (c *PropertiesOperate[T]) ValidateConfig() error {
+	return c.validate(c.Props())
+}
+
+func (c *PropertiesOperate[T]) validate(properties T) error {
+	if err := internal.Validate(properties, driverConfigKey(c.DriverType)); err != nil {
+		return InvalidConfig(err)
+	}
+	return nil
+}
+
+func (c *PropertiesOperate[T]) ConfigSchema() map[string]interface{} {
+	return internal.JSONSchema(reflect.TypeOf(c.Props()))
+}
+
+// DeleteConfig 自定义了读写方法时由调用方自己处理
+func (c *PropertiesOperate[T]) DeleteConfig() error {
 	if c.Write != nil {
-		return c.Write(c.Properties)
+		return NotSupported("delete custom config")
 	}
-	c.m.Lock()
-	defer c.m.Unlock()
-	internal.Viper.Set(ViperDriverPrefix+string(c.DriverType), c.Properties)
-	return internal.Viper.WriteConfig()
+	env := envOf(c.Env)
+	env.accountMu.Lock()
+	defer env.accountMu.Unlock()
+	return deleteAccount(env.Store, c.DriverType, c.ref, c.GetId())
 }
 
 type CacheOperate struct {
 	DriverType DriverType
+	// 实例id，初始化时设置，缓存按实例隔离，同类型的多个账号互不影响
+	InstanceId string
+	Env        *Env
 	w          sync.Mutex
 }
 
+func (c *CacheOperate) cache() *internal.MemCache {
+	return envOf(c.Env).Cache
+}
+
+// CachePrefix 实例缓存key的前缀
+func CachePrefix(driverType DriverType, id string) string {
+	return string(driverType) + "/" + id + "."
+}
+
+// legacyCachePrefix 旧版本只按类型区分的前缀
+func legacyCachePrefix(driverType DriverType) string {
+	return string(driverType) + "."
+}
+
+func (c *CacheOperate) cacheKey(key string) string {
+	return CachePrefix(c.DriverType, c.InstanceId) + key
+}
+
+// SetInstanceId 设置实例id，并把旧版本cache.dat中该类型的缓存迁移过来
+// 旧版本每种类型只有一个账号，所以由第一个初始化的实例接管
+func (c *CacheOperate) SetInstanceId(id string) {
+	c.w.Lock()
+	defer c.w.Unlock()
+	c.InstanceId = id
+	count := c.cache().MovePrefix(legacyCachePrefix(c.DriverType), CachePrefix(c.DriverType, id))
+	if count > 0 {
+		envOf(c.Env).Logger.Infof("migrate %d %s cache items to %s", count, c.DriverType, id)
+	}
+}
+
+// ClearCache 清除当前实例的所有缓存
+func (c *CacheOperate) ClearCache() {
+	c.w.Lock()
+	defer c.w.Unlock()
+	c.cache().DeletePrefix(CachePrefix(c.DriverType, c.InstanceId))
+}
+
 func (c *CacheOperate) Get(key string) (interface{}, bool) {
-	return internal.Cache.Get(string(c.DriverType) + "." + key)
+	return c.cache().Get(c.cacheKey(key))
 }
 
 type DefaultFun func() (interface{}, error)
 
 func (c *CacheOperate) GetOrDefault(key string, defFun DefaultFun) (interface{}, bool, error) {
-	result, ok := internal.Cache.Get(string(c.DriverType) + "." + key)
+	result, ok := c.cache().Get(c.cacheKey(key))
 	if !ok {
 		r, err := defFun()
 		if err != nil {
@@ -394,25 +755,130 @@ func (c *CacheOperate) GetOrDefault(key string, defFun DefaultFun) (interface{},
 func (c *CacheOperate) Set(key string, value interface{}) {
 	c.w.Lock()
 	defer c.w.Unlock()
-	internal.Cache.SetDefault(string(c.DriverType)+"."+key, value)
+	c.cache().SetDefault(c.cacheKey(key), value)
 }
 
 func (c *CacheOperate) SetDuration(key string, value interface{}, d time.Duration) {
 	c.w.Lock()
 	defer c.w.Unlock()
-	internal.Cache.Set(string(c.DriverType)+"."+key, value, d)
+	c.cache().Set(c.cacheKey(key), value, d)
 }
 
 func (c *CacheOperate) Del(key string) {
 	c.w.Lock()
 	defer c.w.Unlock()
-	internal.Cache.Delete(string(c.DriverType) + "." + key)
+	c.cache().Delete(c.cacheKey(key))
 }
 
 type CommonOperate struct {
+	Env    *Env
+	lifeMu sync.Mutex
+	life   context.Context
+	stop   context.CancelFunc
+	// discarded 初始化后发现与已有实例是同一账号而被丢弃
+	discarded bool
+}
+
+func (c *CommonOperate) lifeContext() context.Context {
+	c.lifeMu.Lock()
+	defer c.lifeMu.Unlock()
+	if c.life == nil {
+		c.life, c.stop = context.WithCancel(context.Background())
+	}
+	return c.life
+}
+
+// BindContext 把ctx绑定到实例的生命周期上，Drop时会被取消，用完必须调用返回的cancel
+func (c *CommonOperate) BindContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	life := c.lifeContext()
+	if life.Err() != nil {
+		// 已经Drop，AfterFunc是异步回调，这里直接取消
+		cancel()
+		return ctx, cancel
+	}
+	stop := context.AfterFunc(life, cancel)
+	return ctx, func() {
+		stop()
+		cancel()
+	}
+}
+
+// CancelAll 取消所有绑定到该实例的操作，之后绑定的ctx也会直接被取消
+func (c *CommonOperate) CancelAll() {
+	c.lifeContext()
+	c.stop()
+}
+
+// Logger 实例所在环境的日志
+func (c *CommonOperate) Logger() *logrus.Logger {
+	return envOf(c.Env).Logger
+}
+
+// Unregister 从实例所在的注册中心移除，供驱动的Drop使用，被丢弃的实例不会移除同id的已有实例
+func (c *CommonOperate) Unregister(id string) {
+	if c.Discarded() {
+		return
+	}
+	envOf(c.Env).Registry.Unregister(id)
+}
+
+func (c *CommonOperate) discard() {
+	c.lifeMu.Lock()
+	defer c.lifeMu.Unlock()
+	c.discarded = true
+}
+
+// Discarded 为true时缓存、注册和网盘上的状态都属于同账号的已有实例，Drop只能释放自己的资源
+func (c *CommonOperate) Discarded() bool {
+	c.lifeMu.Lock()
+	defer c.lifeMu.Unlock()
+	return c.discarded
+}
+
+// bindReadCloser 关闭时释放绑定的ctx
+type bindReadCloser struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *bindReadCloser) Close() error {
+	defer b.cancel()
+	return b.ReadCloser.Close()
+}
+
+// BindReadCloser Open返回的流在关闭前都需要保持ctx有效
+func BindReadCloser(rc io.ReadCloser, cancel context.CancelFunc) io.ReadCloser {
+	return &bindReadCloser{ReadCloser: rc, cancel: cancel}
+}
+
+// ResolveItems Move、Copy的对象按id或路径取得id，dirIds为其中的目录
+// 按路径查找失败时返回错误，不会只操作部分对象，没有可操作的对象时也返回错误
+func (c *CommonOperate) ResolveItems(ctx context.Context, items []*PanObj, list func(ctx context.Context, req ListReq) ([]*PanObj, error)) (ids []string, dirIds []string, err error) {
+	ids = make([]string, 0, len(items))
+	for _, item := range items {
+		obj := item
+		if item.Id == "0" || item.Id == "" {
+			// 根目录不能操作
+			if item.Path == "" || item.Path == "/" {
+				continue
+			}
+			if obj, err = c.GetPanObj(ctx, item.Path, true, list); err != nil {
+				return nil, nil, err
+			}
+		}
+		ids = append(ids, obj.Id)
+		if obj.Type == "dir" {
+			dirIds = append(dirIds, obj.Id)
+		}
+	}
+	if len(ids) == 0 {
+		return nil, nil, OnlyMsg("no item to operate")
+	}
+	return ids, dirIds, nil
 }
 
-func (c *CommonOperate) GetPanObj(path string, mustExist bool, list func(req ListReq) ([]*PanObj, error)) (*PanObj, error) {
+func (c *CommonOperate) GetPanObj(ctx context.Context, path string, mustExist bool, list func(ctx context.Context, req ListReq) ([]*PanObj, error)) (*PanObj, error) {
 	truePath := strings.Trim(path, "/")
 	paths := strings.Split(truePath, "/")
 
@@ -427,7 +893,7 @@ func (c *CommonOperate) GetPanObj(path string, mustExist bool, list func(req Lis
 		if pathStr == "" {
 			continue
 		}
-		currentChildren, err := list(ListReq{
+		currentChildren, err := list(ctx, ListReq{
 			// 因为mustExist必须再重新来查一次
 			Reload: false,
 			Dir:    target,
@@ -446,7 +912,7 @@ func (c *CommonOperate) GetPanObj(path string, mustExist bool, list func(req Lis
 
 		// 如果必须存在且不存在，则返回错误
 		if mustExist && !exist {
-			currentChildren, err = list(ListReq{
+			currentChildren, err = list(ctx, ListReq{
 				// 因为mustExist必须再重新来查一次
 				Reload: false,
 				Dir:    target,
@@ -462,7 +928,7 @@ func (c *CommonOperate) GetPanObj(path string, mustExist bool, list func(req Lis
 				}
 			}
 			if !exist {
-				return nil, OnlyMsg(fmt.Sprintf("%s not found", path))
+				return nil, NotFound(path)
 			}
 		}
 
@@ -474,9 +940,48 @@ func (c *CommonOperate) GetPanObj(path string, mustExist bool, list func(req Lis
 	return target, nil
 }
 
+// SplitPath 将路径拆分为父目录和名称，父目录以/开头
+func SplitPath(path string) (string, string) {
+	truePath := "/" + strings.Trim(path, "/")
+	index := strings.LastIndex(truePath, "/")
+	parentPath := truePath[:index]
+	if parentPath == "" {
+		parentPath = "/"
+	}
+	return parentPath, truePath[index+1:]
+}
+
+// DirObj 根据目录id和完整路径构造目录对象，根目录的id固定为0
+func DirObj(id, dirPath string) *PanObj {
+	parentPath, name := SplitPath(dirPath)
+	if name == "" {
+		return &PanObj{
+			Id:   "0",
+			Name: "",
+			Path: "/",
+			Size: 0,
+			Type: "dir",
+		}
+	}
+	return &PanObj{
+		Id:   id,
+		Name: name,
+		Path: parentPath,
+		Type: "dir",
+	}
+}
+
+// BaseStat 逐级遍历目录(走缓存)查找对象，仅在网盘没有原生查找方式时使用
+func (c *CommonOperate) BaseStat(ctx context.Context, path string, list func(ctx context.Context, req ListReq) ([]*PanObj, error)) (*PanObj, error) {
+	return c.GetPanObj(ctx, path, true, list)
+}
+
 type ProgressReader struct {
+	logger          *logrus.Logger
 	readCloser      io.ReadCloser
-	file            *os.File
+	reader          io.Reader
+	closer          io.Closer
+	name            string
 	uploaded        int64
 	chunkSize       int64
 	totalSize       int64
@@ -486,6 +991,8 @@ type ProgressReader struct {
 	finish          bool
 	startTime       time.Time
 	chunkStartTime  time.Time
+	// 设置后上报分片完成
+	progress *internal.ProgressTracker
 }
 
 func (pr *ProgressReader) Read(p []byte) (n int, err error) {
@@ -496,6 +1003,7 @@ func (pr *ProgressReader) Read(p []byte) (n int, err error) {
 		// 相等即已经处理完毕
 		if pr.currentSize == pr.currentUploaded {
 			pr.uploaded += pr.currentSize
+			pr.progress.Chunk(int((pr.uploaded - 1) / pr.chunkSize))
 		}
 		if pr.uploaded == pr.totalSize {
 			pr.finish = true
@@ -504,13 +1012,13 @@ func (pr *ProgressReader) Read(p []byte) (n int, err error) {
 		if pr.finish {
 			startTime = pr.startTime
 		}
-		internal.LogProgress("uploading", pr.file.Name(), startTime, pr.currentUploaded, uploaded, pr.totalSize, false)
+		internal.LogProgress(pr.logger, "uploading", pr.name, startTime, pr.currentUploaded, uploaded, pr.totalSize, false)
 	}
 	return n, err
 }
 func (pr *ProgressReader) NextChunk() (int64, int64) {
 	pr.readCloser = io.NopCloser(&io.LimitedReader{
-		R: pr.file,
+		R: pr.reader,
 		N: pr.chunkSize,
 	})
 	startSize := pr.uploaded
@@ -518,13 +1026,18 @@ func (pr *ProgressReader) NextChunk() (int64, int64) {
 	pr.currentSize = endSize - startSize
 	pr.currentUploaded = 0
 	pr.chunkStartTime = time.Now()
-	internal.LogProgress("uploading", pr.file.Name(), pr.startTime, pr.uploaded, pr.uploaded, pr.totalSize, true)
+	internal.LogProgress(pr.logger, "uploading", pr.name, pr.startTime, pr.uploaded, pr.uploaded, pr.totalSize, true)
 	return startSize, endSize
 }
 
+// SetProgress 上报分片完成的进度，一般为UploadStreamReq.ProgressTracker()
+func (pr *ProgressReader) SetProgress(progress *ProgressTracker) {
+	pr.progress = progress
+}
+
 func (pr *ProgressReader) Close() {
-	if pr.file != nil {
-		pr.file.Close()
+	if pr.closer != nil {
+		pr.closer.Close()
 	}
 }
 func (pr *ProgressReader) GetTotal() int64 {
@@ -552,20 +1065,56 @@ func NewProcessReader(localFile string, chunkSize, uploaded int64) (*ProgressRea
 	if stat.IsDir() {
 		return nil, OnlyMsg(localFile + " not a file")
 	}
+	pr, e := NewStreamProgressReader(file, file.Name(), stat.Size(), chunkSize, uploaded)
+	if e != nil {
+		_ = file.Close()
+		return nil, e
+	}
+	pr.closer = file
+	return pr, nil
+}
+
+// NewStreamProgressReader 同包级的NewStreamProgressReader，进度输出到实例所在环境的日志
+func (b *BaseOperate) NewStreamProgressReader(reader io.Reader, name string, totalSize, chunkSize, uploaded int64) (*ProgressReader, DriverErrorInterface) {
+	pr, e := NewStreamProgressReader(reader, name, totalSize, chunkSize, uploaded)
+	if e != nil {
+		return nil, e
+	}
+	pr.logger = b.logger()
+	return pr, nil
+}
+
+// NewProgressWriter 同包级的NewProgressWriter，进度输出到实例所在环境的日志
+func (b *BaseOperate) NewProgressWriter(filename string, total int64) *ProgressWriter {
+	pw := NewProgressWriter(filename, total)
+	pw.logger = b.logger()
+	return pw
+}
+
+// NewStreamProgressReader 基于流创建分片读取器，uploaded大于0时，可Seek的流直接跳转，否则丢弃已上传的字节
+// 流由调用方负责关闭
+func NewStreamProgressReader(reader io.Reader, name string, totalSize, chunkSize, uploaded int64) (*ProgressReader, DriverErrorInterface) {
 	// 计算剩余字节数
-	totalSize := stat.Size()
 	leftSize := totalSize - uploaded
 
 	chunkNum := (leftSize / chunkSize) + 1
 	if uploaded > 0 {
 		// 将文件指针移动到指定的分片位置
-		ret, _ := file.Seek(uploaded, 0)
-		if ret == 0 {
-			return nil, OnlyMsg(localFile + " seek file failed")
+		if seeker, ok := reader.(io.Seeker); ok {
+			ret, _ := seeker.Seek(uploaded, io.SeekStart)
+			if ret == 0 {
+				return nil, OnlyMsg(name + " seek file failed")
+			}
+		} else {
+			_, err := io.CopyN(io.Discard, reader, uploaded)
+			if err != nil {
+				return nil, MsgError(name+" skip uploaded failed", err)
+			}
 		}
 	}
 	return &ProgressReader{
-		file:            file,
+		reader:          reader,
+		name:            name,
 		uploaded:        uploaded,
 		chunkSize:       chunkSize,
 		totalSize:       totalSize,
@@ -576,6 +1125,7 @@ func NewProcessReader(localFile string, chunkSize, uploaded int64) (*ProgressRea
 }
 
 type ProgressWriter struct {
+	logger    *logrus.Logger
 	startTime time.Time
 	totalSize int64
 	uploaded  int64
@@ -585,7 +1135,7 @@ type ProgressWriter struct {
 func (pw *ProgressWriter) Write(b []byte) (n int, err error) {
 	n = len(b)
 	pw.uploaded += int64(n)
-	internal.LogProgress("uploading", pw.filename, pw.startTime, pw.uploaded, pw.uploaded, pw.totalSize, false)
+	internal.LogProgress(pw.logger, "uploading", pw.filename, pw.startTime, pw.uploaded, pw.uploaded, pw.totalSize, false)
 	return
 }
 
@@ -1,22 +1,26 @@
 package cloudreve
 
 import (
-	"fmt"
+	"context"
+	"encoding/gob"
 	"github.com/google/uuid"
 	"github.com/hefeiyu2025/pan-client/internal"
 	"github.com/hefeiyu2025/pan-client/pan"
 	"github.com/imroc/req/v3"
-	logger "github.com/sirupsen/logrus"
+	"io"
 	"net/http"
-	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type Cloudreve struct {
-	sessionClient *req.Client
+	// session 带cookie的client，与配置一起整个替换
+	session       atomic.Pointer[req.Client]
+	sessionMu     sync.Mutex
 	defaultClient *req.Client
 	pan.PropertiesOperate[*CloudreveProperties]
 	pan.CacheOperate
@@ -25,14 +29,14 @@ type Cloudreve struct {
 }
 
 type CloudreveProperties struct {
-	Id           string            `mapstructure:"id" json:"id" yaml:"id"`
-	Url          string            `mapstructure:"url" json:"url" yaml:"url"`
-	Type         string            `mapstructure:"type" json:"type" yaml:"type" default:"now61"`
-	Session      string            `mapstructure:"session" json:"session" yaml:"session"`
+	Id           string            `mapstructure:"id" json:"id" yaml:"id" desc:"账号id，为空时自动生成"`
+	Url          string            `mapstructure:"url" json:"url" yaml:"url" validate:"required,url" desc:"站点地址，如https://pan.example.com"`
+	Type         string            `mapstructure:"type" json:"type" yaml:"type" default:"now61" validate:"oneof=now61 huang1111 hefamily yiandrive wuaipan hucl" desc:"站点类型，决定上传方式"`
+	Session      string            `mapstructure:"session" json:"session" yaml:"session" secret:"true" validate:"required" desc:"登录后cookie中的cloudreve-session"`
 	RefreshTime  int64             `mapstructure:"refresh_time" json:"refresh_time" yaml:"refresh_time" default:"0"`
-	ChunkSize    int64             `mapstructure:"chunk_size" json:"chunk_size" yaml:"chunk_size" default:"104857600"` // 100M
-	SkipVerify   bool              `mapstructure:"skip_verify" json:"skip_verify" yaml:"skip_verify" default:"false"`  // 100M
-	OtherCookies map[string]string `mapstructure:"other_cookies" json:"other_cookies" yaml:"other_cookies"`
+	ChunkSize    int64             `mapstructure:"chunk_size" json:"chunk_size" yaml:"chunk_size" default:"104857600" validate:"min=1" desc:"上传分片大小，单位字节"` // 100M
+	SkipVerify   bool              `mapstructure:"skip_verify" json:"skip_verify" yaml:"skip_verify" default:"false" desc:"跳过https证书校验"`
+	OtherCookies map[string]string `mapstructure:"other_cookies" json:"other_cookies" yaml:"other_cookies" desc:"其他需要携带的cookie"`
 }
 
 func (cp *CloudreveProperties) OnlyImportProperties() {
@@ -51,28 +55,18 @@ func (cp *CloudreveProperties) GetDriverType() pan.DriverType {
 }
 
 func (c *Cloudreve) Init() (string, error) {
+	ctx := context.Background()
 	err := c.ReadConfig()
 	if err != nil {
 		return "", err
 	}
 	driverId := c.GetId()
-	if c.Properties.Url == "" || c.Properties.Session == "" {
+	c.SetInstanceId(driverId)
+	if err = c.ValidateConfig(); err != nil {
 		_ = c.WriteConfig()
-		return driverId, fmt.Errorf("please set cloudreve url and session")
-	}
-	c.sessionClient = req.C().SetCommonHeader(HeaderUserAgent, DefaultUserAgent).
-		SetCommonHeader("Accept", "application/json, text/plain, */*").
-		SetTimeout(30 * time.Minute).SetBaseURL(c.Properties.Url + "/api/v3").
-		SetCommonCookies(&http.Cookie{Name: CookieSessionKey, Value: c.Properties.Session})
-	if c.Properties.SkipVerify {
-		c.sessionClient.EnableInsecureSkipVerify()
-	}
-	if len(c.Properties.OtherCookies) > 0 {
-		for k, v := range c.Properties.OtherCookies {
-			logger.Info(k, v)
-			c.sessionClient.SetCommonCookies(&http.Cookie{Name: k, Value: v})
-		}
+		return driverId, err
 	}
+	c.setSession(c.Props())
 	c.defaultClient = req.C().SetCommonHeader(HeaderUserAgent, DefaultUserAgent).SetTimeout(2 * time.Hour)
 	c.defaultClient.GetTransport().
 		WrapRoundTripFunc(func(rt http.RoundTripper) req.HttpRoundTripFunc {
@@ -87,8 +81,8 @@ func (c *Cloudreve) Init() (string, error) {
 			}
 		})
 	// 若一小时内更新过，则不重新刷session
-	if c.Properties.RefreshTime == 0 || time.Now().UnixMilli()-c.Properties.RefreshTime > 60*60*1000 {
-		_, err = c.config()
+	if refreshTime := c.Props().RefreshTime; refreshTime == 0 || time.Now().UnixMilli()-refreshTime > 60*60*1000 {
+		_, err = c.config(ctx)
 		if err != nil {
 			return driverId, err
 		} else {
@@ -101,19 +95,97 @@ func (c *Cloudreve) Init() (string, error) {
 	return driverId, nil
 }
 
+func (c *Cloudreve) newSessionClient(properties *CloudreveProperties) *req.Client {
+	sessionClient := req.C().SetCommonHeader(HeaderUserAgent, DefaultUserAgent).
+		SetCommonHeader("Accept", "application/json, text/plain, */*").
+		SetTimeout(30 * time.Minute).SetBaseURL(properties.Url + "/api/v3").
+		SetCommonCookies(&http.Cookie{Name: CookieSessionKey, Value: properties.Session})
+	if properties.SkipVerify {
+		sessionClient.EnableInsecureSkipVerify()
+	}
+	if len(properties.OtherCookies) > 0 {
+		for k, v := range properties.OtherCookies {
+			c.Logger().Info(k, v)
+			sessionClient.SetCommonCookies(&http.Cookie{Name: k, Value: v})
+		}
+	}
+	return sessionClient
+}
+
+// sessionClient 当前的session，一次操作中只取一次
+func (c *Cloudreve) sessionClient() *req.Client {
+	return c.session.Load()
+}
+
+// setSession 按新的配置创建session后和配置一起替换，已经取到旧session的请求不受影响
+func (c *Cloudreve) setSession(properties *CloudreveProperties) {
+	client := c.newSessionClient(properties)
+	c.SetProperties(properties)
+	c.session.Store(client)
+}
+
+// updateSession 在当前配置的副本上修改后替换
+func (c *Cloudreve) updateSession(update func(properties *CloudreveProperties)) {
+	c.sessionMu.Lock()
+	defer c.sessionMu.Unlock()
+	properties := *c.Props()
+	update(&properties)
+	c.setSession(&properties)
+}
+
+// Reload 配置中的地址或session变化时重建session，已经发出的请求仍使用旧的client
+func (c *Cloudreve) Reload(ctx context.Context) error {
+	properties, changed, err := c.ReloadConfig()
+	if err != nil || !changed {
+		return err
+	}
+	c.sessionMu.Lock()
+	c.setSession(properties)
+	c.sessionMu.Unlock()
+	c.Logger().Infof("cloudreve %s reloaded", c.GetId())
+	return nil
+}
+
 func (c *Cloudreve) InitByCustom(id string, read pan.ConfigRW, write pan.ConfigRW) (string, error) {
 	c.Properties = &CloudreveProperties{Id: id}
+	c.PropertiesOperate.AccountId = id
 	c.PropertiesOperate.Write = write
 	c.PropertiesOperate.Read = read
 	return c.Init()
 }
 
 func (c *Cloudreve) Drop() error {
-	return pan.OnlyMsg("not support")
+	c.CancelAll()
+	if c.Discarded() {
+		// 上传会话、缓存和注册属于同账号的已有实例
+		return nil
+	}
+	var err pan.DriverErrorInterface
+	if c.sessionClient() != nil {
+		// 服务端未完成的上传会话一并清除
+		_, err = c.fileUploadDeleteAllUploadSession(context.Background())
+	}
+	c.ClearCache()
+	c.Unregister(c.GetId())
+	if err != nil {
+		return err
+	}
+	return nil
 }
 
-func (c *Cloudreve) Disk() (*pan.DiskResp, error) {
-	storageResp, err := c.userStorage()
+func (c *Cloudreve) Capabilities() pan.Capabilities {
+	return pan.Capabilities{
+		Drop:            true,
+		ResumableUpload: true,
+		DirectLink:      true,
+		Copy:            true,
+		Search:          true,
+		NativeStat:      true,
+	}
+}
+
+func (c *Cloudreve) Disk(ctx context.Context) (*pan.DiskResp, error) {
+	storageResp, err := c.userStorage(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -123,7 +195,7 @@ func (c *Cloudreve) Disk() (*pan.DiskResp, error) {
 		Used:  int64(storageResp.Data.Used / 1024 / 1024),
 	}, nil
 }
-func (c *Cloudreve) List(req pan.ListReq) ([]*pan.PanObj, error) {
+func (c *Cloudreve) List(ctx context.Context, req pan.ListReq) ([]*pan.PanObj, error) {
 	if req.Dir.Path == "/" && req.Dir.Name == "" {
 		req.Dir.Id = "0"
 	}
@@ -132,21 +204,14 @@ func (c *Cloudreve) List(req pan.ListReq) ([]*pan.PanObj, error) {
 		c.Del(cacheKey)
 	}
 	panObjs, exist, err := c.GetOrDefault(cacheKey, func() (interface{}, error) {
-		directory, e := c.listDirectory(strings.TrimRight(req.Dir.Path, "/") + "/" + req.Dir.Name)
+		directory, e := c.listDirectory(ctx, strings.TrimRight(req.Dir.Path, "/")+"/"+req.Dir.Name)
 		if e != nil {
-			logger.Error(e)
+			c.Logger().Error(e)
 			return nil, e
 		}
 		panObjs := make([]*pan.PanObj, 0)
 		for _, item := range directory.Data.Objects {
-			panObjs = append(panObjs, &pan.PanObj{
-				Id:     item.ID,
-				Name:   item.Name,
-				Path:   item.Path,
-				Size:   int64(item.Size),
-				Type:   item.Type,
-				Parent: req.Dir,
-			})
+			panObjs = append(panObjs, objectToPanObj(item, req.Dir))
 		}
 		c.Set(cachePolicy, directory.Data.Policy)
 		return panObjs, nil
@@ -162,14 +227,148 @@ func (c *Cloudreve) List(req pan.ListReq) ([]*pan.PanObj, error) {
 	}
 	return make([]*pan.PanObj, 0), nil
 }
-func (c *Cloudreve) ObjRename(req pan.ObjRenameReq) error {
+
+// ListPage cloudreve的目录接口不支持分页，第一页时重新拉取整个目录，之后基于缓存按偏移量切分
+func (c *Cloudreve) ListPage(ctx context.Context, req pan.ListPageReq) (*pan.ListPageResp, error) {
+	// token即偏移量
+	offset := 0
+	if req.PageToken != "" {
+		o, e := strconv.Atoi(req.PageToken)
+		if e != nil || o < 0 {
+			return nil, pan.OnlyMsg("invalid page token " + req.PageToken)
+		}
+		offset = o
+	}
+	objs, err := c.List(ctx, pan.ListReq{
+		Reload: req.PageToken == "",
+		Dir:    req.Dir,
+	})
+	if err != nil {
+		return nil, err
+	}
+	size := req.PageSize
+	if size <= 0 {
+		size = len(objs)
+	}
+	offset = min(offset, len(objs))
+	end := min(offset+size, len(objs))
+	resp := &pan.ListPageResp{
+		Objs: objs[offset:end],
+	}
+	if end < len(objs) {
+		resp.NextPageToken = strconv.Itoa(end)
+	}
+	return resp, nil
+}
+
+func objectToPanObj(item Object, parent *pan.PanObj) *pan.PanObj {
+	mimeType := ""
+	if item.Type == "file" {
+		mimeType = internal.GetMimeType(item.Name)
+	}
+	return &pan.PanObj{
+		Id:         item.ID,
+		Name:       item.Name,
+		Path:       item.Path,
+		Size:       int64(item.Size),
+		Type:       item.Type,
+		ModTime:    item.Date,
+		CreateTime: item.CreateDate,
+		MimeType:   mimeType,
+		Parent:     parent,
+	}
+}
+
+func (c *Cloudreve) Stat(ctx context.Context, path string) (*pan.PanObj, error) {
+	parentPath, name := pan.SplitPath(path)
+	if name == "" {
+		return pan.DirObj("0", "/"), nil
+	}
+	directory, err := c.listDirectory(ctx, parentPath)
+	if err != nil {
+		if err.GetCode() == CodeParentNotExist {
+			return nil, pan.NotFound(path)
+		}
+		return nil, err
+	}
+	parent := pan.DirObj(directory.Data.Parent, parentPath)
+	for _, item := range directory.Data.Objects {
+		if item.Name != name {
+			continue
+		}
+		obj := objectToPanObj(item, parent)
+		props, e := c.objectGetProperty(ctx, ItemPropertyReq{
+			Id:       item.ID,
+			IsFolder: item.Type == "dir",
+		})
+		if e != nil {
+			return nil, e
+		}
+		obj.Size = int64(props.Data.Size)
+		obj.ModTime = props.Data.UpdatedAt
+		obj.CreateTime = props.Data.CreatedAt
+		return obj, nil
+	}
+	return nil, pan.NotFound(path)
+}
+
+// Search 分类搜索时cloudreve会忽略关键字，所以有关键字时按关键字搜索，分类在结果中过滤
+func (c *Cloudreve) Search(ctx context.Context, req pan.SearchReq) ([]*pan.PanObj, error) {
+	searchType := KEYWORDS
+	keyword := req.Keyword
+	if keyword == "" {
+		switch req.Type {
+		case pan.SearchImage:
+			searchType = IMAGE
+		case pan.SearchVideo:
+			searchType = VIDEO
+		case pan.SearchAudio:
+			searchType = AUDIO
+		case pan.SearchDoc:
+			searchType = DOC
+		default:
+			return nil, pan.OnlyMsg("search keyword is empty")
+		}
+		keyword = "internal"
+	}
+	resp, err := c.fileSearch(ctx, keyword, req.ScopePath(), searchType)
+	if err != nil {
+		return nil, err
+	}
+	// 结果只有父目录的路径，按路径获取一次父目录的id，删除等操作需要用来刷新缓存
+	parents := make(map[string]*pan.PanObj)
+	result := make([]*pan.PanObj, 0)
+	for _, item := range resp.Data.Objects {
+		obj := objectToPanObj(item, nil)
+		if !req.Match(obj) || !req.InScope(obj) {
+			continue
+		}
+		parent, ok := parents[item.Path]
+		if !ok {
+			parent = pan.DirObj("", item.Path)
+			if parent.Id != "0" {
+				directory, e := c.listDirectory(ctx, item.Path)
+				if e != nil {
+					return nil, e
+				}
+				parent.Id = directory.Data.Parent
+			}
+			parents[item.Path] = parent
+		}
+		obj.Parent = parent
+		result = append(result, obj)
+	}
+	return result, nil
+}
+
+func (c *Cloudreve) ObjRename(ctx context.Context, req pan.ObjRenameReq) error {
 	if req.Obj.Id == "0" || (req.Obj.Path == "/" && req.Obj.Name == "") {
 		return pan.OnlyMsg("not support rename root path")
 	}
 	object := req.Obj
 	if object.Id == "" {
 		path := strings.Trim(req.Obj.Path, "/") + "/" + req.Obj.Name
-		obj, err := c.GetPanObj(path, true, c.List)
+		obj, err := c.GetPanObj(ctx, path, true, c.List)
 		if err != nil {
 			return err
 		}
@@ -181,7 +380,7 @@ func (c *Cloudreve) ObjRename(req pan.ObjRenameReq) error {
 	} else {
 		item.Items = []string{object.Id}
 	}
-	_, err := c.objectRename(ItemRenameReq{Src: item,
+	_, err := c.objectRename(ctx, ItemRenameReq{Src: item,
 		NewName: req.NewName})
 	if err != nil {
 		return err
@@ -189,39 +388,10 @@ func (c *Cloudreve) ObjRename(req pan.ObjRenameReq) error {
 	c.Del(cacheDirectoryPrefix + object.Parent.Id)
 	return nil
 }
-func (c *Cloudreve) BatchRename(req pan.BatchRenameReq) error {
-	objs, err := c.List(pan.ListReq{
-		Reload: true,
-		Dir:    req.Path,
-	})
-	if err != nil {
-		return err
-	}
-	for _, object := range objs {
-		if object.Type == "dir" {
-			err = c.BatchRename(pan.BatchRenameReq{
-				Path: object,
-				Func: req.Func,
-			})
-			if err != nil {
-				return err
-			}
-		}
-		newName := req.Func(object)
-
-		if newName != object.Name {
-			err = c.ObjRename(pan.ObjRenameReq{
-				Obj:     object,
-				NewName: newName,
-			})
-			if err != nil {
-				return err
-			}
-		}
-	}
-	return nil
+func (c *Cloudreve) BatchRename(ctx context.Context, req pan.BatchRenameReq) error {
+	return c.BaseBatchRename(ctx, req, c.List, c.ObjRename)
 }
-func (c *Cloudreve) Mkdir(req pan.MkdirReq) (*pan.PanObj, error) {
+func (c *Cloudreve) Mkdir(ctx context.Context, req pan.MkdirReq) (*pan.PanObj, error) {
 	if req.NewPath == "" {
 		// 不处理，直接返回
 		return &pan.PanObj{
@@ -239,7 +409,7 @@ func (c *Cloudreve) Mkdir(req pan.MkdirReq) (*pan.PanObj, error) {
 	if req.Parent != nil && (req.Parent.Id == "0" || req.Parent.Path == "/") {
 		targetPath = req.Parent.Path + "/" + strings.Trim(req.NewPath, "/")
 	}
-	obj, err := c.GetPanObj(targetPath, false, c.List)
+	obj, err := c.GetPanObj(ctx, targetPath, false, c.List)
 	if err != nil {
 		return nil, err
 	}
@@ -257,15 +427,15 @@ func (c *Cloudreve) Mkdir(req pan.MkdirReq) (*pan.PanObj, error) {
 		}
 		split := strings.Split(rel, "/")
 
-		_, err = c.createDirectory(existPath + "/" + split[0])
+		_, err = c.createDirectory(ctx, existPath+"/"+split[0])
 		if err != nil {
 			return nil, pan.OnlyError(err)
 		}
 		c.Del(cacheDirectoryPrefix + obj.Id)
-		return c.Mkdir(req)
+		return c.Mkdir(ctx, req)
 	}
 }
-func (c *Cloudreve) Move(req pan.MovieReq) error {
+func (c *Cloudreve) Move(ctx context.Context, req pan.MovieReq) error {
 	sameSrc := make(map[string][]*pan.PanObj)
 	for _, item := range req.Items {
 		objs, ok := sameSrc[item.Path]
@@ -282,7 +452,7 @@ func (c *Cloudreve) Move(req pan.MovieReq) error {
 	}
 	// 重新直接创建目标目录
 	if targetObj.Id == "" {
-		create, err := c.Mkdir(pan.MkdirReq{
+		create, err := c.Mkdir(ctx, pan.MkdirReq{
 			NewPath: strings.Trim(targetObj.Path, "/") + "/" + targetObj.Name,
 		})
 		if err != nil {
@@ -303,7 +473,7 @@ func (c *Cloudreve) Move(req pan.MovieReq) error {
 					itemIds = append(itemIds, item.Id)
 				}
 			} else if item.Path != "" && item.Path != "/" {
-				obj, err := c.GetPanObj(strings.Trim(item.Path, "/")+"/"+item.Name, true, c.List)
+				obj, err := c.GetPanObj(ctx, strings.Trim(item.Path, "/")+"/"+item.Name, true, c.List)
 				if err == nil {
 					if obj.Type == "dir" {
 						dirIds = append(dirIds, obj.Id)
@@ -315,7 +485,7 @@ func (c *Cloudreve) Move(req pan.MovieReq) error {
 				}
 			}
 		}
-		_, err := c.objectMove(ItemMoveReq{
+		_, err := c.objectMove(ctx, ItemMoveReq{
 			SrcDir: src,
 			Dst:    strings.Trim(targetObj.Path, "/") + "/" + targetObj.Name,
 			Src: Item{
@@ -333,7 +503,58 @@ func (c *Cloudreve) Move(req pan.MovieReq) error {
 	}
 	return nil
 }
-func (c *Cloudreve) Delete(req pan.DeleteReq) error {
+func (c *Cloudreve) Copy(ctx context.Context, req pan.CopyReq) error {
+	targetObj := req.TargetObj
+	if targetObj.Type == "file" {
+		return pan.OnlyMsg("target is a file")
+	}
+	// 重新直接创建目标目录
+	if targetObj.Id == "" {
+		create, err := c.Mkdir(ctx, pan.MkdirReq{
+			NewPath: strings.Trim(targetObj.Path, "/") + "/" + targetObj.Name,
+		})
+		if err != nil {
+			return err
+		}
+		targetObj = create
+	}
+	reloadDirId := make(map[string]any)
+	for _, item := range req.Items {
+		obj := item
+		if item.Id == "0" || item.Id == "" {
+			if item.Path == "" || item.Path == "/" {
+				continue
+			}
+			o, err := c.GetPanObj(ctx, strings.Trim(item.Path, "/")+"/"+item.Name, true, c.List)
+			if err != nil {
+				return err
+			}
+			obj = o
+		}
+		src := Item{}
+		if obj.Type == "dir" {
+			src.Dirs = []string{obj.Id}
+			reloadDirId[obj.Id] = true
+		} else {
+			src.Items = []string{obj.Id}
+		}
+		// cloudreve每次只能复制一个对象
+		_, err := c.objectCopy(ctx, ItemMoveReq{
+			SrcDir: obj.Path,
+			Dst:    "/" + strings.Trim(strings.Trim(targetObj.Path, "/")+"/"+targetObj.Name, "/"),
+			Src:    src,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	reloadDirId[targetObj.Id] = true
+	for key := range reloadDirId {
+		c.Del(cacheDirectoryPrefix + key)
+	}
+	return nil
+}
+func (c *Cloudreve) Delete(ctx context.Context, req pan.DeleteReq) error {
 	if len(req.Items) == 0 {
 		return nil
 	}
@@ -352,7 +573,7 @@ func (c *Cloudreve) Delete(req pan.DeleteReq) error {
 				}
 			}
 		} else if item.Path != "" && item.Path != "/" {
-			obj, err := c.GetPanObj(item.Path, true, c.List)
+			obj, err := c.GetPanObj(ctx, item.Path, true, c.List)
 			if err == nil {
 				if obj.Type == "dir" {
 					dirIds = append(dirIds, obj.Id)
@@ -365,7 +586,7 @@ func (c *Cloudreve) Delete(req pan.DeleteReq) error {
 		}
 	}
 	if len(itemIds) > 0 || len(dirIds) > 0 {
-		_, err := c.objectDelete(ItemReq{
+		_, err := c.objectDelete(ctx, ItemReq{
 			Item: Item{
 				Items: itemIds,
 				Dirs:  dirIds,
@@ -384,14 +605,14 @@ func (c *Cloudreve) Delete(req pan.DeleteReq) error {
 	return nil
 }
 
-func (c *Cloudreve) UploadPath(req pan.UploadPathReq) error {
+func (c *Cloudreve) UploadPath(ctx context.Context, req pan.UploadPathReq) error {
 	if req.OnlyFast {
-		return pan.OnlyMsg("cloudreve is not support fast upload")
+		return pan.NotSupported("cloudreve fast upload")
 	}
-	return c.BaseUploadPath(req, c.UploadFile)
+	return c.BaseUploadPath(ctx, req, c.UploadFile)
 }
 
-func (c *Cloudreve) uploadErrAfter(md5Key string, uploadedSize int64, session UploadCredential) {
+func (c *Cloudreve) uploadErrAfter(ctx context.Context, md5Key string, uploadedSize int64, session UploadCredential) {
 	c.Set(cacheChunkPrefix+md5Key, uploadedSize)
 	errorTimes, _, _ := c.GetOrDefault(cacheSessionErrPrefix+md5Key, func() (interface{}, error) {
 		return 0, nil
@@ -399,9 +620,9 @@ func (c *Cloudreve) uploadErrAfter(md5Key string, uploadedSize int64, session Up
 	i := errorTimes.(int)
 	if i > 3 {
 		if session.SessionID != "" {
-			_, _ = c.fileUploadDeleteUploadSession(session.SessionID)
+			_, _ = c.fileUploadDeleteUploadSession(ctx, session.SessionID)
 		} else {
-			_, _ = c.fileUploadDeleteAllUploadSession()
+			_, _ = c.fileUploadDeleteAllUploadSession(ctx)
 		}
 		c.Del(cacheSessionPrefix + md5Key)
 		c.Del(cacheChunkPrefix + md5Key)
@@ -410,15 +631,24 @@ func (c *Cloudreve) uploadErrAfter(md5Key string, uploadedSize int64, session Up
 	c.Set(cacheSessionErrPrefix+md5Key, i+1)
 }
 
-func (c *Cloudreve) UploadFile(req pan.UploadFileReq) error {
+func (c *Cloudreve) UploadFile(ctx context.Context, req pan.UploadFileReq) error {
 	if req.OnlyFast {
-		return pan.OnlyMsg("cloudreve is not support fast upload")
+		return pan.NotSupported("cloudreve fast upload")
 	}
-	stat, err := os.Stat(req.LocalFile)
-	if err != nil {
-		return err
+	return c.BaseUploadFile(ctx, req, c.UploadStream)
+}
+
+func (c *Cloudreve) UploadStream(ctx context.Context, req pan.UploadStreamReq) error {
+	return c.BaseUploadStream(ctx, req, c.uploadStream)
+}
+
+func (c *Cloudreve) uploadStream(ctx context.Context, req pan.UploadStreamReq) error {
+	ctx, cancel := c.BindContext(ctx)
+	defer cancel()
+	if req.OnlyFast {
+		return pan.NotSupported("cloudreve fast upload")
 	}
-	remoteName := stat.Name()
+	remoteName := req.Name
 	remotePath := strings.TrimRight(req.RemotePath, "/")
 	if req.RemotePathTransfer != nil {
 		remotePath = req.RemotePathTransfer(remotePath)
@@ -427,12 +657,12 @@ func (c *Cloudreve) UploadFile(req pan.UploadFileReq) error {
 		remoteName = req.RemoteNameTransfer(remoteName)
 	}
 	remoteAllPath := remotePath + "/" + remoteName
-	_, err = c.GetPanObj(remoteAllPath, true, c.List)
+	_, err := c.GetPanObj(ctx, remoteAllPath, true, c.List)
 	// 没有报错证明文件已经存在
 	if err == nil {
-		return pan.CodeMsg(CodeObjectExist, remoteAllPath+" is exist")
+		return codeMsg(CodeObjectExist, remoteAllPath+" is exist")
 	}
-	_, err = c.Mkdir(pan.MkdirReq{
+	_, err = c.Mkdir(ctx, pan.MkdirReq{
 		NewPath: remotePath,
 	})
 	if err != nil {
@@ -448,6 +678,10 @@ func (c *Cloudreve) UploadFile(req pan.UploadFileReq) error {
 	if obj, exist := c.Get(cacheChunkPrefix + md5Key); exist {
 		uploadedSize = obj.(int64)
 	}
+	lastModified := req.ModTime.UnixMilli()
+	if req.ModTime.IsZero() {
+		lastModified = time.Now().UnixMilli()
+	}
 	var session UploadCredential
 	data, exist, e := c.GetOrDefault(cacheSessionPrefix+md5Key, func() (interface{}, error) {
 		policy, exist := c.Get(cachePolicy)
@@ -455,23 +689,23 @@ func (c *Cloudreve) UploadFile(req pan.UploadFileReq) error {
 			return nil, pan.OnlyMsg(cachePolicy + " is not exist")
 		}
 		summary := policy.(*PolicySummary)
-		resp, e := c.fileUploadGetUploadSession(CreateUploadSessionReq{
+		resp, e := c.fileUploadGetUploadSession(ctx, CreateUploadSessionReq{
 			Path:         "/" + remotePath,
-			Size:         uint64(stat.Size()),
+			Size:         uint64(req.Size),
 			Name:         remoteName,
 			PolicyID:     summary.ID,
-			LastModified: stat.ModTime().UnixMilli(),
+			LastModified: lastModified,
 		})
 		if e != nil {
 			if e.GetCode() == CodeConflictUploadOngoing {
 				// 要是存在重复的文件，直接删掉别的seesion再上传
-				_, _ = c.fileUploadDeleteAllUploadSession()
-				sResp, secE := c.fileUploadGetUploadSession(CreateUploadSessionReq{
+				_, _ = c.fileUploadDeleteAllUploadSession(ctx)
+				sResp, secE := c.fileUploadGetUploadSession(ctx, CreateUploadSessionReq{
 					Path:         "/" + remotePath,
-					Size:         uint64(stat.Size()),
+					Size:         uint64(req.Size),
 					Name:         remoteName,
 					PolicyID:     summary.ID,
-					LastModified: stat.ModTime().UnixMilli(),
+					LastModified: lastModified,
 				})
 				if secE != nil {
 					return nil, secE
@@ -488,38 +722,46 @@ func (c *Cloudreve) UploadFile(req pan.UploadFileReq) error {
 	if exist {
 		session = data.(UploadCredential)
 	}
-	switch c.Properties.Type {
+	reader := c.UploadReader(ctx, req)
+	properties := c.Props()
+	switch properties.Type {
 	case Now61, Yiandrive, Wuaipan:
-		uploadedSize, err = c.notKnowUpload(NotKnowUploadReq{
+		uploadedSize, err = c.notKnowUpload(ctx, NotKnowUploadReq{
 			UploadUrl:    session.UploadURLs[0],
 			Credential:   session.Credential,
-			LocalFile:    req.LocalFile,
+			Reader:       reader,
+			Name:         remoteName,
+			Size:         req.Size,
 			UploadedSize: uploadedSize,
 			ChunkSize:    int64(session.ChunkSize),
+			Progress:     req.ProgressTracker(),
 		})
 		if err != nil {
-			c.uploadErrAfter(md5Key, uploadedSize, session)
+			c.uploadErrAfter(ctx, md5Key, uploadedSize, session)
 			return err
 		}
 	case Huang1111, Hefamily, Hucl:
-		uploadedSize, err = c.oneDriveUpload(OneDriveUploadReq{
+		uploadedSize, err = c.oneDriveUpload(ctx, OneDriveUploadReq{
 			UploadUrl:    session.UploadURLs[0],
-			LocalFile:    req.LocalFile,
+			Reader:       reader,
+			Name:         remoteName,
+			Size:         req.Size,
 			UploadedSize: uploadedSize,
-			ChunkSize:    min(int64(session.ChunkSize), c.Properties.ChunkSize),
+			ChunkSize:    min(int64(session.ChunkSize), properties.ChunkSize),
+			Progress:     req.ProgressTracker(),
 		})
 		if err != nil {
-			c.uploadErrAfter(md5Key, uploadedSize, session)
+			c.uploadErrAfter(ctx, md5Key, uploadedSize, session)
 			return err
 		}
 
-		_, err = c.oneDriveCallback(session.SessionID)
+		_, err = c.oneDriveCallback(ctx, session.SessionID)
 		if err != nil {
-			c.uploadErrAfter(md5Key, uploadedSize, session)
+			c.uploadErrAfter(ctx, md5Key, uploadedSize, session)
 			return err
 		}
 	default:
-		return pan.OnlyMsg("not support Type")
+		return pan.NotSupported("policy type " + properties.Type)
 	}
 
 	if req.Resumable {
@@ -527,61 +769,65 @@ func (c *Cloudreve) UploadFile(req pan.UploadFileReq) error {
 		c.Del(cacheChunkPrefix + md5Key)
 		c.Del(cacheSessionErrPrefix + md5Key)
 	}
-	logger.Infof("upload success %s", req.LocalFile)
-	// 上传成功则移除文件了
-	if req.SuccessDel {
-		err = os.Remove(req.LocalFile)
-		if err != nil {
-			logger.Errorf("delete fail %s,%v", req.LocalFile, err)
-		} else {
-			logger.Infof("delete success %s", req.LocalFile)
-		}
+	c.Logger().Infof("upload success %s", remoteAllPath)
+	return nil
+}
 
+func (c *Cloudreve) DownloadPath(ctx context.Context, req pan.DownloadPathReq) error {
+	return c.BaseDownloadPath(ctx, req, c.List, c.DownloadFile)
+}
+func (c *Cloudreve) downloadUrl(ctx context.Context, req pan.DownloadFileReq) (string, error) {
+	resp, err := c.fileCreateDownloadSession(ctx, req.RemoteFile.Id)
+	if err != nil {
+		return "", err
 	}
-	return nil
+	return resp.Data, nil
 }
 
-func (c *Cloudreve) DownloadPath(req pan.DownloadPathReq) error {
-	return c.BaseDownloadPath(req, c.List, c.DownloadFile)
+func (c *Cloudreve) DownloadFile(ctx context.Context, req pan.DownloadFileReq) error {
+	ctx, cancel := c.BindContext(ctx)
+	defer cancel()
+	return c.BaseDownloadFile(ctx, req, c.defaultClient, c.downloadUrl)
 }
-func (c *Cloudreve) DownloadFile(req pan.DownloadFileReq) error {
-	return c.BaseDownloadFile(req, c.defaultClient, func(req pan.DownloadFileReq) (string, error) {
-		resp, err := c.fileCreateDownloadSession(req.RemoteFile.Id)
-		if err != nil {
-			return "", err
-		}
-		return resp.Data, nil
-	})
+
+func (c *Cloudreve) Open(ctx context.Context, req pan.OpenReq) (io.ReadCloser, error) {
+	ctx, cancel := c.BindContext(ctx)
+	rc, err := c.BaseOpen(ctx, req, c.defaultClient, c.downloadUrl)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	return pan.BindReadCloser(rc, cancel), nil
 }
 
-func (c *Cloudreve) OfflineDownload(req pan.OfflineDownloadReq) (*pan.Task, error) {
-	return nil, pan.OnlyMsg("offline download not support")
+func (c *Cloudreve) OfflineDownload(ctx context.Context, req pan.OfflineDownloadReq) (*pan.Task, error) {
+	return nil, pan.NotSupported("offline download")
 }
 
-func (c *Cloudreve) TaskList(req pan.TaskListReq) ([]*pan.Task, error) {
-	return nil, pan.OnlyMsg("task list not support")
+func (c *Cloudreve) TaskList(ctx context.Context, req pan.TaskListReq) ([]*pan.Task, error) {
+	return nil, pan.NotSupported("task list")
 }
 
-func (c *Cloudreve) ShareList(req pan.ShareListReq) ([]*pan.ShareData, error) {
-	return nil, pan.OnlyMsg("share list not support")
+func (c *Cloudreve) ShareList(ctx context.Context, req pan.ShareListReq) ([]*pan.ShareData, error) {
+	return nil, pan.NotSupported("share list")
 }
-func (c *Cloudreve) NewShare(req pan.NewShareReq) (*pan.ShareData, error) {
-	return nil, pan.OnlyMsg("new share not support")
+func (c *Cloudreve) NewShare(ctx context.Context, req pan.NewShareReq) (*pan.ShareData, error) {
+	return nil, pan.NotSupported("new share")
 }
-func (c *Cloudreve) DeleteShare(req pan.DelShareReq) error {
-	return pan.OnlyMsg("delete share not support")
+func (c *Cloudreve) DeleteShare(ctx context.Context, req pan.DelShareReq) error {
+	return pan.NotSupported("delete share")
 }
-func (c *Cloudreve) ShareRestore(req pan.ShareRestoreReq) error {
-	return pan.OnlyMsg("share restore not support ")
+func (c *Cloudreve) ShareRestore(ctx context.Context, req pan.ShareRestoreReq) error {
+	return pan.NotSupported("share restore")
 }
 
-func (c *Cloudreve) DirectLink(req pan.DirectLinkReq) ([]*pan.DirectLink, error) {
+func (c *Cloudreve) DirectLink(ctx context.Context, req pan.DirectLinkReq) ([]*pan.DirectLink, error) {
 	fileList := req.List
 	fids := make([]string, 0)
 	for _, file := range fileList {
 		fids = append(fids, file.FileId)
 	}
-	resp, err := c.fileGetSource(ItemReq{
+	resp, err := c.fileGetSource(ctx, ItemReq{
 		Item: Item{Items: fids},
 	})
 	if err != nil {
@@ -602,13 +848,17 @@ func (c *Cloudreve) DirectLink(req pan.DirectLinkReq) ([]*pan.DirectLink, error)
 }
 
 func init() {
-	pan.RegisterDriver(pan.Cloudreve, func() pan.Driver {
+	gob.Register(&PolicySummary{})
+	gob.RegisterName("cloudreve.UploadCredential", UploadCredential{})
+	pan.RegisterDriver(pan.Cloudreve, func(env *pan.Env) pan.Driver {
 		return &Cloudreve{
 			PropertiesOperate: pan.PropertiesOperate[*CloudreveProperties]{
 				DriverType: pan.Cloudreve,
+				Env:        env,
 			},
-			CacheOperate:  pan.CacheOperate{DriverType: pan.Cloudreve},
-			CommonOperate: pan.CommonOperate{},
+			CacheOperate:  pan.CacheOperate{DriverType: pan.Cloudreve, Env: env},
+			CommonOperate: pan.CommonOperate{Env: env},
+			BaseOperate:   pan.BaseOperate{Env: env},
 		}
 	})
 }
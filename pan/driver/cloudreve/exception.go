@@ -1,9 +1,38 @@
 package cloudreve
 
+import "github.com/hefeiyu2025/pan-client/pan"
+
 // 定义异常编码和异常信息
 const (
+	// CodeCheckLogin 未登录
+	CodeCheckLogin = 401
+	// CodeNotFound 资源不存在
+	CodeNotFound = 404
+	// CodeConflict 资源冲突
+	CodeConflict = 409
 	// CodeObjectExist 对象已存在
 	CodeObjectExist = 40004
+	// CodeParentNotExist 父目录不存在
+	CodeParentNotExist = 40016
+	// CodeFeatureNotEnabled 功能未开启
+	CodeFeatureNotEnabled = 40019
+	// CodeCredentialInvalid 凭证无效
+	CodeCredentialInvalid = 40020
 	// CodeConflictUploadOngoing 当前目录下已经有同名文件正在上传中
 	CodeConflictUploadOngoing = 40054
 )
+
+var codeKinds = map[int]error{
+	CodeCheckLogin:        pan.ErrAuthExpired,
+	CodeNotFound:          pan.ErrNotFound,
+	CodeConflict:          pan.ErrAlreadyExists,
+	CodeObjectExist:       pan.ErrAlreadyExists,
+	CodeParentNotExist:    pan.ErrNotFound,
+	CodeFeatureNotEnabled: pan.ErrNotSupported,
+	CodeCredentialInvalid: pan.ErrAuthExpired,
+}
+
+// codeMsg 按cloudreve的错误码归类异常
+func codeMsg(code int, msg string) pan.DriverErrorInterface {
+	return pan.KindCodeMsg(codeKinds[code], code, msg)
+}
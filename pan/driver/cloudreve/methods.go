@@ -1,9 +1,9 @@
 package cloudreve
 
 import (
+	"context"
 	"github.com/hefeiyu2025/pan-client/pan"
 	"github.com/imroc/req/v3"
-	"net/http"
 	"net/url"
 	"strconv"
 	"time"
@@ -14,7 +14,7 @@ func funReturn(err error, response *req.Response, result Resp) (*Resp, pan.Drive
 		return nil, pan.OnlyError(err)
 	}
 	if response.IsErrorState() && result.Code != 0 {
-		return nil, pan.CodeMsg(result.Code, result.Msg)
+		return nil, codeMsg(result.Code, result.Msg)
 	}
 	return &result, pan.NoError()
 }
@@ -23,16 +23,16 @@ func funReturnBySuccess[T any](err error, response *req.Response, errorResult Re
 		return nil, pan.OnlyError(err)
 	}
 	if response.IsErrorState() {
-		return nil, pan.CodeMsg(errorResult.Code, errorResult.Msg)
+		return nil, codeMsg(errorResult.Code, errorResult.Msg)
 	}
 	if successResult.Code != 0 {
-		return nil, pan.CodeMsg(successResult.Code, successResult.Msg)
+		return nil, codeMsg(successResult.Code, successResult.Msg)
 	}
 	return &successResult, pan.NoError()
 }
 
-func (c *Cloudreve) config() (*RespData[SiteConfig], pan.DriverErrorInterface) {
-	r := c.sessionClient.R()
+func (c *Cloudreve) config(ctx context.Context) (*RespData[SiteConfig], pan.DriverErrorInterface) {
+	r := c.sessionClient().R().SetContext(ctx)
 	var successResult RespData[SiteConfig]
 	var errorResult Resp
 	r.SetSuccessResult(&successResult)
@@ -43,17 +43,18 @@ func (c *Cloudreve) config() (*RespData[SiteConfig], pan.DriverErrorInterface) {
 		return nil, pan.OnlyError(err)
 	}
 	if response.IsErrorState() {
-		return nil, pan.CodeMsg(errorResult.Code, errorResult.Msg)
+		return nil, codeMsg(errorResult.Code, errorResult.Msg)
 	}
 	if successResult.Code != 0 {
-		return nil, pan.CodeMsg(successResult.Code, successResult.Msg)
+		return nil, codeMsg(successResult.Code, successResult.Msg)
 	}
 	if !successResult.Data.User.Anonymous {
 		for _, cookie := range response.Cookies() {
 			if cookie.Name == CookieSessionKey {
-				c.Properties.Session = cookie.Value
-				c.Properties.RefreshTime = time.Now().UnixMilli()
-				c.sessionClient.SetCommonCookies(&http.Cookie{Name: CookieSessionKey, Value: c.Properties.Session})
+				c.updateSession(func(properties *CloudreveProperties) {
+					properties.Session = cookie.Value
+					properties.RefreshTime = time.Now().UnixMilli()
+				})
 			}
 		}
 	} else {
@@ -62,8 +63,8 @@ func (c *Cloudreve) config() (*RespData[SiteConfig], pan.DriverErrorInterface) {
 	return &successResult, pan.NoError()
 }
 
-func (c *Cloudreve) userStorage() (*RespData[Storage], pan.DriverErrorInterface) {
-	r := c.sessionClient.R()
+func (c *Cloudreve) userStorage(ctx context.Context) (*RespData[Storage], pan.DriverErrorInterface) {
+	r := c.sessionClient().R().SetContext(ctx)
 	var successResult RespData[Storage]
 	var errorResult Resp
 	r.SetSuccessResult(&successResult)
@@ -73,8 +74,8 @@ func (c *Cloudreve) userStorage() (*RespData[Storage], pan.DriverErrorInterface)
 	return funReturnBySuccess(err, response, errorResult, successResult)
 }
 
-func (c *Cloudreve) fileUploadGetUploadSession(req CreateUploadSessionReq) (*RespData[UploadCredential], pan.DriverErrorInterface) {
-	r := c.sessionClient.R()
+func (c *Cloudreve) fileUploadGetUploadSession(ctx context.Context, req CreateUploadSessionReq) (*RespData[UploadCredential], pan.DriverErrorInterface) {
+	r := c.sessionClient().R().SetContext(ctx)
 	var successResult RespData[UploadCredential]
 	var errorResult Resp
 	r.SetSuccessResult(&successResult)
@@ -85,8 +86,8 @@ func (c *Cloudreve) fileUploadGetUploadSession(req CreateUploadSessionReq) (*Res
 	return funReturnBySuccess(err, response, errorResult, successResult)
 }
 
-func (c *Cloudreve) fileUploadDeleteUploadSession(sessionId string) (*Resp, pan.DriverErrorInterface) {
-	r := c.sessionClient.R()
+func (c *Cloudreve) fileUploadDeleteUploadSession(ctx context.Context, sessionId string) (*Resp, pan.DriverErrorInterface) {
+	r := c.sessionClient().R().SetContext(ctx)
 	var result Resp
 	r.SetSuccessResult(&result)
 	r.SetErrorResult(&result)
@@ -95,8 +96,8 @@ func (c *Cloudreve) fileUploadDeleteUploadSession(sessionId string) (*Resp, pan.
 	return funReturn(err, response, result)
 }
 
-func (c *Cloudreve) fileUploadDeleteAllUploadSession() (*Resp, pan.DriverErrorInterface) {
-	r := c.sessionClient.R()
+func (c *Cloudreve) fileUploadDeleteAllUploadSession(ctx context.Context) (*Resp, pan.DriverErrorInterface) {
+	r := c.sessionClient().R().SetContext(ctx)
 	var result Resp
 	r.SetSuccessResult(&result)
 	r.SetErrorResult(&result)
@@ -109,8 +110,8 @@ func (c *Cloudreve) fileUploadDeleteAllUploadSession() (*Resp, pan.DriverErrorIn
 //
 //}
 
-func (c *Cloudreve) fileCreateFile(path string) (*Resp, pan.DriverErrorInterface) {
-	r := c.sessionClient.R()
+func (c *Cloudreve) fileCreateFile(ctx context.Context, path string) (*Resp, pan.DriverErrorInterface) {
+	r := c.sessionClient().R().SetContext(ctx)
 	var result Resp
 	r.SetSuccessResult(&result)
 	r.SetErrorResult(&result)
@@ -122,8 +123,8 @@ func (c *Cloudreve) fileCreateFile(path string) (*Resp, pan.DriverErrorInterface
 	return funReturn(err, response, result)
 }
 
-func (c *Cloudreve) fileCreateDownloadSession(id string) (*RespData[string], pan.DriverErrorInterface) {
-	r := c.sessionClient.R()
+func (c *Cloudreve) fileCreateDownloadSession(ctx context.Context, id string) (*RespData[string], pan.DriverErrorInterface) {
+	r := c.sessionClient().R().SetContext(ctx)
 	var successResult RespData[string]
 	var errorResult Resp
 	r.SetSuccessResult(&successResult)
@@ -134,7 +135,7 @@ func (c *Cloudreve) fileCreateDownloadSession(id string) (*RespData[string], pan
 }
 
 //func (c *Cloudreve) FilePreview(id string) (string,pan.DriverErrorInterface) {
-//	r := c.sessionClient.R()
+//	r := c.sessionClient().R().SetContext(ctx)
 //
 //
 //	// /file/preview
@@ -150,8 +151,8 @@ func (c *Cloudreve) fileCreateDownloadSession(id string) (*RespData[string], pan
 //
 //}
 
-func (c *Cloudreve) fileGetSource(req ItemReq) (*RespData[[]Sources], pan.DriverErrorInterface) {
-	r := c.sessionClient.R()
+func (c *Cloudreve) fileGetSource(ctx context.Context, req ItemReq) (*RespData[[]Sources], pan.DriverErrorInterface) {
+	r := c.sessionClient().R().SetContext(ctx)
 	var successResult RespData[[]Sources]
 	var errorResult Resp
 	r.SetSuccessResult(&successResult)
@@ -162,8 +163,8 @@ func (c *Cloudreve) fileGetSource(req ItemReq) (*RespData[[]Sources], pan.Driver
 	return funReturnBySuccess(err, response, errorResult, successResult)
 }
 
-func (c *Cloudreve) fileArchive(req ItemReq) (*RespData[string], pan.DriverErrorInterface) {
-	r := c.sessionClient.R()
+func (c *Cloudreve) fileArchive(ctx context.Context, req ItemReq) (*RespData[string], pan.DriverErrorInterface) {
+	r := c.sessionClient().R().SetContext(ctx)
 	var successResult RespData[string]
 	var errorResult Resp
 	r.SetSuccessResult(&successResult)
@@ -173,8 +174,8 @@ func (c *Cloudreve) fileArchive(req ItemReq) (*RespData[string], pan.DriverError
 	response, err := r.Post("/file/archive")
 	return funReturnBySuccess(err, response, errorResult, successResult)
 }
-func (c *Cloudreve) createDirectory(path string) (*Resp, pan.DriverErrorInterface) {
-	r := c.sessionClient.R()
+func (c *Cloudreve) createDirectory(ctx context.Context, path string) (*Resp, pan.DriverErrorInterface) {
+	r := c.sessionClient().R().SetContext(ctx)
 	var result Resp
 	r.SetSuccessResult(&result)
 	r.SetErrorResult(&result)
@@ -186,8 +187,8 @@ func (c *Cloudreve) createDirectory(path string) (*Resp, pan.DriverErrorInterfac
 	return funReturn(err, response, result)
 }
 
-func (c *Cloudreve) listDirectory(path string) (*RespData[ObjectList], pan.DriverErrorInterface) {
-	r := c.sessionClient.R()
+func (c *Cloudreve) listDirectory(ctx context.Context, path string) (*RespData[ObjectList], pan.DriverErrorInterface) {
+	r := c.sessionClient().R().SetContext(ctx)
 	var successResult RespData[ObjectList]
 	var errorResult Resp
 	r.SetSuccessResult(&successResult)
@@ -197,8 +198,22 @@ func (c *Cloudreve) listDirectory(path string) (*RespData[ObjectList], pan.Drive
 	return funReturnBySuccess(err, response, errorResult, successResult)
 }
 
-func (c *Cloudreve) objectDelete(req ItemReq) (*Resp, pan.DriverErrorInterface) {
-	r := c.sessionClient.R()
+func (c *Cloudreve) fileSearch(ctx context.Context, keyword, path string, searchType SearchType) (*RespData[ObjectList], pan.DriverErrorInterface) {
+	r := c.sessionClient().R().SetContext(ctx)
+	var successResult RespData[ObjectList]
+	var errorResult Resp
+	r.SetSuccessResult(&successResult)
+	r.SetErrorResult(&errorResult)
+	r.SetQueryParamsAnyType(pan.Json{
+		"path": path,
+	})
+	// /file/search/:type/:keywords
+	response, err := r.Get("/file/search/" + string(searchType) + "/" + url.PathEscape(keyword))
+	return funReturnBySuccess(err, response, errorResult, successResult)
+}
+
+func (c *Cloudreve) objectDelete(ctx context.Context, req ItemReq) (*Resp, pan.DriverErrorInterface) {
+	r := c.sessionClient().R().SetContext(ctx)
 	var result Resp
 	r.SetSuccessResult(&result)
 	r.SetErrorResult(&result)
@@ -208,8 +223,8 @@ func (c *Cloudreve) objectDelete(req ItemReq) (*Resp, pan.DriverErrorInterface)
 	return funReturn(err, response, result)
 }
 
-func (c *Cloudreve) objectMove(req ItemMoveReq) (*Resp, pan.DriverErrorInterface) {
-	r := c.sessionClient.R()
+func (c *Cloudreve) objectMove(ctx context.Context, req ItemMoveReq) (*Resp, pan.DriverErrorInterface) {
+	r := c.sessionClient().R().SetContext(ctx)
 	var result Resp
 	r.SetSuccessResult(&result)
 	r.SetErrorResult(&result)
@@ -219,8 +234,8 @@ func (c *Cloudreve) objectMove(req ItemMoveReq) (*Resp, pan.DriverErrorInterface
 	return funReturn(err, response, result)
 }
 
-func (c *Cloudreve) objectCopy(req ItemMoveReq) (*Resp, pan.DriverErrorInterface) {
-	r := c.sessionClient.R()
+func (c *Cloudreve) objectCopy(ctx context.Context, req ItemMoveReq) (*Resp, pan.DriverErrorInterface) {
+	r := c.sessionClient().R().SetContext(ctx)
 	var result Resp
 	r.SetSuccessResult(&result)
 	r.SetErrorResult(&result)
@@ -230,8 +245,8 @@ func (c *Cloudreve) objectCopy(req ItemMoveReq) (*Resp, pan.DriverErrorInterface
 	return funReturn(err, response, result)
 }
 
-func (c *Cloudreve) objectRename(req ItemRenameReq) (*Resp, pan.DriverErrorInterface) {
-	r := c.sessionClient.R()
+func (c *Cloudreve) objectRename(ctx context.Context, req ItemRenameReq) (*Resp, pan.DriverErrorInterface) {
+	r := c.sessionClient().R().SetContext(ctx)
 	var result Resp
 	r.SetSuccessResult(&result)
 	r.SetErrorResult(&result)
@@ -241,8 +256,8 @@ func (c *Cloudreve) objectRename(req ItemRenameReq) (*Resp, pan.DriverErrorInter
 	return funReturn(err, response, result)
 }
 
-func (c *Cloudreve) objectGetProperty(req ItemPropertyReq) (*RespData[ObjectProps], pan.DriverErrorInterface) {
-	r := c.sessionClient.R()
+func (c *Cloudreve) objectGetProperty(ctx context.Context, req ItemPropertyReq) (*RespData[ObjectProps], pan.DriverErrorInterface) {
+	r := c.sessionClient().R().SetContext(ctx)
 	var errorResult Resp
 	var successResult RespData[ObjectProps]
 	r.SetSuccessResult(&successResult)
@@ -256,8 +271,8 @@ func (c *Cloudreve) objectGetProperty(req ItemPropertyReq) (*RespData[ObjectProp
 	return funReturnBySuccess(err, response, errorResult, successResult)
 }
 
-func (c *Cloudreve) shareCreateShare(req ShareCreateReq) (*RespData[string], pan.DriverErrorInterface) {
-	r := c.sessionClient.R()
+func (c *Cloudreve) shareCreateShare(ctx context.Context, req ShareCreateReq) (*RespData[string], pan.DriverErrorInterface) {
+	r := c.sessionClient().R().SetContext(ctx)
 	var successResult RespData[string]
 	var errorResult Resp
 	r.SetSuccessResult(&successResult)
@@ -268,8 +283,8 @@ func (c *Cloudreve) shareCreateShare(req ShareCreateReq) (*RespData[string], pan
 	return funReturnBySuccess(err, response, errorResult, successResult)
 }
 
-func (c *Cloudreve) shareListShare() (*RespData[ShareList], pan.DriverErrorInterface) {
-	r := c.sessionClient.R()
+func (c *Cloudreve) shareListShare(ctx context.Context) (*RespData[ShareList], pan.DriverErrorInterface) {
+	r := c.sessionClient().R().SetContext(ctx)
 	var successResult RespData[ShareList]
 	var errorResult Resp
 	r.SetSuccessResult(&successResult)
@@ -279,8 +294,8 @@ func (c *Cloudreve) shareListShare() (*RespData[ShareList], pan.DriverErrorInter
 	return funReturnBySuccess(err, response, errorResult, successResult)
 }
 
-func (c *Cloudreve) shareUpdateShare(req ShareUpdateReq) (*RespData[string], pan.DriverErrorInterface) {
-	r := c.sessionClient.R()
+func (c *Cloudreve) shareUpdateShare(ctx context.Context, req ShareUpdateReq) (*RespData[string], pan.DriverErrorInterface) {
+	r := c.sessionClient().R().SetContext(ctx)
 	var successResult RespData[string]
 	var errorResult Resp
 	r.SetSuccessResult(&successResult)
@@ -294,8 +309,8 @@ func (c *Cloudreve) shareUpdateShare(req ShareUpdateReq) (*RespData[string], pan
 	return funReturnBySuccess(err, response, errorResult, successResult)
 }
 
-func (c *Cloudreve) shareDeleteShare(id string) (*Resp, pan.DriverErrorInterface) {
-	r := c.sessionClient.R()
+func (c *Cloudreve) shareDeleteShare(ctx context.Context, id string) (*Resp, pan.DriverErrorInterface) {
+	r := c.sessionClient().R().SetContext(ctx)
 	var result Resp
 	r.SetSuccessResult(&result)
 	r.SetErrorResult(&result)
@@ -304,8 +319,8 @@ func (c *Cloudreve) shareDeleteShare(id string) (*Resp, pan.DriverErrorInterface
 	return funReturn(err, response, result)
 }
 
-func (c *Cloudreve) shareGetShare(id, password string) (*RespData[Share], pan.DriverErrorInterface) {
-	r := c.defaultClient.R()
+func (c *Cloudreve) shareGetShare(ctx context.Context, id, password string) (*RespData[Share], pan.DriverErrorInterface) {
+	r := c.defaultClient.R().SetContext(ctx)
 	var successResult RespData[Share]
 	var errorResult Resp
 	r.SetSuccessResult(&successResult)
@@ -318,8 +333,8 @@ func (c *Cloudreve) shareGetShare(id, password string) (*RespData[Share], pan.Dr
 	return funReturnBySuccess(err, response, errorResult, successResult)
 }
 
-func (c *Cloudreve) shareGetShareDownload(id, path string) (*RespData[string], pan.DriverErrorInterface) {
-	r := c.defaultClient.R()
+func (c *Cloudreve) shareGetShareDownload(ctx context.Context, id, path string) (*RespData[string], pan.DriverErrorInterface) {
+	r := c.defaultClient.R().SetContext(ctx)
 	var successResult RespData[string]
 	var errorResult Resp
 	r.SetSuccessResult(&successResult)
@@ -332,8 +347,8 @@ func (c *Cloudreve) shareGetShareDownload(id, path string) (*RespData[string], p
 	return funReturnBySuccess(err, response, errorResult, successResult)
 }
 
-func (c *Cloudreve) shareListSharedFolder(id, path string) (*RespData[ObjectList], pan.DriverErrorInterface) {
-	r := c.defaultClient.R()
+func (c *Cloudreve) shareListSharedFolder(ctx context.Context, id, path string) (*RespData[ObjectList], pan.DriverErrorInterface) {
+	r := c.defaultClient.R().SetContext(ctx)
 	var successResult RespData[ObjectList]
 	var errorResult Resp
 	r.SetSuccessResult(&successResult)
@@ -344,8 +359,8 @@ func (c *Cloudreve) shareListSharedFolder(id, path string) (*RespData[ObjectList
 	return funReturnBySuccess(err, response, errorResult, successResult)
 }
 
-func (c *Cloudreve) ShareSearchSharedFolder(id, keyword, path string, searchType SearchType) (*RespData[ObjectList], pan.DriverErrorInterface) {
-	r := c.defaultClient.R()
+func (c *Cloudreve) ShareSearchSharedFolder(ctx context.Context, id, keyword, path string, searchType SearchType) (*RespData[ObjectList], pan.DriverErrorInterface) {
+	r := c.defaultClient.R().SetContext(ctx)
 	var successResult RespData[ObjectList]
 	var errorResult Resp
 	r.SetSuccessResult(&successResult)
@@ -358,8 +373,8 @@ func (c *Cloudreve) ShareSearchSharedFolder(id, keyword, path string, searchType
 	return funReturnBySuccess(err, response, errorResult, successResult)
 }
 
-func (c *Cloudreve) shareSearchShare(req ShareListReq) (*RespData[ShareList], pan.DriverErrorInterface) {
-	r := c.defaultClient.R()
+func (c *Cloudreve) shareSearchShare(ctx context.Context, req ShareListReq) (*RespData[ShareList], pan.DriverErrorInterface) {
+	r := c.defaultClient.R().SetContext(ctx)
 	var successResult RespData[ShareList]
 	var errorResult Resp
 	r.SetSuccessResult(&successResult)
@@ -375,8 +390,8 @@ func (c *Cloudreve) shareSearchShare(req ShareListReq) (*RespData[ShareList], pa
 	return funReturnBySuccess(err, response, errorResult, successResult)
 }
 
-func (c *Cloudreve) oneDriveCallback(sessionId string) (*Resp, pan.DriverErrorInterface) {
-	r := c.sessionClient.R()
+func (c *Cloudreve) oneDriveCallback(ctx context.Context, sessionId string) (*Resp, pan.DriverErrorInterface) {
+	r := c.sessionClient().R().SetContext(ctx)
 	var result Resp
 	r.SetSuccessResult(&result)
 	r.SetErrorResult(&result)
@@ -388,16 +403,17 @@ func (c *Cloudreve) oneDriveCallback(sessionId string) (*Resp, pan.DriverErrorIn
 }
 
 // OneDriveUpload 分片上传 返回已上传的字节数和错误信息
-func (c *Cloudreve) oneDriveUpload(req OneDriveUploadReq) (int64, pan.DriverErrorInterface) {
+func (c *Cloudreve) oneDriveUpload(ctx context.Context, req OneDriveUploadReq) (int64, pan.DriverErrorInterface) {
 	uploadedSize := req.UploadedSize
 
-	pr, err := pan.NewProcessReader(req.LocalFile, req.ChunkSize, uploadedSize)
+	pr, err := c.NewStreamProgressReader(req.Reader, req.Name, req.Size, req.ChunkSize, uploadedSize)
 	if err != nil {
 		return uploadedSize, err
 	}
+	pr.SetProgress(req.Progress)
 	for {
 		startSize, endSize := pr.NextChunk()
-		response, reqErr := c.defaultClient.R().SetBody(pr).
+		response, reqErr := c.defaultClient.R().SetContext(ctx).SetBody(pr).
 			SetContentType("application/octet-stream").
 			SetHeader("Content-Length", strconv.FormatInt(endSize-startSize, 10)).
 			SetHeader("Content-Range", "bytes "+strconv.FormatInt(startSize, 10)+"-"+strconv.FormatInt(endSize-1, 10)+"/"+strconv.FormatInt(pr.GetTotal(), 10)).
@@ -417,15 +433,16 @@ func (c *Cloudreve) oneDriveUpload(req OneDriveUploadReq) (int64, pan.DriverErro
 	return pr.GetUploaded(), pan.NoError()
 }
 
-func (c *Cloudreve) notKnowUpload(req NotKnowUploadReq) (int64, pan.DriverErrorInterface) {
+func (c *Cloudreve) notKnowUpload(ctx context.Context, req NotKnowUploadReq) (int64, pan.DriverErrorInterface) {
 	uploadedSize := req.UploadedSize
-	pr, err := pan.NewProcessReader(req.LocalFile, req.ChunkSize, uploadedSize)
+	pr, err := c.NewStreamProgressReader(req.Reader, req.Name, req.Size, req.ChunkSize, uploadedSize)
 	if err != nil {
 		return uploadedSize, err
 	}
+	pr.SetProgress(req.Progress)
 	for {
 		startSize, endSize := pr.NextChunk()
-		response, reqErr := c.defaultClient.R().SetBody(pr).
+		response, reqErr := c.defaultClient.R().SetContext(ctx).SetBody(pr).
 			SetContentType("application/octet-stream").
 			SetHeader("Content-Length", strconv.FormatInt(endSize-startSize, 10)).
 			SetHeader("Authorization", req.Credential).
@@ -1,6 +1,8 @@
 package cloudreve
 
 import (
+	"github.com/hefeiyu2025/pan-client/pan"
+	"io"
 	"time"
 )
 
@@ -304,15 +306,21 @@ type ShareListReq struct {
 
 type OneDriveUploadReq struct {
 	UploadUrl    string
-	LocalFile    string
+	Reader       io.Reader
+	Name         string
+	Size         int64
 	UploadedSize int64
 	ChunkSize    int64
+	Progress     *pan.ProgressTracker
 }
 
 type NotKnowUploadReq struct {
 	UploadUrl    string
 	Credential   string
-	LocalFile    string
+	Reader       io.Reader
+	Name         string
+	Size         int64
 	UploadedSize int64
 	ChunkSize    int64
+	Progress     *pan.ProgressTracker
 }
@@ -1,22 +1,26 @@
 package quark
 
 import (
-	"fmt"
+	"context"
 	"github.com/google/uuid"
 	"github.com/hefeiyu2025/pan-client/internal"
 	"github.com/hefeiyu2025/pan-client/pan"
 	"github.com/imroc/req/v3"
-	logger "github.com/sirupsen/logrus"
+	"io"
 	"net/http"
 	"net/url"
-	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type Quark struct {
-	sessionClient *req.Client
+	// session 带cookie的client，与配置一起整个替换
+	session       atomic.Pointer[req.Client]
+	sessionMu     sync.Mutex
 	defaultClient *req.Client
 	pan.PropertiesOperate[*QuarkProperties]
 	pan.CacheOperate
@@ -25,11 +29,11 @@ type Quark struct {
 }
 
 type QuarkProperties struct {
-	Id          string `mapstructure:"id" json:"id" yaml:"id"`
-	Pus         string `mapstructure:"pus" json:"pus" yaml:"pus"`
-	Puus        string `mapstructure:"puus" json:"puus" yaml:"puus"`
+	Id          string `mapstructure:"id" json:"id" yaml:"id" desc:"账号id，为空时自动生成"`
+	Pus         string `mapstructure:"pus" json:"pus" yaml:"pus" secret:"true" validate:"required" desc:"登录后cookie中的__pus"`
+	Puus        string `mapstructure:"puus" json:"puus" yaml:"puus" secret:"true" validate:"required" desc:"登录后cookie中的__puus"`
 	RefreshTime int64  `mapstructure:"refresh_time" json:"refresh_time" yaml:"refresh_time" default:"0"`
-	ChunkSize   int64  `mapstructure:"chunk_size" json:"chunk_size" yaml:"chunk_size" default:"314572800"` // 300M
+	ChunkSize   int64  `mapstructure:"chunk_size" json:"chunk_size" yaml:"chunk_size" default:"314572800" validate:"min=1" desc:"上传分片大小，单位字节"` // 300M
 }
 
 func (cp *QuarkProperties) OnlyImportProperties() {
@@ -48,29 +52,22 @@ func (cp *QuarkProperties) GetDriverType() pan.DriverType {
 }
 
 func (q *Quark) Init() (string, error) {
+	ctx := context.Background()
 	err := q.ReadConfig()
 	if err != nil {
 		return "", err
 	}
 	driverId := q.GetId()
-	if q.Properties.Pus == "" || q.Properties.Puus == "" {
+	q.SetInstanceId(driverId)
+	if err = q.ValidateConfig(); err != nil {
 		_ = q.WriteConfig()
-		return driverId, fmt.Errorf("please set pus and puus")
+		return driverId, err
 	}
-	q.sessionClient = req.C().
-		SetCommonHeaders(map[string]string{
-			HeaderUserAgent: DefaultUserAgent,
-			"Accept":        "application/json, text/plain, */*",
-			"Referer":       "https://pan.quark.cn",
-		}).
-		SetCommonQueryParam("pr", "ucpro").
-		SetCommonQueryParam("fr", "pc").
-		SetCommonCookies(&http.Cookie{Name: CookiePusKey, Value: q.Properties.Pus}, &http.Cookie{Name: CookiePuusKey, Value: q.Properties.Puus}).
-		SetTimeout(30 * time.Minute).SetBaseURL("https://drive.quark.cn/1/clouddrive")
+	q.setSession(q.Props())
 	q.defaultClient = req.C().SetTimeout(30 * time.Minute)
 	// 若一小时内更新过，则不重新刷session
-	if q.Properties.RefreshTime == 0 || time.Now().UnixMilli()-q.Properties.RefreshTime > 60*60*1000 {
-		_, err = q.config()
+	if refreshTime := q.Props().RefreshTime; refreshTime == 0 || time.Now().UnixMilli()-refreshTime > 60*60*1000 {
+		_, err = q.config(ctx)
 		if err != nil {
 			return driverId, err
 		} else {
@@ -83,19 +80,87 @@ func (q *Quark) Init() (string, error) {
 	return driverId, nil
 }
 
+func (q *Quark) newSessionClient(properties *QuarkProperties) *req.Client {
+	return req.C().
+		SetCommonHeaders(map[string]string{
+			HeaderUserAgent: DefaultUserAgent,
+			"Accept":        "application/json, text/plain, */*",
+			"Referer":       "https://pan.quark.cn",
+		}).
+		SetCommonQueryParam("pr", "ucpro").
+		SetCommonQueryParam("fr", "pc").
+		SetCommonCookies(&http.Cookie{Name: CookiePusKey, Value: properties.Pus}, &http.Cookie{Name: CookiePuusKey, Value: properties.Puus}).
+		SetTimeout(30 * time.Minute).SetBaseURL("https://drive.quark.cn/1/clouddrive")
+}
+
+// sessionClient 当前的session，一次操作中只取一次
+func (q *Quark) sessionClient() *req.Client {
+	return q.session.Load()
+}
+
+// setSession 按新的配置创建session后和配置一起替换，已经取到旧session的请求不受影响
+func (q *Quark) setSession(properties *QuarkProperties) {
+	client := q.newSessionClient(properties)
+	q.SetProperties(properties)
+	q.session.Store(client)
+}
+
+// updateSession 在当前配置的副本上修改后替换
+func (q *Quark) updateSession(update func(properties *QuarkProperties)) {
+	q.sessionMu.Lock()
+	defer q.sessionMu.Unlock()
+	properties := *q.Props()
+	update(&properties)
+	q.setSession(&properties)
+}
+
+// Reload 配置中的cookie变化时重建session，已经发出的请求仍使用旧的client
+func (q *Quark) Reload(ctx context.Context) error {
+	properties, changed, err := q.ReloadConfig()
+	if err != nil || !changed {
+		return err
+	}
+	q.sessionMu.Lock()
+	q.setSession(properties)
+	q.sessionMu.Unlock()
+	q.Logger().Infof("quark %s reloaded", q.GetId())
+	return nil
+}
+
 func (q *Quark) InitByCustom(id string, read pan.ConfigRW, write pan.ConfigRW) (string, error) {
 	q.Properties = &QuarkProperties{Id: id}
+	q.PropertiesOperate.AccountId = id
 	q.PropertiesOperate.Write = write
 	q.PropertiesOperate.Read = read
 	return q.Init()
 }
 
 func (q *Quark) Drop() error {
-	return pan.OnlyMsg("not support")
+	q.CancelAll()
+	if q.Discarded() {
+		// 缓存和注册属于同账号的已有实例
+		return nil
+	}
+	q.ClearCache()
+	q.Unregister(q.GetId())
+	return nil
+}
+
+func (q *Quark) Capabilities() pan.Capabilities {
+	return pan.Capabilities{
+		Drop:           true,
+		FastUpload:     true,
+		Share:          true,
+		ShareRestore:   true,
+		Copy:           true,
+		Search:         true,
+		NativeListPage: true,
+		NativeStat:     true,
+	}
 }
 
-func (q *Quark) Disk() (*pan.DiskResp, error) {
-	memberResp, err := q.member()
+func (q *Quark) Disk(ctx context.Context) (*pan.DiskResp, error) {
+	memberResp, err := q.member(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -105,13 +170,13 @@ func (q *Quark) Disk() (*pan.DiskResp, error) {
 		Used:  memberResp.Data.UseCapacity / 1024 / 1024,
 	}, nil
 }
-func (q *Quark) List(req pan.ListReq) ([]*pan.PanObj, error) {
+func (q *Quark) List(ctx context.Context, req pan.ListReq) ([]*pan.PanObj, error) {
 	queryDir := req.Dir
 	if queryDir.Path == "/" && queryDir.Name == "" {
 		queryDir.Id = "0"
 	}
 	if queryDir.Id == "" {
-		obj, err := q.GetPanObj(strings.TrimRight(queryDir.Path, "/")+"/"+queryDir.Name, true, q.List)
+		obj, err := q.GetPanObj(ctx, strings.TrimRight(queryDir.Path, "/")+"/"+queryDir.Name, true, q.List)
 		if err != nil {
 			return nil, err
 		}
@@ -122,29 +187,18 @@ func (q *Quark) List(req pan.ListReq) ([]*pan.PanObj, error) {
 		q.Del(cacheKey)
 	}
 	panObjs, exist, err := q.GetOrDefault(cacheKey, func() (interface{}, error) {
-		files, e := q.fileSort(queryDir.Id)
+		files, e := q.fileSort(ctx, queryDir.Id)
 		if e != nil {
-			logger.Error(e)
+			q.Logger().Error(e)
 			return nil, e
 		}
 		panObjs := make([]*pan.PanObj, 0)
+		path := strings.TrimRight(queryDir.Path, "/") + "/" + queryDir.Name
+		if queryDir.Id == "0" {
+			path = "/"
+		}
 		for _, item := range files {
-			fileType := "file"
-			if item.FileType == 0 {
-				fileType = "dir"
-			}
-			path := strings.TrimRight(queryDir.Path, "/") + "/" + queryDir.Name
-			if queryDir.Id == "0" {
-				path = "/"
-			}
-			panObjs = append(panObjs, &pan.PanObj{
-				Id:     item.Fid,
-				Name:   item.FileName,
-				Path:   path,
-				Size:   int64(item.Size),
-				Type:   fileType,
-				Parent: req.Dir,
-			})
+			panObjs = append(panObjs, fileToPanObj(item, path, req.Dir))
 		}
 		return panObjs, nil
 	})
@@ -159,59 +213,197 @@ func (q *Quark) List(req pan.ListReq) ([]*pan.PanObj, error) {
 	}
 	return make([]*pan.PanObj, 0), nil
 }
-func (q *Quark) ObjRename(req pan.ObjRenameReq) error {
-	if req.Obj.Id == "0" || (req.Obj.Path == "/" && req.Obj.Name == "") {
-		return pan.OnlyMsg("not support rename root path")
+func (q *Quark) ListPage(ctx context.Context, req pan.ListPageReq) (*pan.ListPageResp, error) {
+	queryDir := req.Dir
+	if queryDir.Path == "/" && queryDir.Name == "" {
+		queryDir.Id = "0"
 	}
-	object := req.Obj
-	if object.Id == "" {
-		path := strings.Trim(req.Obj.Path, "/") + "/" + req.Obj.Name
-		obj, err := q.GetPanObj(path, true, q.List)
+	if queryDir.Id == "" {
+		obj, err := q.Stat(ctx, strings.TrimRight(queryDir.Path, "/")+"/"+queryDir.Name)
 		if err != nil {
-			return err
+			return nil, err
 		}
-		object = obj
+		queryDir = obj
+	}
+	// token即页码
+	page := 1
+	if req.PageToken != "" {
+		p, e := strconv.Atoi(req.PageToken)
+		if e != nil || p < 1 {
+			return nil, pan.OnlyMsg("invalid page token " + req.PageToken)
+		}
+		page = p
+	}
+	size := req.PageSize
+	if size <= 0 {
+		size = 100
 	}
-	err := q.objectRename(object.Id, req.NewName)
+	files, total, err := q.fileSortPage(ctx, queryDir.Id, page, size)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	q.Del(cacheDirectoryPrefix + object.Parent.Id)
-	return nil
+	path := strings.TrimRight(queryDir.Path, "/") + "/" + queryDir.Name
+	if queryDir.Id == "0" {
+		path = "/"
+	}
+	resp := &pan.ListPageResp{
+		Objs: make([]*pan.PanObj, 0, len(files)),
+	}
+	for _, item := range files {
+		resp.Objs = append(resp.Objs, fileToPanObj(item, path, queryDir))
+	}
+	if page*size < total {
+		resp.NextPageToken = strconv.Itoa(page + 1)
+	}
+	return resp, nil
 }
-func (q *Quark) BatchRename(req pan.BatchRenameReq) error {
-	objs, err := q.List(pan.ListReq{
-		Reload: true,
-		Dir:    req.Path,
-	})
+
+func fileToPanObj(item File, path string, parent *pan.PanObj) *pan.PanObj {
+	fileType := "file"
+	if item.FileType == 0 {
+		fileType = "dir"
+	}
+	mimeType := item.FormatType
+	if mimeType == "" && fileType == "file" {
+		mimeType = internal.GetMimeType(item.FileName)
+	}
+	return &pan.PanObj{
+		Id:         item.Fid,
+		Name:       item.FileName,
+		Path:       path,
+		Size:       int64(item.Size),
+		Type:       fileType,
+		ModTime:    milliTime(item.LUpdatedAt, item.UpdatedAt),
+		CreateTime: milliTime(item.LCreatedAt, item.CreatedAt),
+		MimeType:   mimeType,
+		Parent:     parent,
+	}
+}
+
+func (q *Quark) Stat(ctx context.Context, path string) (*pan.PanObj, error) {
+	parentPath, name := pan.SplitPath(path)
+	if name == "" {
+		return pan.DirObj("0", "/"), nil
+	}
+	truePath := strings.TrimRight(parentPath, "/") + "/" + name
+	paths := []string{truePath}
+	if parentPath != "/" {
+		paths = append(paths, parentPath)
+	}
+	resp, err := q.filePathList(ctx, paths)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	fids := make(map[string]string)
+	for _, pathFid := range resp.Data {
+		fids["/"+strings.Trim(pathFid.FilePath, "/")] = pathFid.Fid
+	}
+	fid, ok := fids[truePath]
+	if !ok {
+		return nil, pan.NotFound(path)
+	}
+	parentFid := "0"
+	if parentPath != "/" {
+		parentFid = fids[parentPath]
+	}
+	// 夸克没有按fid获取信息的接口，在父目录中按页查找，找到即停止
+	item, err := q.fileFind(ctx, parentFid, fid)
+	if err != nil {
+		return nil, err
+	}
+	if item == nil {
+		return nil, pan.NotFound(path)
 	}
-	for _, object := range objs {
-		if object.Type == "dir" {
-			err = q.BatchRename(pan.BatchRenameReq{
-				Path: object,
-				Func: req.Func,
-			})
+	return fileToPanObj(*item, parentPath, pan.DirObj(parentFid, parentPath)), nil
+}
+
+// Search 夸克的搜索结果只有父目录id，限定了目录时按结果父目录的完整路径判断范围并补全路径，否则只有根目录下的结果有路径
+func (q *Quark) Search(ctx context.Context, req pan.SearchReq) ([]*pan.PanObj, error) {
+	if req.Keyword == "" {
+		return nil, pan.OnlyMsg("search keyword is empty")
+	}
+	root := pan.DirObj("0", "/")
+	var index *pan.AncestorIndex
+	if req.ScopePath() != "/" {
+		dir := req.Dir
+		if dir.Id == "" {
+			obj, err := q.Stat(ctx, pan.ObjPath(dir))
 			if err != nil {
-				return err
+				return nil, err
 			}
+			dir = obj
 		}
-		newName := req.Func(object)
-
-		if newName != object.Name {
-			err = q.ObjRename(pan.ObjRenameReq{
-				Obj:     object,
-				NewName: newName,
-			})
+		// 一次请求就能拿到所有上级目录，key为目录fid，值为目录名和父目录fid
+		parents := make(map[string]PathNode)
+		index = pan.NewAncestorIndex(dir, "0", func(ctx context.Context, fid string) (string, string, error) {
+			if node, ok := parents[fid]; ok {
+				return node.FileName, node.Fid, nil
+			}
+			fullPath, err := q.dirFullPath(ctx, fid)
 			if err != nil {
-				return err
+				return "", "", err
+			}
+			parentFid := "0"
+			for _, node := range fullPath {
+				parents[node.Fid] = PathNode{Fid: parentFid, FileName: node.FileName}
+				parentFid = node.Fid
 			}
+			node := parents[fid]
+			return node.FileName, node.Fid, nil
+		})
+	}
+	files, err := q.fileSearch(ctx, req.Keyword)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*pan.PanObj, 0)
+	for _, item := range files {
+		var obj *pan.PanObj
+		if index != nil {
+			parent, e := index.Dir(ctx, item.PdirFid)
+			if e != nil {
+				return nil, e
+			}
+			if parent == nil {
+				continue
+			}
+			obj = fileToPanObj(item, pan.ObjPath(parent), parent)
+		} else if item.PdirFid == "0" {
+			obj = fileToPanObj(item, "/", root)
+		} else {
+			obj = fileToPanObj(item, "", &pan.PanObj{Id: item.PdirFid, Type: "dir"})
 		}
+		if req.Match(obj) {
+			result = append(result, obj)
+		}
+	}
+	return result, nil
+}
+
+func (q *Quark) ObjRename(ctx context.Context, req pan.ObjRenameReq) error {
+	if req.Obj.Id == "0" || (req.Obj.Path == "/" && req.Obj.Name == "") {
+		return pan.OnlyMsg("not support rename root path")
 	}
+	object := req.Obj
+	if object.Id == "" {
+		path := strings.Trim(req.Obj.Path, "/") + "/" + req.Obj.Name
+		obj, err := q.GetPanObj(ctx, path, true, q.List)
+		if err != nil {
+			return err
+		}
+		object = obj
+	}
+	err := q.objectRename(ctx, object.Id, req.NewName)
+	if err != nil {
+		return err
+	}
+	q.Del(cacheDirectoryPrefix + object.Parent.Id)
 	return nil
 }
-func (q *Quark) Mkdir(req pan.MkdirReq) (*pan.PanObj, error) {
+func (q *Quark) BatchRename(ctx context.Context, req pan.BatchRenameReq) error {
+	return q.BaseBatchRename(ctx, req, q.List, q.ObjRename)
+}
+func (q *Quark) Mkdir(ctx context.Context, req pan.MkdirReq) (*pan.PanObj, error) {
 	if req.NewPath == "" {
 		// 不处理，直接返回
 		return &pan.PanObj{
@@ -229,7 +421,7 @@ func (q *Quark) Mkdir(req pan.MkdirReq) (*pan.PanObj, error) {
 	if req.Parent != nil && (req.Parent.Id == "0" || req.Parent.Path == "/") {
 		targetPath = req.Parent.Path + "/" + strings.Trim(req.NewPath, "/")
 	}
-	obj, err := q.GetPanObj(targetPath, false, q.List)
+	obj, err := q.GetPanObj(ctx, targetPath, false, q.List)
 	if err != nil {
 		return nil, err
 	}
@@ -248,24 +440,24 @@ func (q *Quark) Mkdir(req pan.MkdirReq) (*pan.PanObj, error) {
 		split := strings.Split(rel, "/")
 		targetDirId := obj.Id
 		for _, s := range split {
-			resp, err := q.createDirectory(s, targetDirId)
+			resp, err := q.createDirectory(ctx, s, targetDirId)
 			if err != nil {
 				return nil, pan.OnlyError(err)
 			}
 			targetDirId = resp.Data.Fid
 		}
 		q.Del(cacheDirectoryPrefix + obj.Id)
-		return q.Mkdir(req)
+		return q.Mkdir(ctx, req)
 	}
 }
-func (q *Quark) Move(req pan.MovieReq) error {
+func (q *Quark) Move(ctx context.Context, req pan.MovieReq) error {
 	targetObj := req.TargetObj
 	if targetObj.Type == "file" {
 		return pan.OnlyMsg("target is a file")
 	}
 	// 重新直接创建目标目录
 	if targetObj.Id == "" {
-		create, err := q.Mkdir(pan.MkdirReq{
+		create, err := q.Mkdir(ctx, pan.MkdirReq{
 			NewPath: strings.Trim(targetObj.Path, "/") + "/" + targetObj.Name,
 		})
 		if err != nil {
@@ -273,34 +465,45 @@ func (q *Quark) Move(req pan.MovieReq) error {
 		}
 		targetObj = create
 	}
-	reloadDirId := make(map[string]any)
-	objIds := make([]string, 0)
-	for _, item := range req.Items {
-		if item.Id != "0" && item.Id != "" {
-			objIds = append(objIds, item.Id)
-			if item.Type == "dir" {
-				reloadDirId[item.Id] = true
-			}
-		} else if item.Path != "" && item.Path != "/" {
-			obj, err := q.GetPanObj(item.Path, true, q.List)
-			if err == nil {
-				objIds = append(objIds, obj.Id)
-				if obj.Type == "dir" {
-					reloadDirId[obj.Id] = true
-				}
-			}
+	objIds, dirIds, err := q.ResolveItems(ctx, req.Items, q.List)
+	if err != nil {
+		return err
+	}
+	if e := q.objectMove(ctx, objIds, targetObj.Id); e != nil {
+		return pan.OnlyError(e)
+	}
+	for _, id := range dirIds {
+		q.Del(cacheDirectoryPrefix + id)
+	}
+	return nil
+}
+func (q *Quark) Copy(ctx context.Context, req pan.CopyReq) error {
+	targetObj := req.TargetObj
+	if targetObj.Type == "file" {
+		return pan.OnlyMsg("target is a file")
+	}
+	// 重新直接创建目标目录
+	if targetObj.Id == "" {
+		create, err := q.Mkdir(ctx, pan.MkdirReq{
+			NewPath: strings.Trim(targetObj.Path, "/") + "/" + targetObj.Name,
+		})
+		if err != nil {
+			return err
 		}
+		targetObj = create
 	}
-	err := q.objectMove(objIds, targetObj.Id)
+	objIds, _, err := q.ResolveItems(ctx, req.Items, q.List)
 	if err != nil {
-		return pan.OnlyError(err)
+		return err
 	}
-	for key := range reloadDirId {
-		q.Del(cacheDirectoryPrefix + key)
+	if e := q.objectCopy(ctx, objIds, targetObj.Id); e != nil {
+		return pan.OnlyError(e)
 	}
+	// 源对象不变，只有目标目录的列表需要刷新
+	q.Del(cacheDirectoryPrefix + targetObj.Id)
 	return nil
 }
-func (q *Quark) Delete(req pan.DeleteReq) error {
+func (q *Quark) Delete(ctx context.Context, req pan.DeleteReq) error {
 	if len(req.Items) == 0 {
 		return nil
 	}
@@ -317,7 +520,7 @@ func (q *Quark) Delete(req pan.DeleteReq) error {
 				}
 			}
 		} else if item.Path != "" && item.Path != "/" {
-			obj, err := q.GetPanObj(item.Path, true, q.List)
+			obj, err := q.GetPanObj(ctx, item.Path, true, q.List)
 			if err == nil {
 				objIds = append(objIds, obj.Id)
 				if obj.Type == "dir" {
@@ -329,7 +532,7 @@ func (q *Quark) Delete(req pan.DeleteReq) error {
 		}
 	}
 	if len(objIds) > 0 {
-		err := q.objectDelete(objIds)
+		err := q.objectDelete(ctx, objIds)
 		if err != nil {
 			return err
 		}
@@ -341,19 +544,25 @@ func (q *Quark) Delete(req pan.DeleteReq) error {
 	return nil
 }
 
-func (q *Quark) UploadPath(req pan.UploadPathReq) error {
-	return q.BaseUploadPath(req, q.UploadFile)
+func (q *Quark) UploadPath(ctx context.Context, req pan.UploadPathReq) error {
+	return q.BaseUploadPath(ctx, req, q.UploadFile)
+}
+
+func (q *Quark) UploadFile(ctx context.Context, req pan.UploadFileReq) error {
+	return q.BaseUploadFile(ctx, req, q.UploadStream)
+}
+
+func (q *Quark) UploadStream(ctx context.Context, req pan.UploadStreamReq) error {
+	return q.BaseUploadStream(ctx, req, q.uploadStream)
 }
 
-func (q *Quark) UploadFile(req pan.UploadFileReq) error {
+func (q *Quark) uploadStream(ctx context.Context, req pan.UploadStreamReq) error {
+	ctx, cancel := q.BindContext(ctx)
+	defer cancel()
 	if req.Resumable {
-		logger.Warn("quark is not support resumeable")
+		q.Logger().Warn("quark is not support resumeable")
 	}
-	stat, err := os.Stat(req.LocalFile)
-	if err != nil {
-		return err
-	}
-	remoteName := stat.Name()
+	remoteName := req.Name
 	remotePath := strings.TrimRight(req.RemotePath, "/")
 	if req.RemotePathTransfer != nil {
 		remotePath = req.RemotePathTransfer(remotePath)
@@ -362,33 +571,33 @@ func (q *Quark) UploadFile(req pan.UploadFileReq) error {
 		remoteName = req.RemoteNameTransfer(remoteName)
 	}
 	remoteAllPath := remotePath + "/" + remoteName
-	_, err = q.GetPanObj(remoteAllPath, true, q.List)
+	_, err := q.GetPanObj(ctx, remoteAllPath, true, q.List)
 	// 没有报错证明文件已经存在
 	if err == nil {
-		return pan.CodeMsg(CodeObjectExist, remoteAllPath+" is exist")
+		return codeMsg(CodeObjectExist, remoteAllPath+" is exist")
 	}
-	dir, err := q.Mkdir(pan.MkdirReq{
+	dir, err := q.Mkdir(ctx, pan.MkdirReq{
 		NewPath: remotePath,
 	})
 	if err != nil {
 		return pan.MsgError(remotePath+" create error", err)
 	}
 
-	md5Str, err := internal.GetFileMd5(req.LocalFile)
-	if err != nil {
-		return err
-	}
-	sha1Str, err := internal.GetFileSha1(req.LocalFile)
-	if err != nil {
-		return err
-	}
+	// 秒传需要先得到md5和sha1
+	cleanup, e := q.PrepareStreamHash(ctx, &req, pan.HashMd5, pan.HashSha1)
+	defer cleanup()
+	if e != nil {
+		return e
+	}
+	md5Str := req.Hashes[pan.HashMd5]
+	sha1Str := req.Hashes[pan.HashSha1]
 
-	mimeType := internal.GetMimeType(req.LocalFile)
+	mimeType := internal.GetMimeType(remoteName)
 
-	pre, err := q.FileUploadPre(FileUpPreReq{
+	pre, err := q.FileUploadPre(ctx, FileUpPreReq{
 		ParentId: dir.Id,
 		FileName: remoteName,
-		FileSize: stat.Size(),
+		FileSize: req.Size,
 		MimeType: mimeType,
 	})
 	if err != nil {
@@ -396,7 +605,7 @@ func (q *Quark) UploadFile(req pan.UploadFileReq) error {
 	}
 
 	// hash
-	finish, err := q.FileUploadHash(FileUpHashReq{
+	finish, err := q.FileUploadHash(ctx, FileUpHashReq{
 		Md5:    md5Str,
 		Sha1:   sha1Str,
 		TaskId: pre.Data.TaskId,
@@ -405,39 +614,30 @@ func (q *Quark) UploadFile(req pan.UploadFileReq) error {
 		return err
 	}
 	if finish.Data.Finish {
-		logger.Infof("upload fast success %s", req.LocalFile)
-		// 上传成功则移除文件了
-		if req.SuccessDel {
-			err = os.Remove(req.LocalFile)
-			if err != nil {
-				logger.Errorf("delete fail %s,%v", req.LocalFile, err)
-			} else {
-				logger.Infof("delete success %s", req.LocalFile)
-			}
-		}
+		q.Logger().Infof("upload fast success %s", remoteAllPath)
 		return nil
 	}
 
 	if req.OnlyFast {
-		logger.Infof("upload fast error %s", req.LocalFile)
-		return pan.OnlyMsg("only support fast error:" + req.LocalFile)
+		q.Logger().Infof("upload fast error %s", remoteAllPath)
+		return pan.OnlyMsg("only support fast error:" + remoteAllPath)
 	}
 
 	// part up
-	partSize := min(int64(pre.Metadata.PartSize), q.Properties.ChunkSize)
-	total := stat.Size()
-	left := total
+	partSize := min(int64(pre.Metadata.PartSize), q.Props().ChunkSize)
+	left := req.Size
 	partNumber := 1
-	pr, err := pan.NewProcessReader(req.LocalFile, partSize, 0)
+	pr, err := q.NewStreamProgressReader(q.UploadReader(ctx, req), remoteName, req.Size, partSize, 0)
 	if err != nil {
 		return err
 	}
+	pr.SetProgress(req.ProgressTracker())
 	md5s := make([]string, 0)
 	for left > 0 {
 		start, end := pr.NextChunk()
 		chunkUploadSize := end - start
 		left -= chunkUploadSize
-		m, e := q.FileUpPart(FileUpPartReq{
+		m, e := q.FileUpPart(ctx, FileUpPartReq{
 			ObjKey:     pre.Data.ObjKey,
 			Bucket:     pre.Data.Bucket,
 			UploadId:   pre.Data.UploadId,
@@ -452,22 +652,13 @@ func (q *Quark) UploadFile(req pan.UploadFileReq) error {
 			return e
 		}
 		if m == "finish" {
-			logger.Infof("upload success:%s", req.LocalFile)
-			// 上传成功则移除文件了
-			if req.SuccessDel {
-				err = os.Remove(req.LocalFile)
-				if err != nil {
-					logger.Errorf("delete fail %s,%v", req.LocalFile, err)
-				} else {
-					logger.Infof("delete success %s", req.LocalFile)
-				}
-			}
+			q.Logger().Infof("upload success:%s", remoteAllPath)
 			return nil
 		}
 		md5s = append(md5s, m)
 		partNumber++
 	}
-	err = q.FileUpCommit(FileUpCommitReq{
+	err = q.FileUpCommit(ctx, FileUpCommitReq{
 		ObjKey:    pre.Data.ObjKey,
 		Bucket:    pre.Data.Bucket,
 		UploadId:  pre.Data.UploadId,
@@ -480,50 +671,57 @@ func (q *Quark) UploadFile(req pan.UploadFileReq) error {
 	if err != nil {
 		return err
 	}
-	_, err = q.FileUpFinish(FileUpFinishReq{
+	_, err = q.FileUpFinish(ctx, FileUpFinishReq{
 		ObjKey: pre.Data.ObjKey,
 		TaskId: pre.Data.TaskId,
 	})
 	if err != nil {
 		return err
 	}
-	logger.Infof("upload success %s", req.LocalFile)
-	// 上传成功则移除文件了
-	if req.SuccessDel {
-		err = os.Remove(req.LocalFile)
-		if err != nil {
-			logger.Errorf("delete fail %s,%v", req.LocalFile, err)
-		} else {
-			logger.Infof("delete success %s", req.LocalFile)
-		}
-	}
+	q.Logger().Infof("upload success %s", remoteAllPath)
 	return nil
 }
 
-func (q *Quark) DownloadPath(req pan.DownloadPathReq) error {
-	return q.BaseDownloadPath(req, q.List, q.DownloadFile)
+func (q *Quark) DownloadPath(ctx context.Context, req pan.DownloadPathReq) error {
+	return q.BaseDownloadPath(ctx, req, q.List, q.DownloadFile)
 }
-func (q *Quark) DownloadFile(req pan.DownloadFileReq) error {
-	return q.BaseDownloadFile(req, q.sessionClient, func(req pan.DownloadFileReq) (string, error) {
-		resp, err := q.fileDownload(req.RemoteFile.Id)
-		if err != nil {
-			return "", err
-		}
-		return resp.Data[0].DownloadUrl, nil
-	})
+func (q *Quark) downloadUrl(ctx context.Context, req pan.DownloadFileReq) (string, error) {
+	resp, err := q.fileDownload(ctx, req.RemoteFile.Id)
+	if err != nil {
+		return "", err
+	}
+	// 列表中没有md5，只有下载接口返回，用于下载后校验
+	req.RemoteFile.SetHash(pan.HashMd5, resp.Data[0].Md5)
+	return resp.Data[0].DownloadUrl, nil
+}
+
+func (q *Quark) DownloadFile(ctx context.Context, req pan.DownloadFileReq) error {
+	ctx, cancel := q.BindContext(ctx)
+	defer cancel()
+	return q.BaseDownloadFile(ctx, req, q.sessionClient(), q.downloadUrl)
+}
+
+func (q *Quark) Open(ctx context.Context, req pan.OpenReq) (io.ReadCloser, error) {
+	ctx, cancel := q.BindContext(ctx)
+	rc, err := q.BaseOpen(ctx, req, q.sessionClient(), q.downloadUrl)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	return pan.BindReadCloser(rc, cancel), nil
 }
 
-func (q *Quark) OfflineDownload(req pan.OfflineDownloadReq) (*pan.Task, error) {
-	return nil, pan.OnlyMsg("offline download not support")
+func (q *Quark) OfflineDownload(ctx context.Context, req pan.OfflineDownloadReq) (*pan.Task, error) {
+	return nil, pan.NotSupported("offline download")
 }
 
-func (q *Quark) TaskList(req pan.TaskListReq) ([]*pan.Task, error) {
-	return nil, pan.OnlyMsg("task list not support")
+func (q *Quark) TaskList(ctx context.Context, req pan.TaskListReq) ([]*pan.Task, error) {
+	return nil, pan.NotSupported("task list")
 }
 
-func (q *Quark) ShareList(req pan.ShareListReq) ([]*pan.ShareData, error) {
+func (q *Quark) ShareList(ctx context.Context, req pan.ShareListReq) ([]*pan.ShareData, error) {
 	needFilter := len(req.ShareIds) > 0
-	details, err := q.shareList()
+	details, err := q.shareList(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -550,12 +748,12 @@ func (q *Quark) ShareList(req pan.ShareListReq) ([]*pan.ShareData, error) {
 	}
 	return result, nil
 }
-func (q *Quark) NewShare(req pan.NewShareReq) (*pan.ShareData, error) {
+func (q *Quark) NewShare(ctx context.Context, req pan.NewShareReq) (*pan.ShareData, error) {
 	urlType := 1
 	if req.NeedPassCode {
 		urlType = 2
 	}
-	shareId, err := q.share(ShareReq{
+	shareId, err := q.share(ctx, ShareReq{
 		FidList:     req.Fids,
 		Title:       req.Title,
 		UrlType:     urlType,
@@ -564,7 +762,7 @@ func (q *Quark) NewShare(req pan.NewShareReq) (*pan.ShareData, error) {
 	if err != nil {
 		return nil, err
 	}
-	resp, err := q.sharePassword(shareId)
+	resp, err := q.sharePassword(ctx, shareId)
 	if err != nil {
 		return nil, err
 	}
@@ -575,12 +773,12 @@ func (q *Quark) NewShare(req pan.NewShareReq) (*pan.ShareData, error) {
 		Title:    resp.Data.Title,
 	}, nil
 }
-func (q *Quark) DeleteShare(req pan.DelShareReq) error {
-	_, err := q.shareDelete(req.ShareIds)
+func (q *Quark) DeleteShare(ctx context.Context, req pan.DelShareReq) error {
+	_, err := q.shareDelete(ctx, req.ShareIds)
 	return err
 }
 
-func (q *Quark) ShareRestore(req pan.ShareRestoreReq) error {
+func (q *Quark) ShareRestore(ctx context.Context, req pan.ShareRestoreReq) error {
 	if req.ShareUrl == "" {
 		return pan.OnlyMsg("share url must not null")
 	}
@@ -590,13 +788,13 @@ func (q *Quark) ShareRestore(req pan.ShareRestoreReq) error {
 		return err
 	}
 	pwdId := strings.TrimLeft(parsedURL.Path, "/s/")
-	targetDir, err := q.Mkdir(pan.MkdirReq{
+	targetDir, err := q.Mkdir(ctx, pan.MkdirReq{
 		NewPath: req.TargetDir,
 	})
 	if err != nil {
 		return err
 	}
-	token, err := q.shareToken(ShareTokenReq{
+	token, err := q.shareToken(ctx, ShareTokenReq{
 		PwdId:    pwdId,
 		Passcode: req.PassCode,
 	})
@@ -604,7 +802,7 @@ func (q *Quark) ShareRestore(req pan.ShareRestoreReq) error {
 		return err
 	}
 	stoken := token.Data.Stoken
-	detail, err := q.shareDetail(ShareDetailReq{
+	detail, err := q.shareDetail(ctx, ShareDetailReq{
 		PwdId:  pwdId,
 		Stoken: stoken,
 	})
@@ -617,7 +815,7 @@ func (q *Quark) ShareRestore(req pan.ShareRestoreReq) error {
 		fidList = append(fidList, file.Fid)
 		fidTokenList = append(fidTokenList, file.ShareFidToken)
 	}
-	err = q.shareRestore(RestoreReq{
+	err = q.shareRestore(ctx, RestoreReq{
 		FidList:      fidList,
 		FidTokenList: fidTokenList,
 		ToPdirFid:    targetDir.Id,
@@ -629,18 +827,20 @@ func (q *Quark) ShareRestore(req pan.ShareRestoreReq) error {
 	return err
 }
 
-func (q *Quark) DirectLink(req pan.DirectLinkReq) ([]*pan.DirectLink, error) {
-	return nil, pan.OnlyMsg("direct link not support")
+func (q *Quark) DirectLink(ctx context.Context, req pan.DirectLinkReq) ([]*pan.DirectLink, error) {
+	return nil, pan.NotSupported("direct link")
 }
 
 func init() {
-	pan.RegisterDriver(pan.Quark, func() pan.Driver {
+	pan.RegisterDriver(pan.Quark, func(env *pan.Env) pan.Driver {
 		return &Quark{
 			PropertiesOperate: pan.PropertiesOperate[*QuarkProperties]{
 				DriverType: pan.Quark,
+				Env:        env,
 			},
-			CacheOperate:  pan.CacheOperate{DriverType: pan.Quark},
-			CommonOperate: pan.CommonOperate{},
+			CacheOperate:  pan.CacheOperate{DriverType: pan.Quark, Env: env},
+			CommonOperate: pan.CommonOperate{Env: env},
+			BaseOperate:   pan.BaseOperate{Env: env},
 		}
 	})
 }
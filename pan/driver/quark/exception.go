@@ -1,7 +1,36 @@
 package quark
 
+import "github.com/hefeiyu2025/pan-client/pan"
+
 // 定义异常编码和异常信息
 const (
 	// CodeObjectExist 对象已存在
 	CodeObjectExist = 40004
+	// CodeNameConflict 同名冲突
+	CodeNameConflict = 23008
+	// CodeRequireLogin 未登录或登录失效
+	CodeRequireLogin = 31001
+	// CodeCapacityLimit 容量不足
+	CodeCapacityLimit = 32003
 )
+
+var codeKinds = map[int]error{
+	CodeObjectExist:   pan.ErrAlreadyExists,
+	CodeNameConflict:  pan.ErrAlreadyExists,
+	CodeRequireLogin:  pan.ErrAuthExpired,
+	CodeCapacityLimit: pan.ErrQuotaExceeded,
+}
+
+// codeMsg 按夸克的错误码归类异常
+func codeMsg(code int, msg string) pan.DriverErrorInterface {
+	return pan.KindCodeMsg(codeKinds[code], code, msg)
+}
+
+// statusCodeMsg 错误码未能归类时再按返回的status归类
+func statusCodeMsg(status, code int, msg string) pan.DriverErrorInterface {
+	kind, ok := codeKinds[code]
+	if !ok {
+		kind = pan.StatusKind(status)
+	}
+	return pan.KindCodeMsg(kind, code, msg)
+}
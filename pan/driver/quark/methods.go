@@ -1,6 +1,7 @@
 package quark
 
 import (
+	"context"
 	"crypto/md5"
 	"encoding/base64"
 	"encoding/json"
@@ -19,7 +20,7 @@ func funReturn(err error, response *req.Response, result Resp) (*Resp, pan.Drive
 		return nil, pan.OnlyError(err)
 	}
 	if response.IsErrorState() && result.Code != 0 {
-		return nil, pan.CodeMsg(result.Code, result.Msg)
+		return nil, statusCodeMsg(result.Status, result.Code, result.Msg)
 	}
 	return &result, pan.NoError()
 }
@@ -28,10 +29,10 @@ func funReturnBySuccess[T any](err error, response *req.Response, errorResult Re
 		return nil, pan.OnlyError(err)
 	}
 	if response.IsErrorState() {
-		return nil, pan.CodeMsg(errorResult.Code, errorResult.Msg)
+		return nil, statusCodeMsg(errorResult.Status, errorResult.Code, errorResult.Msg)
 	}
 	if successResult.Code != 0 {
-		return nil, pan.CodeMsg(successResult.Code, successResult.Msg)
+		return nil, statusCodeMsg(successResult.Status, successResult.Code, successResult.Msg)
 	}
 	return &successResult, pan.NoError()
 }
@@ -41,23 +42,25 @@ func funReturnBySuccessMeta[T any, R any](err error, response *req.Response, err
 		return nil, pan.OnlyError(err)
 	}
 	if response.IsErrorState() {
-		return nil, pan.CodeMsg(errorResult.Code, errorResult.Msg)
+		return nil, statusCodeMsg(errorResult.Status, errorResult.Code, errorResult.Msg)
 	}
 	if successResult.Code != 0 {
-		return nil, pan.CodeMsg(successResult.Code, successResult.Msg)
+		return nil, statusCodeMsg(successResult.Status, successResult.Code, successResult.Msg)
 	}
 	return &successResult, pan.NoError()
 }
 
-func checkTaskSuccess(finish bool, successResult RespDataWithMeta[TaskDoing, TaskMeta], c *Quark) pan.DriverErrorInterface {
+func checkTaskSuccess(ctx context.Context, finish bool, successResult RespDataWithMeta[TaskDoing, TaskMeta], c *Quark) pan.DriverErrorInterface {
 	isFinish := finish
 	taskId := successResult.Data.TaskId
 	for {
 		if isFinish || taskId == "" {
 			break
 		}
-		time.Sleep(time.Duration(successResult.Metadata.TqGap) * time.Millisecond)
-		query, err := c.taskQuery(taskId)
+		if err := internal.SleepContext(ctx, time.Duration(successResult.Metadata.TqGap)*time.Millisecond); err != nil {
+			return pan.OnlyError(err)
+		}
+		query, err := c.taskQuery(ctx, taskId)
 		if err != nil {
 			return err
 		}
@@ -67,8 +70,8 @@ func checkTaskSuccess(finish bool, successResult RespDataWithMeta[TaskDoing, Tas
 	return nil
 }
 
-func (q *Quark) taskQuery(taskId string) (*RespDataWithMeta[Task, TaskMeta], pan.DriverErrorInterface) {
-	r := q.sessionClient.R()
+func (q *Quark) taskQuery(ctx context.Context, taskId string) (*RespDataWithMeta[Task, TaskMeta], pan.DriverErrorInterface) {
+	r := q.sessionClient().R().SetContext(ctx)
 	var successResult RespDataWithMeta[Task, TaskMeta]
 	var errorResult Resp
 	r.SetSuccessResult(&successResult)
@@ -81,8 +84,8 @@ func (q *Quark) taskQuery(taskId string) (*RespDataWithMeta[Task, TaskMeta], pan
 	return funReturnBySuccessMeta(err, response, errorResult, successResult)
 }
 
-func (q *Quark) member() (*RespDataWithMeta[MemberData, MemberMeta], pan.DriverErrorInterface) {
-	r := q.sessionClient.R()
+func (q *Quark) member(ctx context.Context) (*RespDataWithMeta[MemberData, MemberMeta], pan.DriverErrorInterface) {
+	r := q.sessionClient().R().SetContext(ctx)
 	var successResult RespDataWithMeta[MemberData, MemberMeta]
 	var errorResult Resp
 	r.SetSuccessResult(&successResult)
@@ -91,8 +94,8 @@ func (q *Quark) member() (*RespDataWithMeta[MemberData, MemberMeta], pan.DriverE
 	return funReturnBySuccessMeta(err, response, errorResult, successResult)
 }
 
-func (q *Quark) config() (*RespData[Config], pan.DriverErrorInterface) {
-	r := q.sessionClient.R()
+func (q *Quark) config(ctx context.Context) (*RespData[Config], pan.DriverErrorInterface) {
+	r := q.sessionClient().R().SetContext(ctx)
 	var successResult RespData[Config]
 	var errorResult Resp
 	r.SetSuccessResult(&successResult)
@@ -102,28 +105,30 @@ func (q *Quark) config() (*RespData[Config], pan.DriverErrorInterface) {
 		return nil, pan.OnlyError(err)
 	}
 	if response.IsErrorState() {
-		return nil, pan.CodeMsg(errorResult.Code, errorResult.Msg)
+		return nil, statusCodeMsg(errorResult.Status, errorResult.Code, errorResult.Msg)
 	}
 	if successResult.Code != 0 {
-		return nil, pan.CodeMsg(successResult.Code, successResult.Msg)
+		return nil, statusCodeMsg(successResult.Status, successResult.Code, successResult.Msg)
 	}
 	for _, cookie := range response.Cookies() {
 		if cookie.Name == CookiePuusKey {
-			q.Properties.Puus = cookie.Value
-			q.Properties.RefreshTime = time.Now().UnixMilli()
-			q.sessionClient.SetCommonCookies(&http.Cookie{Name: CookiePuusKey, Value: q.Properties.Puus})
+			q.updateSession(func(properties *QuarkProperties) {
+				properties.Puus = cookie.Value
+				properties.RefreshTime = time.Now().UnixMilli()
+			})
 		}
 		if cookie.Name == CookiePusKey {
-			q.Properties.Pus = cookie.Value
-			q.Properties.RefreshTime = time.Now().UnixMilli()
-			q.sessionClient.SetCommonCookies(&http.Cookie{Name: CookiePusKey, Value: q.Properties.Pus})
+			q.updateSession(func(properties *QuarkProperties) {
+				properties.Pus = cookie.Value
+				properties.RefreshTime = time.Now().UnixMilli()
+			})
 		}
 	}
 	return &successResult, pan.NoError()
 }
 
-func (q *Quark) createDirectory(dirName, dstId string) (*RespData[Dir], pan.DriverErrorInterface) {
-	r := q.sessionClient.R()
+func (q *Quark) createDirectory(ctx context.Context, dirName, dstId string) (*RespData[Dir], pan.DriverErrorInterface) {
+	r := q.sessionClient().R().SetContext(ctx)
 	var successResult RespData[Dir]
 	var errorResult Resp
 	r.SetSuccessResult(&successResult)
@@ -139,46 +144,164 @@ func (q *Quark) createDirectory(dirName, dstId string) (*RespData[Dir], pan.Driv
 	return funReturnBySuccess(err, response, errorResult, successResult)
 }
 
-func (q *Quark) fileSort(parent string) ([]File, pan.DriverErrorInterface) {
+func (q *Quark) fileSort(ctx context.Context, parent string) ([]File, pan.DriverErrorInterface) {
 	files := make([]File, 0)
-	r := q.sessionClient.R()
 	page := 1
 	size := 100
-	query := map[string]string{
+	for {
+		list, total, err := q.fileSortPage(ctx, parent, page, size)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, list...)
+		if page*size >= total {
+			break
+		}
+		page++
+	}
+
+	return files, nil
+}
+
+// fileFind 按页查找目录中fid对应的文件，找到后不再请求后面的页
+func (q *Quark) fileFind(ctx context.Context, parent, fid string) (*File, pan.DriverErrorInterface) {
+	size := 100
+	for page := 1; ; page++ {
+		list, total, err := q.fileSortPage(ctx, parent, page, size)
+		if err != nil {
+			return nil, err
+		}
+		for i := range list {
+			if list[i].Fid == fid {
+				return &list[i], nil
+			}
+		}
+		if page*size >= total || len(list) == 0 {
+			return nil, nil
+		}
+	}
+}
+
+// dirFullPath 获取目录从根目录下一级到自身的路径，只请求一条数据
+func (q *Quark) dirFullPath(ctx context.Context, fid string) ([]PathNode, pan.DriverErrorInterface) {
+	r := q.sessionClient().R().SetContext(ctx)
+	var successResult RespDataWithMeta[FileList, SortMeta]
+	var errorResult Resp
+	r.SetSuccessResult(&successResult)
+	r.SetErrorResult(&errorResult)
+	r.SetQueryParams(map[string]string{
+		"pdir_fid":         fid,
+		"_page":            "1",
+		"_size":            "1",
+		"_fetch_full_path": "1",
+	})
+	response, err := r.Get("/file/sort")
+	if err != nil {
+		return nil, pan.OnlyError(err)
+	}
+	if response.IsErrorState() {
+		return nil, statusCodeMsg(errorResult.Status, errorResult.Code, errorResult.Msg)
+	}
+	if successResult.Status >= 400 || successResult.Code != 0 {
+		return nil, statusCodeMsg(successResult.Status, successResult.Code, successResult.Msg)
+	}
+	fullPath := successResult.Data.FullPath
+	if len(fullPath) == 0 || fullPath[len(fullPath)-1].Fid != fid {
+		return nil, pan.NotFound("dir " + fid)
+	}
+	return fullPath, nil
+}
+
+// fileSortPage 获取目录的某一页，返回该页数据和总数
+func (q *Quark) fileSortPage(ctx context.Context, parent string, page, size int) ([]File, int, pan.DriverErrorInterface) {
+	r := q.sessionClient().R().SetContext(ctx)
+	var successResult RespDataWithMeta[FileList, SortMeta]
+	var errorResult Resp
+	r.SetSuccessResult(&successResult)
+	r.SetErrorResult(&errorResult)
+	r.SetQueryParams(map[string]string{
 		"pdir_fid":     parent,
+		"_page":        strconv.Itoa(page),
 		"_size":        strconv.Itoa(size),
 		"_fetch_total": "1",
+	})
+	response, err := r.Get("/file/sort")
+	if err != nil {
+		return nil, 0, pan.OnlyError(err)
+	}
+	if response.IsErrorState() {
+		return nil, 0, statusCodeMsg(errorResult.Status, errorResult.Code, errorResult.Msg)
+	}
+	if successResult.Status >= 400 || successResult.Code != 0 {
+		return nil, 0, statusCodeMsg(successResult.Status, successResult.Code, successResult.Msg)
 	}
+	return successResult.Data.List, successResult.Metadata.Total, nil
+}
+
+// fileSearchPage 按文件名搜索的某一页，返回该页数据和总数
+func (q *Quark) fileSearchPage(ctx context.Context, keyword string, page, size int) ([]File, int, pan.DriverErrorInterface) {
+	r := q.sessionClient().R().SetContext(ctx)
 	var successResult RespDataWithMeta[FileList, SortMeta]
 	var errorResult Resp
 	r.SetSuccessResult(&successResult)
 	r.SetErrorResult(&errorResult)
+	r.SetQueryParams(map[string]string{
+		"q":            keyword,
+		"_page":        strconv.Itoa(page),
+		"_size":        strconv.Itoa(size),
+		"_fetch_total": "1",
+		"_sort":        "file_type:desc,updated_at:desc",
+		"_is_hl":       "1",
+	})
+	response, err := r.Get("/file/search")
+	if err != nil {
+		return nil, 0, pan.OnlyError(err)
+	}
+	if response.IsErrorState() {
+		return nil, 0, statusCodeMsg(errorResult.Status, errorResult.Code, errorResult.Msg)
+	}
+	if successResult.Status >= 400 || successResult.Code != 0 {
+		return nil, 0, statusCodeMsg(successResult.Status, successResult.Code, successResult.Msg)
+	}
+	return successResult.Data.List, successResult.Metadata.Total, nil
+}
+
+func (q *Quark) fileSearch(ctx context.Context, keyword string) ([]File, pan.DriverErrorInterface) {
+	files := make([]File, 0)
+	page := 1
+	size := 100
 	for {
-		query["_page"] = strconv.Itoa(page)
-		r.SetQueryParams(query)
-		response, err := r.Get("/file/sort")
+		list, total, err := q.fileSearchPage(ctx, keyword, page, size)
 		if err != nil {
-			return nil, pan.OnlyError(err)
+			return nil, err
 		}
-		if response.IsErrorState() {
-			return nil, pan.CodeMsg(errorResult.Code, errorResult.Msg)
-		}
-		if successResult.Status >= 400 || successResult.Code != 0 {
-			return nil, pan.CodeMsg(successResult.Code, successResult.Msg)
-		}
-		files = append(files, successResult.Data.List...)
-		if page*size >= successResult.Metadata.Total {
+		files = append(files, list...)
+		if page*size >= total || len(list) == 0 {
 			break
 		}
 		page++
 	}
-
 	return files, nil
 }
 
-func (q *Quark) objectDelete(objIds []string) pan.DriverErrorInterface {
+// filePathList 根据路径批量获取fid，不存在的路径不会返回
+func (q *Quark) filePathList(ctx context.Context, paths []string) (*RespData[[]PathFid], pan.DriverErrorInterface) {
+	r := q.sessionClient().R().SetContext(ctx)
+	data := map[string]any{
+		"file_path": paths,
+		"namespace": "0",
+	}
+	var successResult RespData[[]PathFid]
+	var errorResult Resp
+	r.SetSuccessResult(&successResult)
+	r.SetErrorResult(&errorResult)
+	response, err := r.SetBody(data).Post("/file/info/path_list")
+	return funReturnBySuccess(err, response, errorResult, successResult)
+}
+
+func (q *Quark) objectDelete(ctx context.Context, objIds []string) pan.DriverErrorInterface {
 
-	r := q.sessionClient.R()
+	r := q.sessionClient().R().SetContext(ctx)
 	var successResult RespDataWithMeta[TaskDoing, TaskMeta]
 	var errorResult Resp
 	r.SetSuccessResult(&successResult)
@@ -195,11 +318,11 @@ func (q *Quark) objectDelete(objIds []string) pan.DriverErrorInterface {
 		return e
 	}
 	finish := result.Data.Finish
-	return checkTaskSuccess(finish, successResult, q)
+	return checkTaskSuccess(ctx, finish, successResult, q)
 }
 
-func (q *Quark) objectMove(objIds []string, dstId string) pan.DriverErrorInterface {
-	r := q.sessionClient.R()
+func (q *Quark) objectMove(ctx context.Context, objIds []string, dstId string) pan.DriverErrorInterface {
+	r := q.sessionClient().R().SetContext(ctx)
 	var successResult RespDataWithMeta[TaskDoing, TaskMeta]
 	var errorResult Resp
 	r.SetSuccessResult(&successResult)
@@ -216,17 +339,44 @@ func (q *Quark) objectMove(objIds []string, dstId string) pan.DriverErrorInterfa
 		return pan.OnlyError(err)
 	}
 	if response.IsErrorState() {
-		return pan.CodeMsg(errorResult.Code, errorResult.Msg)
+		return statusCodeMsg(errorResult.Status, errorResult.Code, errorResult.Msg)
+	}
+	if successResult.Status >= 400 || successResult.Code != 0 {
+		return statusCodeMsg(successResult.Status, successResult.Code, successResult.Msg)
+	}
+	finish := successResult.Data.Finish
+	return checkTaskSuccess(ctx, finish, successResult, q)
+}
+
+func (q *Quark) objectCopy(ctx context.Context, objIds []string, dstId string) pan.DriverErrorInterface {
+	r := q.sessionClient().R().SetContext(ctx)
+	var successResult RespDataWithMeta[TaskDoing, TaskMeta]
+	var errorResult Resp
+	r.SetSuccessResult(&successResult)
+	r.SetErrorResult(&errorResult)
+	r.SetBody(map[string]any{
+		"action_type":  2,
+		"exclude_fids": []string{},
+		"filelist":     objIds,
+		"to_pdir_fid":  dstId,
+	})
+	// object
+	response, err := r.Post("/file/copy")
+	if err != nil {
+		return pan.OnlyError(err)
+	}
+	if response.IsErrorState() {
+		return statusCodeMsg(errorResult.Status, errorResult.Code, errorResult.Msg)
 	}
 	if successResult.Status >= 400 || successResult.Code != 0 {
-		return pan.CodeMsg(successResult.Code, successResult.Msg)
+		return statusCodeMsg(successResult.Status, successResult.Code, successResult.Msg)
 	}
 	finish := successResult.Data.Finish
-	return checkTaskSuccess(finish, successResult, q)
+	return checkTaskSuccess(ctx, finish, successResult, q)
 }
 
-func (q *Quark) objectRename(objId, newName string) pan.DriverErrorInterface {
-	r := q.sessionClient.R()
+func (q *Quark) objectRename(ctx context.Context, objId, newName string) pan.DriverErrorInterface {
+	r := q.sessionClient().R().SetContext(ctx)
 	var successResult RespDataWithMeta[TaskDoing, TaskMeta]
 	var errorResult Resp
 	r.SetSuccessResult(&successResult)
@@ -241,17 +391,17 @@ func (q *Quark) objectRename(objId, newName string) pan.DriverErrorInterface {
 		return pan.OnlyError(err)
 	}
 	if response.IsErrorState() {
-		return pan.CodeMsg(errorResult.Code, errorResult.Msg)
+		return statusCodeMsg(errorResult.Status, errorResult.Code, errorResult.Msg)
 	}
 	if successResult.Status >= 400 || successResult.Code != 0 {
-		return pan.CodeMsg(successResult.Code, successResult.Msg)
+		return statusCodeMsg(successResult.Status, successResult.Code, successResult.Msg)
 	}
 	finish := successResult.Data.Finish
-	return checkTaskSuccess(finish, successResult, q)
+	return checkTaskSuccess(ctx, finish, successResult, q)
 }
 
-func (q *Quark) FileUploadPre(req FileUpPreReq) (*RespDataWithMeta[FileUpPre, FileUpPreMeta], error) {
-	r := q.sessionClient.R()
+func (q *Quark) FileUploadPre(ctx context.Context, req FileUpPreReq) (*RespDataWithMeta[FileUpPre, FileUpPreMeta], error) {
+	r := q.sessionClient().R().SetContext(ctx)
 	var successResult RespDataWithMeta[FileUpPre, FileUpPreMeta]
 	var errorResult Resp
 	r.SetSuccessResult(&successResult)
@@ -271,17 +421,17 @@ func (q *Quark) FileUploadPre(req FileUpPreReq) (*RespDataWithMeta[FileUpPre, Fi
 		return nil, err
 	}
 	if response.IsErrorState() {
-		return nil, fmt.Errorf("code: %d, msg: %s", errorResult.Code, errorResult.Msg)
+		return nil, statusCodeMsg(errorResult.Status, errorResult.Code, errorResult.Msg)
 	}
 	if successResult.Status >= 400 || successResult.Code != 0 {
-		return nil, fmt.Errorf("code: %d, msg: %s", successResult.Code, successResult.Msg)
+		return nil, statusCodeMsg(successResult.Status, successResult.Code, successResult.Msg)
 	}
 
 	return &successResult, nil
 }
 
-func (q *Quark) FileUploadHash(req FileUpHashReq) (*RespData[FileUpHash], error) {
-	r := q.sessionClient.R()
+func (q *Quark) FileUploadHash(ctx context.Context, req FileUpHashReq) (*RespData[FileUpHash], error) {
+	r := q.sessionClient().R().SetContext(ctx)
 	var successResult RespData[FileUpHash]
 	var errorResult Resp
 	r.SetSuccessResult(&successResult)
@@ -291,16 +441,16 @@ func (q *Quark) FileUploadHash(req FileUpHashReq) (*RespData[FileUpHash], error)
 		return nil, err
 	}
 	if response.IsErrorState() {
-		return nil, fmt.Errorf("code: %d, msg: %s", errorResult.Code, errorResult.Msg)
+		return nil, statusCodeMsg(errorResult.Status, errorResult.Code, errorResult.Msg)
 	}
 	if successResult.Status >= 400 || successResult.Code != 0 {
-		return nil, fmt.Errorf("code: %d, msg: %s", successResult.Code, successResult.Msg)
+		return nil, statusCodeMsg(successResult.Status, successResult.Code, successResult.Msg)
 	}
 
 	return &successResult, nil
 }
 
-func (q *Quark) FileUpPart(req FileUpPartReq) (string, error) {
+func (q *Quark) FileUpPart(ctx context.Context, req FileUpPartReq) (string, error) {
 	timeStr := time.Now().UTC().Format(http.TimeFormat)
 	data := map[string]any{
 		"auth_info": req.AuthInfo,
@@ -313,7 +463,7 @@ x-oss-user-agent:aliyun-sdk-js/6.6.1 Chrome 98.0.4758.80 on Windows 10 64-bit
 /%s/%s?partNumber=%d&uploadId=%s`, req.MineType, timeStr, timeStr, req.Bucket, req.ObjKey, req.PartNumber, req.UploadId),
 		"task_id": req.TaskId,
 	}
-	r := q.sessionClient.R()
+	r := q.sessionClient().R().SetContext(ctx)
 	var resp RespData[FileUpAuth]
 	r.SetSuccessResult(&resp)
 	r.SetBody(data)
@@ -323,7 +473,7 @@ x-oss-user-agent:aliyun-sdk-js/6.6.1 Chrome 98.0.4758.80 on Windows 10 64-bit
 	}
 
 	u := fmt.Sprintf("https://%s.%s/%s", req.Bucket, req.UploadUrl[7:], req.ObjKey)
-	r = q.defaultClient.R()
+	r = q.defaultClient.R().SetContext(ctx)
 	r.SetHeaders(map[string]string{
 		"Authorization":    resp.Data.AuthKey,
 		"Content-Type":     req.MineType,
@@ -341,12 +491,12 @@ x-oss-user-agent:aliyun-sdk-js/6.6.1 Chrome 98.0.4758.80 on Windows 10 64-bit
 		return "", err
 	}
 	if res.StatusCode != 200 {
-		return "", fmt.Errorf("up status: %d, error: %s", res.StatusCode, res.String())
+		return "", pan.KindCodeMsg(pan.StatusKind(res.StatusCode), res.StatusCode, "up error: "+res.String())
 	}
 	return res.Header.Get("ETag"), nil
 }
 
-func (q *Quark) FileUpCommit(req FileUpCommitReq, md5s []string) error {
+func (q *Quark) FileUpCommit(ctx context.Context, req FileUpCommitReq, md5s []string) error {
 	timeStr := time.Now().UTC().Format(http.TimeFormat)
 	bodyBuilder := strings.Builder{}
 	bodyBuilder.WriteString(`<?xml version="1.0" encoding="UTF-8"?>
@@ -384,7 +534,7 @@ x-oss-user-agent:aliyun-sdk-js/6.6.1 Chrome 98.0.4758.80 on Windows 10 64-bit
 		"task_id": req.TaskId,
 	}
 	var resp RespData[FileUpAuth]
-	r := q.sessionClient.R()
+	r := q.sessionClient().R().SetContext(ctx)
 	r.SetSuccessResult(&resp)
 	r.SetBody(data)
 	_, err = r.Post("/file/upload/auth")
@@ -392,7 +542,7 @@ x-oss-user-agent:aliyun-sdk-js/6.6.1 Chrome 98.0.4758.80 on Windows 10 64-bit
 		return err
 	}
 
-	r = q.defaultClient.R()
+	r = q.defaultClient.R().SetContext(ctx)
 	u := fmt.Sprintf("https://%s.%s/%s", req.Bucket, req.UploadUrl[7:], req.ObjKey)
 	res, err := r.
 		SetHeaders(map[string]string{
@@ -411,13 +561,13 @@ x-oss-user-agent:aliyun-sdk-js/6.6.1 Chrome 98.0.4758.80 on Windows 10 64-bit
 		return err
 	}
 	if res.StatusCode != 200 {
-		return fmt.Errorf("up status: %d, error: %s", res.StatusCode, res.String())
+		return pan.KindCodeMsg(pan.StatusKind(res.StatusCode), res.StatusCode, "up error: "+res.String())
 	}
 	return nil
 }
 
-func (q *Quark) FileUpFinish(req FileUpFinishReq) (*Resp, error) {
-	r := q.sessionClient.R()
+func (q *Quark) FileUpFinish(ctx context.Context, req FileUpFinishReq) (*Resp, error) {
+	r := q.sessionClient().R().SetContext(ctx)
 	var result Resp
 	r.SetSuccessResult(&result)
 	r.SetErrorResult(&result)
@@ -426,16 +576,16 @@ func (q *Quark) FileUpFinish(req FileUpFinishReq) (*Resp, error) {
 		return nil, err
 	}
 	if response.IsErrorState() {
-		return nil, fmt.Errorf("code: %d, msg: %s", result.Code, result.Msg)
+		return nil, statusCodeMsg(result.Status, result.Code, result.Msg)
 	}
 	if result.Status >= 400 || result.Code != 0 {
-		return nil, fmt.Errorf("code: %d, msg: %s", result.Code, result.Msg)
+		return nil, statusCodeMsg(result.Status, result.Code, result.Msg)
 	}
 	return &result, nil
 }
 
-func (q *Quark) fileDownload(fileId string) (*RespData[[]DownloadData], pan.DriverErrorInterface) {
-	r := q.sessionClient.R()
+func (q *Quark) fileDownload(ctx context.Context, fileId string) (*RespData[[]DownloadData], pan.DriverErrorInterface) {
+	r := q.sessionClient().R().SetContext(ctx)
 	data := map[string]any{
 		"fids": []string{fileId},
 	}
@@ -447,12 +597,12 @@ func (q *Quark) fileDownload(fileId string) (*RespData[[]DownloadData], pan.Driv
 	return funReturnBySuccess(err, response, errorResult, successResult)
 }
 
-func (q *Quark) share(req ShareReq) (string, pan.DriverErrorInterface) {
+func (q *Quark) share(ctx context.Context, req ShareReq) (string, pan.DriverErrorInterface) {
 	shareId := ""
 	if req.UrlType == 2 && req.Passcode == "" {
 		req.Passcode = internal.GenRandomWord()
 	}
-	r := q.sessionClient.R()
+	r := q.sessionClient().R().SetContext(ctx)
 	var successResult RespDataWithMeta[TaskDoing, TaskMeta]
 	var errorResult Resp
 	r.SetSuccessResult(&successResult)
@@ -470,8 +620,10 @@ func (q *Quark) share(req ShareReq) (string, pan.DriverErrorInterface) {
 		if isFinish {
 			break
 		}
-		time.Sleep(time.Duration(result.Metadata.TqGap) * time.Millisecond)
-		query, err := q.taskQuery(result.Data.TaskId)
+		if err := internal.SleepContext(ctx, time.Duration(result.Metadata.TqGap)*time.Millisecond); err != nil {
+			return shareId, pan.OnlyError(err)
+		}
+		query, err := q.taskQuery(ctx, result.Data.TaskId)
 		if err != nil {
 			return shareId, err
 		}
@@ -483,8 +635,8 @@ func (q *Quark) share(req ShareReq) (string, pan.DriverErrorInterface) {
 	return shareId, nil
 }
 
-func (q *Quark) sharePassword(shareId string) (*RespData[SharePasswordData], pan.DriverErrorInterface) {
-	r := q.sessionClient.R()
+func (q *Quark) sharePassword(ctx context.Context, shareId string) (*RespData[SharePasswordData], pan.DriverErrorInterface) {
+	r := q.sessionClient().R().SetContext(ctx)
 	var successResult RespData[SharePasswordData]
 	var errorResult Resp
 	r.SetSuccessResult(&successResult)
@@ -497,9 +649,9 @@ func (q *Quark) sharePassword(shareId string) (*RespData[SharePasswordData], pan
 	return funReturnBySuccess(err, response, errorResult, successResult)
 }
 
-func (q *Quark) shareList() ([]*ShareList, pan.DriverErrorInterface) {
+func (q *Quark) shareList(ctx context.Context) ([]*ShareList, pan.DriverErrorInterface) {
 	shareList := make([]*ShareList, 0)
-	r := q.sessionClient.R()
+	r := q.sessionClient().R().SetContext(ctx)
 	page := 1
 	size := 100
 	query := map[string]string{
@@ -528,8 +680,8 @@ func (q *Quark) shareList() ([]*ShareList, pan.DriverErrorInterface) {
 	return shareList, nil
 }
 
-func (q *Quark) shareDelete(shareIds []string) (*Resp, error) {
-	r := q.sessionClient.R()
+func (q *Quark) shareDelete(ctx context.Context, shareIds []string) (*Resp, error) {
+	r := q.sessionClient().R().SetContext(ctx)
 	var result Resp
 	r.SetSuccessResult(&result)
 	r.SetErrorResult(&result)
@@ -541,8 +693,8 @@ func (q *Quark) shareDelete(shareIds []string) (*Resp, error) {
 	return funReturn(err, response, result)
 }
 
-func (q *Quark) shareToken(shareTokenReq ShareTokenReq) (*RespData[ShareTokenResp], error) {
-	r := q.sessionClient.R()
+func (q *Quark) shareToken(ctx context.Context, shareTokenReq ShareTokenReq) (*RespData[ShareTokenResp], error) {
+	r := q.sessionClient().R().SetContext(ctx)
 	var successResult RespData[ShareTokenResp]
 	var errorResult Resp
 	r.SetSuccessResult(&successResult)
@@ -552,8 +704,8 @@ func (q *Quark) shareToken(shareTokenReq ShareTokenReq) (*RespData[ShareTokenRes
 	return funReturnBySuccess(err, response, errorResult, successResult)
 }
 
-func (q *Quark) shareDetail(shareDetailReq ShareDetailReq) (*ShareDetailResp, error) {
-	r := q.sessionClient.R()
+func (q *Quark) shareDetail(ctx context.Context, shareDetailReq ShareDetailReq) (*ShareDetailResp, error) {
+	r := q.sessionClient().R().SetContext(ctx)
 	page := 1
 	size := 100
 	query := map[string]string{
@@ -593,8 +745,8 @@ func (q *Quark) shareDetail(shareDetailReq ShareDetailReq) (*ShareDetailResp, er
 	return returnResult, nil
 }
 
-func (q *Quark) shareRestore(restoreReq RestoreReq) pan.DriverErrorInterface {
-	r := q.sessionClient.R()
+func (q *Quark) shareRestore(ctx context.Context, restoreReq RestoreReq) pan.DriverErrorInterface {
+	r := q.sessionClient().R().SetContext(ctx)
 	var successResult RespDataWithMeta[TaskDoing, TaskMeta]
 	var errorResult Resp
 	r.SetSuccessResult(&successResult)
@@ -605,5 +757,5 @@ func (q *Quark) shareRestore(restoreReq RestoreReq) pan.DriverErrorInterface {
 	if e != nil {
 		return e
 	}
-	return checkTaskSuccess(false, *result, q)
+	return checkTaskSuccess(ctx, false, *result, q)
 }
@@ -2,6 +2,7 @@ package quark
 
 import (
 	"io"
+	"time"
 )
 
 // Resp 基础序列化器
@@ -101,6 +102,23 @@ type MemberData struct {
 
 type FileList struct {
 	List []File
+	// FullPath 请求带_fetch_full_path时返回，目录从根目录下一级到自身的路径
+	FullPath []PathNode `json:"full_path,omitempty"`
+}
+
+type PathNode struct {
+	Fid      string `json:"fid"`
+	FileName string `json:"file_name"`
+}
+
+// milliTime 取第一个非0的毫秒时间戳转为时间，都为0时返回零值
+func milliTime(millis ...int64) time.Time {
+	for _, milli := range millis {
+		if milli > 0 {
+			return time.UnixMilli(milli)
+		}
+	}
+	return time.Time{}
 }
 
 type File struct {
@@ -252,6 +270,11 @@ type FileUpHash struct {
 	FormatType string `json:"format_type"`
 }
 
+type PathFid struct {
+	Fid      string `json:"fid"`
+	FilePath string `json:"file_path"`
+}
+
 type FileUpPartReq struct {
 	ObjKey     string `json:"obj_key"`
 	Bucket     string `json:"bucket"`
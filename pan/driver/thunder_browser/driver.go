@@ -1,6 +1,7 @@
 package thunder_browser
 
 import (
+	"context"
 	"fmt"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/credentials"
@@ -10,18 +11,20 @@ import (
 	"github.com/hefeiyu2025/pan-client/internal"
 	"github.com/hefeiyu2025/pan-client/pan"
 	"github.com/imroc/req/v3"
-	logger "github.com/sirupsen/logrus"
 	"io"
 	"net/url"
-	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type ThunderBrowser struct {
-	sessionClient  *req.Client
+	// session 带设备id的client，与配置一起整个替换
+	session        atomic.Pointer[req.Client]
+	sessionMu      sync.Mutex
 	downloadClient *req.Client
 	pan.PropertiesOperate[*ThunderBrowserProperties]
 	pan.CacheOperate
@@ -30,22 +33,22 @@ type ThunderBrowser struct {
 }
 
 type ThunderBrowserProperties struct {
-	Id string `mapstructure:"id" json:"id" yaml:"id"`
+	Id string `mapstructure:"id" json:"id" yaml:"id" desc:"账号id，为空时自动生成"`
 	// 登录方式1
-	Username string `mapstructure:"username" json:"username" yaml:"username"`
-	Password string `mapstructure:"password" json:"password" yaml:"password"`
+	Username string `mapstructure:"username" json:"username" yaml:"username" validate:"required_without=refresh_token" desc:"登录账号，与refresh_token二选一"`
+	Password string `mapstructure:"password" json:"password" yaml:"password" secret:"true" validate:"required_without=refresh_token" desc:"登录密码"`
 	// 登录方式2
-	RefreshToken string `mapstructure:"refresh_token" json:"refresh_token" yaml:"refresh_token"`
+	RefreshToken string `mapstructure:"refresh_token" json:"refresh_token" yaml:"refresh_token" secret:"true" desc:"登录后的refresh_token，与账号密码二选一"`
 
 	// 验证码
-	CaptchaToken string `mapstructure:"captcha_token" json:"captcha_token" yaml:"captcha_token"`
+	CaptchaToken string `mapstructure:"captcha_token" json:"captcha_token" yaml:"captcha_token" secret:"true"`
 
 	DeviceID string `mapstructure:"device_id" json:"device_id" yaml:"device_id"`
 
 	ExpiresIn int64 `mapstructure:"expires_in" json:"expires_in" yaml:"expires_in"`
 
 	TokenType   string `mapstructure:"token_type" json:"token_type" yaml:"token_type"`
-	AccessToken string `mapstructure:"access_token" json:"access_token" yaml:"access_token"`
+	AccessToken string `mapstructure:"access_token" json:"access_token" yaml:"access_token" secret:"true"`
 
 	Sub    string `mapstructure:"sub" json:"sub" yaml:"sub"`
 	UserID string `mapstructure:"user_id" json:"user_id" yaml:"user_id"`
@@ -65,69 +68,145 @@ func (cp *ThunderBrowserProperties) GetDriverType() pan.DriverType {
 	return pan.Cloudreve
 }
 func (tb *ThunderBrowser) Init() (string, error) {
+	ctx := context.Background()
 	err := tb.ReadConfig()
 	if err != nil {
 		return "", err
 	}
 	driverId := tb.GetId()
-	if (tb.Properties.Username == "" || tb.Properties.Password == "") && tb.Properties.RefreshToken == "" {
+	tb.SetInstanceId(driverId)
+	if err = tb.ValidateConfig(); err != nil {
 		_ = tb.WriteConfig()
-		return driverId, fmt.Errorf("please set login info ")
+		return driverId, err
+	}
+	err = tb.ensureLogin(ctx, tb.Props())
+	if err != nil {
+		return driverId, err
+	}
+
+	tb.downloadClient = req.C().SetCommonHeader(HeaderUserAgent, DownloadUserAgent)
+
+	err = tb.WriteConfig()
+	if err != nil {
+		return driverId, err
 	}
-	tb.Properties.DeviceID = internal.Md5HashStr(tb.Properties.Username + tb.Properties.Password)
+	return driverId, nil
+}
+
+func (tb *ThunderBrowser) newSessionClient(properties *ThunderBrowserProperties) *req.Client {
 	commonHeaderMap := map[string]string{
 		HeaderUserAgent:    BuildCustomUserAgent(PackageName, SdkVersion, ClientVersion),
 		"accept":           "application/json;charset=UTF-8",
-		"x-device-id":      tb.Properties.DeviceID,
+		"x-device-id":      properties.DeviceID,
 		"x-client-id":      ClientID,
 		"x-client-version": ClientVersion,
 	}
-	tb.sessionClient = req.C().SetCommonHeaders(commonHeaderMap)
+	return req.C().SetCommonHeaders(commonHeaderMap)
+}
+
+// sessionClient 当前的session，一次操作中只取一次
+func (tb *ThunderBrowser) sessionClient() *req.Client {
+	return tb.session.Load()
+}
+
+// loginSession 登录时使用的client和配置，登录成功后才一起替换到driver上
+type loginSession struct {
+	client     *req.Client
+	properties *ThunderBrowserProperties
+}
+
+// newLoginSession 按properties的副本和设备id新建client
+func (tb *ThunderBrowser) newLoginSession(properties *ThunderBrowserProperties, deviceId string) *loginSession {
+	p := *properties
+	p.DeviceID = deviceId
+	return &loginSession{client: tb.newSessionClient(&p), properties: &p}
+}
 
-	_, err = tb.userMe()
+// currentSession 当前的client和配置的副本
+func (tb *ThunderBrowser) currentSession() *loginSession {
+	tb.sessionMu.Lock()
+	defer tb.sessionMu.Unlock()
+	p := *tb.Props()
+	return &loginSession{client: tb.sessionClient(), properties: &p}
+}
+
+// commitSession 替换driver上的client和配置
+func (tb *ThunderBrowser) commitSession(s *loginSession) {
+	tb.sessionMu.Lock()
+	defer tb.sessionMu.Unlock()
+	tb.SetProperties(s.properties)
+	tb.session.Store(s.client)
+}
+
+// ensureLogin 按properties的副本新建session，token失效时依次用refreshToken和账号密码登录
+// 成功后才替换driver上的client和配置，失败时保留原来的并返回错误
+func (tb *ThunderBrowser) ensureLogin(ctx context.Context, properties *ThunderBrowserProperties) error {
+	s := tb.newLoginSession(properties, internal.Md5HashStr(properties.Username+properties.Password))
 	// 若能拿到用户信息，证明已经登录
+	_, err := tb.userMe(ctx, s)
+	// refreshToken不为空，则先用token登录
+	if err != nil && properties.RefreshToken != "" {
+		rs := tb.newLoginSession(properties, internal.Md5HashStr(properties.RefreshToken))
+		if _, err = tb.refreshToken(ctx, rs); err == nil {
+			s = rs
+		}
+	}
 	if err != nil {
-
-		// refreshToken不为空，则先用token登录
-		if tb.Properties.RefreshToken != "" {
-			tb.Properties.DeviceID = internal.Md5HashStr(tb.Properties.RefreshToken)
-			_, loginErr := tb.refreshToken(tb.Properties.RefreshToken)
-			if loginErr != nil {
-				_, loginErr = tb.login(tb.Properties.Username, tb.Properties.Password)
-				if loginErr != nil {
-					return driverId, loginErr
-				}
-			}
-		} else {
-			_, loginErr := tb.login(tb.Properties.Username, tb.Properties.Password)
-			if loginErr != nil {
-				return driverId, loginErr
-			}
+		if _, err = tb.login(ctx, s); err != nil {
+			return err
 		}
 	}
+	tb.commitSession(s)
+	return nil
+}
 
-	tb.downloadClient = req.C().SetCommonHeader(HeaderUserAgent, DownloadUserAgent)
-
-	err = tb.WriteConfig()
-	if err != nil {
-		return driverId, err
+// Reload 配置中的登录信息变化时按新的配置重新登录，已经发出的请求仍使用旧的client
+func (tb *ThunderBrowser) Reload(ctx context.Context) error {
+	properties, changed, err := tb.ReloadConfig()
+	if err != nil || !changed {
+		return err
 	}
-	return driverId, nil
+	if err = tb.ensureLogin(ctx, properties); err != nil {
+		return err
+	}
+	tb.Logger().Infof("thunder browser %s reloaded", tb.GetId())
+	return tb.WriteConfig()
 }
 
 func (tb *ThunderBrowser) InitByCustom(id string, read pan.ConfigRW, write pan.ConfigRW) (string, error) {
 	tb.Properties = &ThunderBrowserProperties{Id: id}
+	tb.PropertiesOperate.AccountId = id
 	tb.PropertiesOperate.Write = write
 	tb.PropertiesOperate.Read = read
 	return tb.Init()
 }
 
 func (tb *ThunderBrowser) Drop() error {
-	return pan.OnlyMsg("drop not support")
+	tb.CancelAll()
+	if tb.Discarded() {
+		// 缓存和注册属于同账号的已有实例
+		return nil
+	}
+	tb.ClearCache()
+	tb.Unregister(tb.GetId())
+	return nil
 }
 
-func (tb *ThunderBrowser) Disk() (*pan.DiskResp, error) {
-	about, err := tb.about()
+func (tb *ThunderBrowser) Capabilities() pan.Capabilities {
+	return pan.Capabilities{
+		Drop:            true,
+		OfflineDownload: true,
+		TaskList:        true,
+		Share:           true,
+		ShareRestore:    true,
+		Copy:            true,
+		Search:          true,
+		NativeListPage:  true,
+	}
+}
+
+func (tb *ThunderBrowser) Disk(ctx context.Context) (*pan.DiskResp, error) {
+	about, err := tb.about(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -142,13 +221,13 @@ func (tb *ThunderBrowser) Disk() (*pan.DiskResp, error) {
 		},
 	}, nil
 }
-func (tb *ThunderBrowser) List(req pan.ListReq) ([]*pan.PanObj, error) {
+func (tb *ThunderBrowser) List(ctx context.Context, req pan.ListReq) ([]*pan.PanObj, error) {
 	queryDir := req.Dir
 	if queryDir.Path == "/" && queryDir.Name == "" {
 		queryDir.Id = "0"
 	}
 	if queryDir.Id == "" {
-		obj, err := tb.GetPanObj(strings.TrimRight(queryDir.Path, "/")+"/"+queryDir.Name, true, tb.List)
+		obj, err := tb.GetPanObj(ctx, strings.TrimRight(queryDir.Path, "/")+"/"+queryDir.Name, true, tb.List)
 		if err != nil {
 			return nil, err
 		}
@@ -159,30 +238,18 @@ func (tb *ThunderBrowser) List(req pan.ListReq) ([]*pan.PanObj, error) {
 		tb.Del(cacheKey)
 	}
 	panObjs, exist, err := tb.GetOrDefault(cacheKey, func() (interface{}, error) {
-		files, e := tb.getFiles(queryDir.Id)
+		files, e := tb.getFiles(ctx, queryDir.Id)
 		if e != nil {
-			logger.Error(e)
+			tb.Logger().Error(e)
 			return nil, e
 		}
 		panObjs := make([]*pan.PanObj, 0)
 		for _, item := range files {
-			fileType := "file"
-			if item.Kind == "drive#folder" {
-				fileType = "dir"
-			}
 			path := strings.TrimRight(queryDir.Path, "/") + "/" + queryDir.Name
 			if queryDir.Id == "" {
 				path = "/"
 			}
-			size, _ := strconv.ParseInt(item.Size, 10, 64)
-			panObjs = append(panObjs, &pan.PanObj{
-				Id:     item.ID,
-				Name:   item.Name,
-				Path:   path,
-				Size:   size,
-				Type:   fileType,
-				Parent: req.Dir,
-			})
+			panObjs = append(panObjs, filesToPanObj(item, path, req.Dir))
 		}
 		return panObjs, nil
 	})
@@ -197,59 +264,166 @@ func (tb *ThunderBrowser) List(req pan.ListReq) ([]*pan.PanObj, error) {
 	}
 	return make([]*pan.PanObj, 0), nil
 }
-func (tb *ThunderBrowser) ObjRename(req pan.ObjRenameReq) error {
-	if req.Obj.Id == "0" || (req.Obj.Path == "/" && req.Obj.Name == "") {
-		return pan.OnlyMsg("not support rename root path")
+func (tb *ThunderBrowser) ListPage(ctx context.Context, req pan.ListPageReq) (*pan.ListPageResp, error) {
+	queryDir := req.Dir
+	if queryDir.Path == "/" && queryDir.Name == "" {
+		queryDir.Id = "0"
 	}
-	object := req.Obj
-	if object.Id == "" {
-		path := strings.Trim(req.Obj.Path, "/") + "/" + req.Obj.Name
-		obj, err := tb.GetPanObj(path, true, tb.List)
+	if queryDir.Id == "" {
+		obj, err := tb.GetPanObj(ctx, strings.TrimRight(queryDir.Path, "/")+"/"+queryDir.Name, true, tb.List)
 		if err != nil {
-			return err
+			return nil, err
 		}
-		object = obj
+		queryDir = obj
 	}
-	newFile, err := tb.rename(object.Id, req.NewName)
+	fileList, err := tb.getFilesPage(ctx, queryDir.Id, req.PageToken, req.PageSize)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	tb.Del(cacheDirectoryPrefix + newFile.ParentID)
-	return nil
+	path := strings.TrimRight(queryDir.Path, "/") + "/" + queryDir.Name
+	if queryDir.Id == "0" {
+		path = "/"
+	}
+	resp := &pan.ListPageResp{
+		Objs:          make([]*pan.PanObj, 0, len(fileList.Files)),
+		NextPageToken: fileList.NextPageToken,
+	}
+	for _, item := range fileList.Files {
+		resp.Objs = append(resp.Objs, filesToPanObj(item, path, queryDir))
+	}
+	return resp, nil
 }
-func (tb *ThunderBrowser) BatchRename(req pan.BatchRenameReq) error {
-	objs, err := tb.List(pan.ListReq{
-		Reload: true,
-		Dir:    req.Path,
-	})
+
+func filesToPanObj(item *Files, path string, parent *pan.PanObj) *pan.PanObj {
+	fileType := "file"
+	if item.Kind == "drive#folder" {
+		fileType = "dir"
+	}
+	size, _ := strconv.ParseInt(item.Size, 10, 64)
+	hashes := make(map[string]string)
+	if item.Md5Checksum != "" {
+		hashes[pan.HashMd5] = item.Md5Checksum
+	}
+	if item.Hash != "" {
+		hashes[pan.HashGcid] = item.Hash
+	}
+	return &pan.PanObj{
+		Id:         item.ID,
+		Name:       item.Name,
+		Path:       path,
+		Size:       size,
+		Type:       fileType,
+		ModTime:    item.ModifiedTime.Time,
+		CreateTime: item.CreatedTime.Time,
+		Hashes:     hashes,
+		MimeType:   item.MimeType,
+		Parent:     parent,
+	}
+}
+
+func (tb *ThunderBrowser) Stat(ctx context.Context, path string) (*pan.PanObj, error) {
+	// 迅雷没有按路径查找的接口，先通过缓存遍历拿到id，再按id获取最新信息
+	obj, err := tb.BaseStat(ctx, path, tb.List)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if obj.Id == "0" {
+		return obj, nil
+	}
+	file, e := tb.getFile(ctx, obj.Id)
+	if e != nil {
+		return nil, e
 	}
-	for _, object := range objs {
-		if object.Type == "dir" {
-			err = tb.BatchRename(pan.BatchRenameReq{
-				Path: object,
-				Func: req.Func,
-			})
+	if file.Trashed {
+		return nil, pan.NotFound(path)
+	}
+	return filesToPanObj(file, obj.Path, obj.Parent), nil
+}
+
+// Search 迅雷的搜索结果只有父目录id，限定了目录时沿结果的父目录向上查找来判断范围并补全路径，否则只有根目录下的结果有路径
+func (tb *ThunderBrowser) Search(ctx context.Context, req pan.SearchReq) ([]*pan.PanObj, error) {
+	if req.Keyword == "" {
+		return nil, pan.OnlyMsg("search keyword is empty")
+	}
+	kind := ""
+	switch req.Type {
+	case pan.SearchDir:
+		kind = FOLDER
+	case pan.SearchAll:
+	default:
+		kind = FILE
+	}
+	root := pan.DirObj("0", "/")
+	var index *pan.AncestorIndex
+	if req.ScopePath() != "/" {
+		dir := req.Dir
+		if dir.Id == "" {
+			obj, err := tb.Stat(ctx, pan.ObjPath(dir))
 			if err != nil {
-				return err
+				return nil, err
 			}
+			dir = obj
 		}
-		newName := req.Func(object)
-
-		if newName != object.Name {
-			err = tb.ObjRename(pan.ObjRenameReq{
-				Obj:     object,
-				NewName: newName,
-			})
+		index = pan.NewAncestorIndex(dir, "0", func(ctx context.Context, id string) (string, string, error) {
+			file, err := tb.getFile(ctx, id)
 			if err != nil {
-				return err
+				return "", "", err
+			}
+			return file.Name, file.ParentID, nil
+		})
+	}
+	files, err := tb.searchFiles(ctx, req.Keyword, kind)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*pan.PanObj, 0)
+	for _, item := range files {
+		var obj *pan.PanObj
+		if index != nil {
+			parent, e := index.Dir(ctx, item.ParentID)
+			if e != nil {
+				return nil, e
+			}
+			if parent == nil {
+				continue
 			}
+			obj = filesToPanObj(item, pan.ObjPath(parent), parent)
+		} else if item.ParentID == "" {
+			obj = filesToPanObj(item, "/", root)
+		} else {
+			obj = filesToPanObj(item, "", &pan.PanObj{Id: item.ParentID, Type: "dir"})
+		}
+		if req.Match(obj) {
+			result = append(result, obj)
+		}
+	}
+	return result, nil
+}
+
+func (tb *ThunderBrowser) ObjRename(ctx context.Context, req pan.ObjRenameReq) error {
+	if req.Obj.Id == "0" || (req.Obj.Path == "/" && req.Obj.Name == "") {
+		return pan.OnlyMsg("not support rename root path")
+	}
+	object := req.Obj
+	if object.Id == "" {
+		path := strings.Trim(req.Obj.Path, "/") + "/" + req.Obj.Name
+		obj, err := tb.GetPanObj(ctx, path, true, tb.List)
+		if err != nil {
+			return err
 		}
+		object = obj
+	}
+	newFile, err := tb.rename(ctx, object.Id, req.NewName)
+	if err != nil {
+		return err
 	}
+	tb.Del(cacheDirectoryPrefix + newFile.ParentID)
 	return nil
 }
-func (tb *ThunderBrowser) Mkdir(req pan.MkdirReq) (*pan.PanObj, error) {
+func (tb *ThunderBrowser) BatchRename(ctx context.Context, req pan.BatchRenameReq) error {
+	return tb.BaseBatchRename(ctx, req, tb.List, tb.ObjRename)
+}
+func (tb *ThunderBrowser) Mkdir(ctx context.Context, req pan.MkdirReq) (*pan.PanObj, error) {
 	if req.NewPath == "" {
 		// 不处理，直接返回
 		return &pan.PanObj{
@@ -267,7 +441,7 @@ func (tb *ThunderBrowser) Mkdir(req pan.MkdirReq) (*pan.PanObj, error) {
 	if req.Parent != nil && (req.Parent.Id == "0" || req.Parent.Path == "/") {
 		targetPath = req.Parent.Path + "/" + strings.Trim(req.NewPath, "/")
 	}
-	obj, err := tb.GetPanObj(targetPath, false, tb.List)
+	obj, err := tb.GetPanObj(ctx, targetPath, false, tb.List)
 	if err != nil {
 		return nil, err
 	}
@@ -286,7 +460,7 @@ func (tb *ThunderBrowser) Mkdir(req pan.MkdirReq) (*pan.PanObj, error) {
 		split := strings.Split(rel, "/")
 		targetDirId := obj.Id
 		for _, s := range split {
-			resp, err := tb.makeDir(s, targetDirId)
+			resp, err := tb.makeDir(ctx, s, targetDirId)
 			if err != nil {
 				return nil, pan.OnlyError(err)
 			}
@@ -294,17 +468,17 @@ func (tb *ThunderBrowser) Mkdir(req pan.MkdirReq) (*pan.PanObj, error) {
 			targetDirId = resp.File.ID
 		}
 		tb.Del(cacheDirectoryPrefix + obj.Id)
-		return tb.Mkdir(req)
+		return tb.Mkdir(ctx, req)
 	}
 }
-func (tb *ThunderBrowser) Move(req pan.MovieReq) error {
+func (tb *ThunderBrowser) Move(ctx context.Context, req pan.MovieReq) error {
 	targetObj := req.TargetObj
 	if targetObj.Type == "file" {
 		return pan.OnlyMsg("target is a file")
 	}
 	// 重新直接创建目标目录
 	if targetObj.Id == "" {
-		create, err := tb.Mkdir(pan.MkdirReq{
+		create, err := tb.Mkdir(ctx, pan.MkdirReq{
 			NewPath: strings.Trim(targetObj.Path, "/") + "/" + targetObj.Name,
 		})
 		if err != nil {
@@ -312,34 +486,45 @@ func (tb *ThunderBrowser) Move(req pan.MovieReq) error {
 		}
 		targetObj = create
 	}
-	reloadDirId := make(map[string]any)
-	objIds := make([]string, 0)
-	for _, item := range req.Items {
-		if item.Id != "0" && item.Id != "" {
-			objIds = append(objIds, item.Id)
-			if item.Type == "dir" {
-				reloadDirId[item.Id] = true
-			}
-		} else if item.Path != "" && item.Path != "/" {
-			obj, err := tb.GetPanObj(item.Path, true, tb.List)
-			if err == nil {
-				objIds = append(objIds, obj.Id)
-				if obj.Type == "dir" {
-					reloadDirId[obj.Id] = true
-				}
-			}
+	objIds, dirIds, err := tb.ResolveItems(ctx, req.Items, tb.List)
+	if err != nil {
+		return err
+	}
+	if e := tb.move(ctx, objIds, targetObj.Id); e != nil {
+		return pan.OnlyError(e)
+	}
+	for _, id := range dirIds {
+		tb.Del(cacheDirectoryPrefix + id)
+	}
+	return nil
+}
+func (tb *ThunderBrowser) Copy(ctx context.Context, req pan.CopyReq) error {
+	targetObj := req.TargetObj
+	if targetObj.Type == "file" {
+		return pan.OnlyMsg("target is a file")
+	}
+	// 重新直接创建目标目录
+	if targetObj.Id == "" {
+		create, err := tb.Mkdir(ctx, pan.MkdirReq{
+			NewPath: strings.Trim(targetObj.Path, "/") + "/" + targetObj.Name,
+		})
+		if err != nil {
+			return err
 		}
+		targetObj = create
 	}
-	err := tb.move(objIds, targetObj.Id)
+	objIds, _, err := tb.ResolveItems(ctx, req.Items, tb.List)
 	if err != nil {
-		return pan.OnlyError(err)
+		return err
 	}
-	for key, _ := range reloadDirId {
-		tb.Del(cacheDirectoryPrefix + key)
+	if e := tb.copy(ctx, objIds, targetObj.Id); e != nil {
+		return pan.OnlyError(e)
 	}
+	// 源对象不变，只有目标目录的列表需要刷新
+	tb.Del(cacheDirectoryPrefix + targetObj.Id)
 	return nil
 }
-func (tb *ThunderBrowser) Delete(req pan.DeleteReq) error {
+func (tb *ThunderBrowser) Delete(ctx context.Context, req pan.DeleteReq) error {
 	if len(req.Items) == 0 {
 		return nil
 	}
@@ -356,7 +541,7 @@ func (tb *ThunderBrowser) Delete(req pan.DeleteReq) error {
 				}
 			}
 		} else if item.Path != "" && item.Path != "/" {
-			obj, err := tb.GetPanObj(item.Path, true, tb.List)
+			obj, err := tb.GetPanObj(ctx, item.Path, true, tb.List)
 			if err == nil {
 				objIds = append(objIds, obj.Id)
 				if obj.Type == "dir" {
@@ -368,7 +553,7 @@ func (tb *ThunderBrowser) Delete(req pan.DeleteReq) error {
 		}
 	}
 	if len(objIds) > 0 {
-		err := tb.remove(objIds)
+		err := tb.remove(ctx, objIds)
 		if err != nil {
 			return err
 		}
@@ -380,23 +565,32 @@ func (tb *ThunderBrowser) Delete(req pan.DeleteReq) error {
 	return nil
 }
 
-func (tb *ThunderBrowser) UploadPath(req pan.UploadPathReq) error {
-	return tb.BaseUploadPath(req, tb.UploadFile)
+func (tb *ThunderBrowser) UploadPath(ctx context.Context, req pan.UploadPathReq) error {
+	return tb.BaseUploadPath(ctx, req, tb.UploadFile)
+}
+
+func (tb *ThunderBrowser) UploadFile(ctx context.Context, req pan.UploadFileReq) error {
+	if req.OnlyFast {
+		return pan.NotSupported("thunder_browser fast upload")
+	}
+	return tb.BaseUploadFile(ctx, req, tb.UploadStream)
 }
 
-func (tb *ThunderBrowser) UploadFile(req pan.UploadFileReq) error {
+func (tb *ThunderBrowser) UploadStream(ctx context.Context, req pan.UploadStreamReq) error {
+	return tb.BaseUploadStream(ctx, req, tb.uploadStream)
+}
+
+func (tb *ThunderBrowser) uploadStream(ctx context.Context, req pan.UploadStreamReq) error {
+	ctx, cancel := tb.BindContext(ctx)
+	defer cancel()
 	if req.Resumable {
-		logger.Warn("thunder_browser is not support resumeable")
+		tb.Logger().Warn("thunder_browser is not support resumeable")
 	}
 	if req.OnlyFast {
-		return pan.OnlyMsg("thunder_browser is not support fast upload")
+		return pan.NotSupported("thunder_browser fast upload")
 	}
 
-	stat, err := os.Stat(req.LocalFile)
-	if err != nil {
-		return err
-	}
-	remoteName := stat.Name()
+	remoteName := req.Name
 	remotePath := strings.TrimRight(req.RemotePath, "/")
 	if req.RemotePathTransfer != nil {
 		remotePath = req.RemotePathTransfer(remotePath)
@@ -405,31 +599,34 @@ func (tb *ThunderBrowser) UploadFile(req pan.UploadFileReq) error {
 		remoteName = req.RemoteNameTransfer(remoteName)
 	}
 	remoteAllPath := remotePath + "/" + remoteName
-	_, err = tb.GetPanObj(remoteAllPath, true, tb.List)
+	_, err := tb.GetPanObj(ctx, remoteAllPath, true, tb.List)
 	// 没有报错证明文件已经存在
 	if err == nil {
-		return pan.CodeMsg(CodeObjectExist, remoteAllPath+" is exist")
+		return pan.KindCodeMsg(pan.ErrAlreadyExists, CodeObjectExist, remoteAllPath+" is exist")
 	}
-	dir, err := tb.Mkdir(pan.MkdirReq{
+	dir, err := tb.Mkdir(ctx, pan.MkdirReq{
 		NewPath: remotePath,
 	})
 	if err != nil {
 		return pan.MsgError(remotePath+" create error", err)
 	}
 
-	gcid, err := internal.GetFileGcid(req.LocalFile)
-	if err != nil {
-		return err
+	// 创建任务需要先得到gcid
+	cleanup, e := tb.PrepareStreamHash(ctx, &req, pan.HashGcid)
+	defer cleanup()
+	if e != nil {
+		return e
 	}
+	gcid := req.Hashes[pan.HashGcid]
 	parentId := dir.Id
 	if parentId == "0" {
 		parentId = ""
 	}
-	resp, err := tb.uploadTask(UploadTaskRequest{
+	resp, err := tb.uploadTask(ctx, UploadTaskRequest{
 		Kind:       FILE,
 		ParentId:   parentId,
 		Name:       remoteName,
-		Size:       stat.Size(),
+		Size:       req.Size,
 		Hash:       gcid,
 		UploadType: UploadTypeResumable,
 		Space:      ThunderDriveSpace,
@@ -451,63 +648,66 @@ func (tb *ThunderBrowser) UploadFile(req pan.UploadFileReq) error {
 			return err
 		}
 		uploader := s3manager.NewUploader(s)
-		if stat.Size() > s3manager.MaxUploadParts*s3manager.DefaultUploadPartSize {
-			uploader.PartSize = stat.Size() / (s3manager.MaxUploadParts - 1)
-		}
-		file, err := os.Open(req.LocalFile)
-		if err != nil {
-			return err
+		if req.Size > s3manager.MaxUploadParts*s3manager.DefaultUploadPartSize {
+			uploader.PartSize = req.Size / (s3manager.MaxUploadParts - 1)
 		}
-		_, err = uploader.Upload(&s3manager.UploadInput{
+		_, err = uploader.UploadWithContext(ctx, &s3manager.UploadInput{
 			Bucket:  aws.String(param.Bucket),
 			Key:     aws.String(param.Key),
 			Expires: aws.Time(param.Expiration),
-			Body:    io.TeeReader(file, pan.NewProgressWriter(req.LocalFile, stat.Size())),
+			Body:    io.TeeReader(tb.UploadReader(ctx, req), tb.NewProgressWriter(remoteName, req.Size)),
 		})
-		_ = file.Close()
-		if err == nil && req.SuccessDel {
-			err = os.Remove(req.LocalFile)
-			if err != nil {
-				logger.Errorf("delete fail %s,%v", req.LocalFile, err)
-			} else {
-				logger.Infof("delete success %s", req.LocalFile)
-			}
-		}
 		return err
 	}
 
 	return nil
 }
 
-func (tb *ThunderBrowser) DownloadPath(req pan.DownloadPathReq) error {
-	return tb.BaseDownloadPath(req, tb.List, tb.DownloadFile)
+func (tb *ThunderBrowser) DownloadPath(ctx context.Context, req pan.DownloadPathReq) error {
+	return tb.BaseDownloadPath(ctx, req, tb.List, tb.DownloadFile)
 }
-func (tb *ThunderBrowser) DownloadFile(req pan.DownloadFileReq) error {
-	return tb.BaseDownloadFile(req, tb.downloadClient, func(req pan.DownloadFileReq) (string, error) {
-		link, err := tb.getLink(req.RemoteFile.Id)
-		if err != nil {
-			return "", err
-		}
-		downloadLink := link.WebContentLink
-		if downloadLink == "" {
-			logger.Errorf("cant get link:%s,try media link", req.RemoteFile.Name)
-			for _, media := range link.Medias {
-				if media.Link.URL != "" {
-					downloadLink = media.Link.URL
-					break
-				}
+func (tb *ThunderBrowser) downloadUrl(ctx context.Context, req pan.DownloadFileReq) (string, error) {
+	link, err := tb.getLink(ctx, req.RemoteFile.Id)
+	if err != nil {
+		return "", err
+	}
+	req.RemoteFile.SetHash(pan.HashMd5, link.Md5Checksum)
+	req.RemoteFile.SetHash(pan.HashGcid, link.Hash)
+	downloadLink := link.WebContentLink
+	if downloadLink == "" {
+		tb.Logger().Errorf("cant get link:%s,try media link", req.RemoteFile.Name)
+		for _, media := range link.Medias {
+			if media.Link.URL != "" {
+				downloadLink = media.Link.URL
+				break
 			}
 		}
-		if downloadLink == "" {
-			logger.Debugf("cant get link:%s,%v", req.RemoteFile.Name, link)
-			return "", pan.OnlyMsg(fmt.Sprintf("cant get link:%s", req.RemoteFile.Name))
-		}
-		return downloadLink, nil
-	})
+	}
+	if downloadLink == "" {
+		tb.Logger().Debugf("cant get link:%s,%v", req.RemoteFile.Name, link)
+		return "", pan.OnlyMsg(fmt.Sprintf("cant get link:%s", req.RemoteFile.Name))
+	}
+	return downloadLink, nil
+}
+
+func (tb *ThunderBrowser) DownloadFile(ctx context.Context, req pan.DownloadFileReq) error {
+	ctx, cancel := tb.BindContext(ctx)
+	defer cancel()
+	return tb.BaseDownloadFile(ctx, req, tb.downloadClient, tb.downloadUrl)
 }
 
-func (tb *ThunderBrowser) OfflineDownload(req pan.OfflineDownloadReq) (*pan.Task, error) {
-	dir, err := tb.Mkdir(pan.MkdirReq{
+func (tb *ThunderBrowser) Open(ctx context.Context, req pan.OpenReq) (io.ReadCloser, error) {
+	ctx, cancel := tb.BindContext(ctx)
+	rc, err := tb.BaseOpen(ctx, req, tb.downloadClient, tb.downloadUrl)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	return pan.BindReadCloser(rc, cancel), nil
+}
+
+func (tb *ThunderBrowser) OfflineDownload(ctx context.Context, req pan.OfflineDownloadReq) (*pan.Task, error) {
+	dir, err := tb.Mkdir(ctx, pan.MkdirReq{
 		NewPath: req.RemotePath,
 	})
 	if err != nil {
@@ -521,7 +721,7 @@ func (tb *ThunderBrowser) OfflineDownload(req pan.OfflineDownloadReq) (*pan.Task
 	if remoteName == "" {
 		remoteName = req.Url
 	}
-	taskResp, e := tb.uploadTask(UploadTaskRequest{
+	taskResp, e := tb.uploadTask(ctx, UploadTaskRequest{
 		Kind:       FILE,
 		ParentId:   parentId,
 		Name:       remoteName,
@@ -547,8 +747,8 @@ func (tb *ThunderBrowser) OfflineDownload(req pan.OfflineDownloadReq) (*pan.Task
 	}, nil
 }
 
-func (tb *ThunderBrowser) TaskList(req pan.TaskListReq) ([]*pan.Task, error) {
-	tasks, err := tb.taskQuery(TaskQueryRequest{
+func (tb *ThunderBrowser) TaskList(ctx context.Context, req pan.TaskListReq) ([]*pan.Task, error) {
+	tasks, err := tb.taskQuery(ctx, TaskQueryRequest{
 		Space:  ThunderDriveSpace,
 		Types:  req.Types,
 		Ids:    req.Ids,
@@ -574,8 +774,8 @@ func (tb *ThunderBrowser) TaskList(req pan.TaskListReq) ([]*pan.Task, error) {
 	return panTasks, nil
 }
 
-func (tb *ThunderBrowser) ShareList(req pan.ShareListReq) ([]*pan.ShareData, error) {
-	shareList, err := tb.shareList(req.ShareIds...)
+func (tb *ThunderBrowser) ShareList(ctx context.Context, req pan.ShareListReq) ([]*pan.ShareData, error) {
+	shareList, err := tb.shareList(ctx, req.ShareIds...)
 	if err != nil {
 		return nil, err
 	}
@@ -590,8 +790,8 @@ func (tb *ThunderBrowser) ShareList(req pan.ShareListReq) ([]*pan.ShareData, err
 	}
 	return result, nil
 }
-func (tb *ThunderBrowser) NewShare(req pan.NewShareReq) (*pan.ShareData, error) {
-	share, err := tb.createShare(CreateShareReq{
+func (tb *ThunderBrowser) NewShare(ctx context.Context, req pan.NewShareReq) (*pan.ShareData, error) {
+	share, err := tb.createShare(ctx, CreateShareReq{
 		FileIds: req.Fids,
 		ShareTo: "copy",
 		Params: CreateShareParams{
@@ -611,10 +811,10 @@ func (tb *ThunderBrowser) NewShare(req pan.NewShareReq) (*pan.ShareData, error)
 		PassCode: share.PassCode,
 	}, nil
 }
-func (tb *ThunderBrowser) DeleteShare(req pan.DelShareReq) error {
+func (tb *ThunderBrowser) DeleteShare(ctx context.Context, req pan.DelShareReq) error {
 	shareIds := req.ShareIds
 	for _, shareId := range shareIds {
-		err := tb.deleteShare(shareId)
+		err := tb.deleteShare(ctx, shareId)
 		if err != nil {
 			return err
 		}
@@ -622,7 +822,7 @@ func (tb *ThunderBrowser) DeleteShare(req pan.DelShareReq) error {
 	return nil
 }
 
-func (tb *ThunderBrowser) ShareRestore(req pan.ShareRestoreReq) error {
+func (tb *ThunderBrowser) ShareRestore(ctx context.Context, req pan.ShareRestoreReq) error {
 	passCode := req.PassCode
 	shareId := req.ShareId
 	targetDir := req.TargetDir
@@ -645,13 +845,13 @@ func (tb *ThunderBrowser) ShareRestore(req pan.ShareRestoreReq) error {
 		// 从查询参数中提取分享ID和密码
 		passCode = queryParams.Get("pwd")
 	}
-	parentDir, err := tb.Mkdir(pan.MkdirReq{
+	parentDir, err := tb.Mkdir(ctx, pan.MkdirReq{
 		NewPath: targetDir,
 	})
 	if err != nil {
 		return err
 	}
-	share, err := tb.getShare(ShareDetailReq{
+	share, err := tb.getShare(ctx, ShareDetailReq{
 		ShareId:  shareId,
 		PassCode: passCode,
 	})
@@ -662,7 +862,7 @@ func (tb *ThunderBrowser) ShareRestore(req pan.ShareRestoreReq) error {
 	for _, file := range share.Files {
 		fileIds = append(fileIds, file.ID)
 	}
-	restore, err := tb.restore(RestoreReq{
+	restore, err := tb.restore(ctx, RestoreReq{
 		ParentId:        parentDir.Id,
 		ShareId:         shareId,
 		PassCodeToken:   share.PassCodeToken,
@@ -674,30 +874,34 @@ func (tb *ThunderBrowser) ShareRestore(req pan.ShareRestoreReq) error {
 		return err
 	}
 	for {
-		info, err := tb.taskInfo(restore.RestoreTaskId)
+		info, err := tb.taskInfo(ctx, restore.RestoreTaskId)
 		if err != nil {
 			return err
 		}
 		if info.Phase == PhaseTypeComplete {
 			break
 		}
-		time.Sleep(time.Second)
+		if err := internal.SleepContext(ctx, time.Second); err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
-func (tb *ThunderBrowser) DirectLink(req pan.DirectLinkReq) ([]*pan.DirectLink, error) {
-	return nil, pan.OnlyMsg("direct link not support")
+func (tb *ThunderBrowser) DirectLink(ctx context.Context, req pan.DirectLinkReq) ([]*pan.DirectLink, error) {
+	return nil, pan.NotSupported("direct link")
 }
 
 func init() {
-	pan.RegisterDriver(pan.ThunderBrowser, func() pan.Driver {
+	pan.RegisterDriver(pan.ThunderBrowser, func(env *pan.Env) pan.Driver {
 		return &ThunderBrowser{
 			PropertiesOperate: pan.PropertiesOperate[*ThunderBrowserProperties]{
 				DriverType: pan.ThunderBrowser,
+				Env:        env,
 			},
-			CacheOperate:  pan.CacheOperate{DriverType: pan.ThunderBrowser},
-			CommonOperate: pan.CommonOperate{},
+			CacheOperate:  pan.CacheOperate{DriverType: pan.ThunderBrowser, Env: env},
+			CommonOperate: pan.CommonOperate{Env: env},
+			BaseOperate:   pan.BaseOperate{Env: env},
 		}
 	})
 }
@@ -1,7 +1,47 @@
 package thunder_browser
 
+import "github.com/hefeiyu2025/pan-client/pan"
+
 // 定义异常编码和异常信息
 const (
 	// CodeObjectExist 对象已存在
 	CodeObjectExist = 40004
+	// CodeCaptchaInvalid 验证码token失效
+	CodeCaptchaInvalid = 9
+	// CodeInvalidToken 以下均为token失效
+	CodeInvalidToken       = 10
+	CodeUnauthenticated    = 16
+	CodeInvalidAccessToken = 4121
+	CodeAccessTokenExpired = 4122
 )
+
+var codeKinds = map[int64]error{
+	CodeObjectExist:        pan.ErrAlreadyExists,
+	CodeCaptchaInvalid:     pan.ErrCaptchaRequired,
+	CodeInvalidToken:       pan.ErrAuthExpired,
+	CodeUnauthenticated:    pan.ErrAuthExpired,
+	CodeInvalidAccessToken: pan.ErrAuthExpired,
+	CodeAccessTokenExpired: pan.ErrAuthExpired,
+}
+
+// 错误码没有文档，部分只能按返回的error判断
+var errorKinds = map[string]error{
+	"file_not_found":        pan.ErrNotFound,
+	"file_space_not_enough": pan.ErrQuotaExceeded,
+	"captcha_invalid":       pan.ErrCaptchaRequired,
+	"captcha_required":      pan.ErrCaptchaRequired,
+	"unauthenticated":       pan.ErrAuthExpired,
+	"too_many_requests":     pan.ErrRateLimited,
+}
+
+// errRespError 按迅雷的错误码、error和http状态码归类异常
+func errRespError(status int, errResp ErrResp) pan.DriverErrorInterface {
+	kind, ok := codeKinds[errResp.ErrorCode]
+	if !ok {
+		kind, ok = errorKinds[errResp.ErrorMsg]
+	}
+	if !ok {
+		kind = pan.StatusKind(status)
+	}
+	return pan.KindCodeMsg(kind, int(errResp.ErrorCode), errResp.ErrorMsg+errResp.ErrorDescription)
+}
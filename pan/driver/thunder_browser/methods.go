@@ -1,6 +1,8 @@
 package thunder_browser
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"github.com/hefeiyu2025/pan-client/internal"
 	"github.com/hefeiyu2025/pan-client/pan"
@@ -17,44 +19,44 @@ func funReturnBySuccess[T any](err error, response *req.Response, errorResult Er
 		return nil, pan.OnlyError(err)
 	}
 	if response.IsErrorState() {
-		return nil, pan.CodeMsg(int(errorResult.ErrorCode), errorResult.ErrorMsg)
+		return nil, errRespError(response.StatusCode, errorResult)
 	}
 	return &successResult, pan.NoError()
 }
 
 // refreshToken 刷新Token
-func (tb *ThunderBrowser) refreshToken(refreshToken string) (*TokenResp, pan.DriverErrorInterface) {
-	r := tb.sessionClient.R()
+func (tb *ThunderBrowser) refreshToken(ctx context.Context, s *loginSession) (*TokenResp, pan.DriverErrorInterface) {
+	r := s.client.R().SetContext(ctx)
 	var successResult TokenResp
 	var errorResult ErrResp
 	r.SetSuccessResult(&successResult)
 	r.SetErrorResult(&errorResult)
 	r.SetBody(&RefreshTokenRequest{
 		GrantType:    "refresh_token",
-		RefreshToken: refreshToken,
+		RefreshToken: s.properties.RefreshToken,
 		ClientID:     ClientID,
 		ClientSecret: ClientSecret,
 	})
 	response, err := r.Post(XLUSER_API_URL + "/auth/token")
 	tokenResp, e := funReturnBySuccess(err, response, errorResult, successResult)
 	if e == nil {
-		tb.setTokenResp(tokenResp)
+		s.setTokenResp(tokenResp)
 	}
 	return tokenResp, e
 }
 
 // 刷新验证码token
-func (tb *ThunderBrowser) refreshCaptchaToken(action string, metas map[string]string) pan.DriverErrorInterface {
-	r := tb.sessionClient.R()
+func (tb *ThunderBrowser) refreshCaptchaToken(ctx context.Context, s *loginSession, action string, metas map[string]string) pan.DriverErrorInterface {
+	r := s.client.R().SetContext(ctx)
 	var successResult CaptchaTokenResponse
 	var errorResult ErrResp
 	r.SetSuccessResult(&successResult)
 	r.SetErrorResult(&errorResult)
 	r.SetBody(&CaptchaTokenRequest{
 		Action:       action,
-		CaptchaToken: tb.Properties.CaptchaToken,
+		CaptchaToken: s.properties.CaptchaToken,
 		ClientID:     ClientID,
-		DeviceID:     tb.Properties.DeviceID,
+		DeviceID:     s.properties.DeviceID,
 		Meta:         metas,
 		RedirectUri:  "xlaccsdk01://xunlei.com/callback?state=harbor",
 	})
@@ -73,15 +75,14 @@ func (tb *ThunderBrowser) refreshCaptchaToken(action string, metas map[string]st
 		return pan.OnlyMsg("empty captchaToken")
 	}
 
-	tb.Properties.CaptchaToken = result.CaptchaToken
-	_ = tb.WriteConfig()
+	s.properties.CaptchaToken = result.CaptchaToken
 	return nil
 }
 
 // GetCaptchaSign 获取验证码签名
-func (tb *ThunderBrowser) getCaptchaSign() (timestamp, sign string) {
+func (tb *ThunderBrowser) getCaptchaSign(deviceId string) (timestamp, sign string) {
 	timestamp = fmt.Sprint(time.Now().UnixMilli())
-	str := fmt.Sprint(ClientID, ClientVersion, PackageName, tb.Properties.DeviceID, timestamp)
+	str := fmt.Sprint(ClientID, ClientVersion, PackageName, deviceId, timestamp)
 	for _, algorithm := range Algorithms {
 		str = internal.Md5HashStr(str + algorithm)
 	}
@@ -90,18 +91,19 @@ func (tb *ThunderBrowser) getCaptchaSign() (timestamp, sign string) {
 }
 
 // refreshCaptchaTokenAtLogin 刷新验证码token(登录后)
-func (tb *ThunderBrowser) refreshCaptchaTokenAtLogin(action, userID string) pan.DriverErrorInterface {
+func (tb *ThunderBrowser) refreshCaptchaTokenAtLogin(ctx context.Context, s *loginSession, action string) pan.DriverErrorInterface {
 	metas := map[string]string{
 		"client_version": ClientVersion,
 		"package_name":   PackageName,
-		"user_id":        userID,
+		"user_id":        s.properties.UserID,
 	}
-	metas["timestamp"], metas["captcha_sign"] = tb.getCaptchaSign()
-	return tb.refreshCaptchaToken(action, metas)
+	metas["timestamp"], metas["captcha_sign"] = tb.getCaptchaSign(s.properties.DeviceID)
+	return tb.refreshCaptchaToken(ctx, s, action, metas)
 }
 
 // refreshCaptchaTokenInLogin 刷新验证码token(登录时)
-func (tb *ThunderBrowser) refreshCaptchaTokenInLogin(action, username string) pan.DriverErrorInterface {
+func (tb *ThunderBrowser) refreshCaptchaTokenInLogin(ctx context.Context, s *loginSession, action string) pan.DriverErrorInterface {
+	username := s.properties.Username
 	metas := make(map[string]string)
 	if ok, _ := regexp.MatchString(`\w+([-+.]\w+)*@\w+([-.]\w+)*\.\w+([-.]\w+)*`, username); ok {
 		metas["email"] = username
@@ -110,7 +112,7 @@ func (tb *ThunderBrowser) refreshCaptchaTokenInLogin(action, username string) pa
 	} else {
 		metas["username"] = username
 	}
-	return tb.refreshCaptchaToken(action, metas)
+	return tb.refreshCaptchaToken(ctx, s, action, metas)
 }
 
 func GetAction(method string, url string) string {
@@ -118,53 +120,64 @@ func GetAction(method string, url string) string {
 	return method + ":" + urlpath
 }
 
-func (tb *ThunderBrowser) setTokenResp(tokenResp *TokenResp) {
-	tb.Properties.TokenType = tokenResp.TokenType
-	tb.Properties.AccessToken = tokenResp.AccessToken
-	tb.Properties.RefreshToken = tokenResp.RefreshToken
-	tb.Properties.ExpiresIn = tokenResp.ExpiresIn
-	tb.Properties.Sub = tokenResp.Sub
-	tb.Properties.UserID = tokenResp.UserID
+func (s *loginSession) setTokenResp(tokenResp *TokenResp) {
+	s.properties.TokenType = tokenResp.TokenType
+	s.properties.AccessToken = tokenResp.AccessToken
+	s.properties.RefreshToken = tokenResp.RefreshToken
+	s.properties.ExpiresIn = tokenResp.ExpiresIn
+	s.properties.Sub = tokenResp.Sub
+	s.properties.UserID = tokenResp.UserID
 }
 
-func (tb *ThunderBrowser) login(username, password string) (*TokenResp, pan.DriverErrorInterface) {
+// authorize 按session的配置设置请求的认证头
+func (s *loginSession) authorize(r *req.Request) *req.Request {
+	return r.SetHeaders(map[string]string{
+		"Authorization":         fmt.Sprint(s.properties.TokenType, " ", s.properties.AccessToken),
+		"X-Captcha-Token":       s.properties.CaptchaToken,
+		"X-Space-Authorization": "",
+	})
+}
+
+func (tb *ThunderBrowser) login(ctx context.Context, s *loginSession) (*TokenResp, pan.DriverErrorInterface) {
 	url := XLUSER_API_URL + "/auth/signin"
-	err := tb.refreshCaptchaTokenInLogin(GetAction(http.MethodPost, url), username)
+	err := tb.refreshCaptchaTokenInLogin(ctx, s, GetAction(http.MethodPost, url))
 	if err != nil {
 		return nil, err
 	}
-	r := tb.sessionClient.R()
+	r := s.client.R().SetContext(ctx)
 	var successResult TokenResp
 	var errorResult ErrResp
 	r.SetSuccessResult(&successResult)
 	r.SetErrorResult(&errorResult)
 	r.SetBody(&LogInRequest{
-		CaptchaToken: tb.Properties.CaptchaToken,
-		Username:     username,
-		Password:     password,
+		CaptchaToken: s.properties.CaptchaToken,
+		Username:     s.properties.Username,
+		Password:     s.properties.Password,
 		ClientID:     ClientID,
 		ClientSecret: ClientSecret,
 	})
 	response, reqErr := r.Post(url)
 	tokenResp, e := funReturnBySuccess(reqErr, response, errorResult, successResult)
 	if e == nil {
-		tb.setTokenResp(tokenResp)
+		s.setTokenResp(tokenResp)
 	}
 	return tokenResp, e
 }
 
-func (tb *ThunderBrowser) userMe() (*UserMeResp, pan.DriverErrorInterface) {
+// userMe 用session取用户信息，token失效时不自动刷新
+func (tb *ThunderBrowser) userMe(ctx context.Context, s *loginSession) (*UserMeResp, pan.DriverErrorInterface) {
+	r := s.authorize(s.client.R().SetContext(ctx))
 	var successResult UserMeResp
-	_, err := tb.request(func(r *req.Request) (*req.Response, error) {
-		r.SetSuccessResult(&successResult)
-		return r.Get(XLUSER_API_URL + "/user/me")
-	})
-	return &successResult, err
+	var errorResult ErrResp
+	r.SetSuccessResult(&successResult)
+	r.SetErrorResult(&errorResult)
+	response, err := r.Get(XLUSER_API_URL + "/user/me")
+	return funReturnBySuccess(err, response, errorResult, successResult)
 }
 
-func (tb *ThunderBrowser) rename(fileId string, newName string) (*Files, pan.DriverErrorInterface) {
+func (tb *ThunderBrowser) rename(ctx context.Context, fileId string, newName string) (*Files, pan.DriverErrorInterface) {
 	var newFile Files
-	_, err := tb.request(func(r *req.Request) (*req.Response, error) {
+	_, err := tb.request(ctx, func(r *req.Request) (*req.Response, error) {
 		r.SetPathParam("fileID", fileId)
 		r.SetBody(&pan.Json{"name": newName})
 		r.SetQueryParams(map[string]string{
@@ -176,13 +189,13 @@ func (tb *ThunderBrowser) rename(fileId string, newName string) (*Files, pan.Dri
 	return &newFile, err
 }
 
-func (tb *ThunderBrowser) makeDir(dirName, dirId string) (*MkdirResponse, pan.DriverErrorInterface) {
+func (tb *ThunderBrowser) makeDir(ctx context.Context, dirName, dirId string) (*MkdirResponse, pan.DriverErrorInterface) {
 	parentId := dirId
 	if dirId == "0" {
 		parentId = ""
 	}
 	var successResult MkdirResponse
-	_, err := tb.request(func(r *req.Request) (*req.Response, error) {
+	_, err := tb.request(ctx, func(r *req.Request) (*req.Response, error) {
 		r.SetSuccessResult(&successResult)
 		r.SetBody(pan.Json{
 			"kind":      FOLDER,
@@ -195,8 +208,8 @@ func (tb *ThunderBrowser) makeDir(dirName, dirId string) (*MkdirResponse, pan.Dr
 	return &successResult, err
 }
 
-func (tb *ThunderBrowser) move(srcIds []string, destId string) pan.DriverErrorInterface {
-	_, err := tb.request(func(r *req.Request) (*req.Response, error) {
+func (tb *ThunderBrowser) move(ctx context.Context, srcIds []string, destId string) pan.DriverErrorInterface {
+	_, err := tb.request(ctx, func(r *req.Request) (*req.Response, error) {
 		r.SetQueryParams(map[string]string{
 			"_from": ThunderDriveSpace,
 		})
@@ -210,8 +223,23 @@ func (tb *ThunderBrowser) move(srcIds []string, destId string) pan.DriverErrorIn
 	return err
 }
 
-func (tb *ThunderBrowser) remove(ids []string) pan.DriverErrorInterface {
-	_, err := tb.request(func(r *req.Request) (*req.Response, error) {
+func (tb *ThunderBrowser) copy(ctx context.Context, srcIds []string, destId string) pan.DriverErrorInterface {
+	_, err := tb.request(ctx, func(r *req.Request) (*req.Response, error) {
+		r.SetQueryParams(map[string]string{
+			"_from": ThunderDriveSpace,
+		})
+		r.SetBody(pan.Json{
+			"to":    pan.Json{"parent_id": destId, "space": ThunderDriveSpace},
+			"space": ThunderDriveSpace,
+			"ids":   srcIds,
+		})
+		return r.Post(API_URL + "/files:batchCopy")
+	})
+	return err
+}
+
+func (tb *ThunderBrowser) remove(ctx context.Context, ids []string) pan.DriverErrorInterface {
+	_, err := tb.request(ctx, func(r *req.Request) (*req.Response, error) {
 		r.SetBody(pan.Json{
 			"ids":   ids,
 			"space": ThunderDriveSpace,
@@ -221,9 +249,9 @@ func (tb *ThunderBrowser) remove(ids []string) pan.DriverErrorInterface {
 	return err
 }
 
-func (tb *ThunderBrowser) getLink(id string) (*Files, pan.DriverErrorInterface) {
+func (tb *ThunderBrowser) getLink(ctx context.Context, id string) (*Files, pan.DriverErrorInterface) {
 	var lFile Files
-	_, err := tb.request(func(r *req.Request) (*req.Response, error) {
+	_, err := tb.request(ctx, func(r *req.Request) (*req.Response, error) {
 		r.SetPathParam("fileID", id)
 		r.SetQueryParams(map[string]string{
 			"_magic":         "2021",
@@ -240,23 +268,102 @@ func (tb *ThunderBrowser) getLink(id string) (*Files, pan.DriverErrorInterface)
 	return &lFile, err
 }
 
-func (tb *ThunderBrowser) getFiles(dirId string) ([]*Files, pan.DriverErrorInterface) {
+func (tb *ThunderBrowser) getFile(ctx context.Context, id string) (*Files, pan.DriverErrorInterface) {
+	var file Files
+	_, err := tb.request(ctx, func(r *req.Request) (*req.Response, error) {
+		r.SetPathParam("fileID", id)
+		r.SetQueryParams(map[string]string{
+			"_magic": "2021",
+			"space":  ThunderDriveSpace,
+		})
+		r.SetSuccessResult(&file)
+		return r.Get(API_URL + "/files/{fileID}")
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &file, err
+}
+
+func (tb *ThunderBrowser) getFiles(ctx context.Context, dirId string) ([]*Files, pan.DriverErrorInterface) {
+	files := make([]*Files, 0)
+	var pageToken string
+	for {
+		fileList, err := tb.getFilesPage(ctx, dirId, pageToken, 0)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, fileList.Files...)
+		if fileList.NextPageToken == "" {
+			break
+		}
+		pageToken = fileList.NextPageToken
+	}
+	return files, nil
+}
+
+// getFilesPage 获取目录的某一页，limit小于等于0时使用服务端默认值
+func (tb *ThunderBrowser) getFilesPage(ctx context.Context, dirId, pageToken string, limit int) (*FileList, pan.DriverErrorInterface) {
 	parentId := dirId
 	if dirId == "0" {
 		parentId = ""
 	}
+	var successResult FileList
+	_, err := tb.request(ctx, func(r *req.Request) (*req.Response, error) {
+		r.SetSuccessResult(&successResult)
+		r.SetQueryParams(map[string]string{
+			"parent_id":      parentId,
+			"page_token":     pageToken,
+			"space":          ThunderDriveSpace,
+			"filters":        `{"trashed":{"eq":false}}`,
+			"with":           "url",
+			"with_audit":     "true",
+			"thumbnail_size": "SIZE_LARGE",
+		})
+		if limit > 0 {
+			r.SetQueryParam("limit", strconv.Itoa(limit))
+		}
+		return r.Get(API_URL + "/files")
+	})
+	if err != nil {
+		return nil, err
+	}
+	files := make([]*Files, 0, len(successResult.Files))
+	for _, file := range successResult.Files {
+		// 解决 "迅雷云盘" 重复出现问题————迅雷后端发送错误
+		if file.FolderType == ThunderDriveFolderType && file.ID == "" && file.Space == "" && dirId != "" {
+			continue
+		}
+		files = append(files, file)
+	}
+	successResult.Files = files
+	return &successResult, nil
+}
+
+// searchFiles 按文件名搜索整个网盘，kind为空时不限制文件或目录
+func (tb *ThunderBrowser) searchFiles(ctx context.Context, keyword, kind string) ([]*Files, pan.DriverErrorInterface) {
+	filters := pan.Json{
+		"name":    pan.Json{"include": keyword},
+		"trashed": pan.Json{"eq": false},
+	}
+	if kind != "" {
+		filters["kind"] = pan.Json{"eq": kind}
+	}
+	filtersJson, e := json.Marshal(filters)
+	if e != nil {
+		return nil, pan.OnlyError(e)
+	}
 	files := make([]*Files, 0)
-	var pageToken string
+	pageToken := ""
 	for {
 		var successResult FileList
-		_, err := tb.request(func(r *req.Request) (*req.Response, error) {
+		_, err := tb.request(ctx, func(r *req.Request) (*req.Response, error) {
 			r.SetSuccessResult(&successResult)
 			r.SetQueryParams(map[string]string{
-				"parent_id":      parentId,
+				"parent_id":      "*",
 				"page_token":     pageToken,
 				"space":          ThunderDriveSpace,
-				"filters":        `{"trashed":{"eq":false}}`,
-				"with":           "url",
+				"filters":        string(filtersJson),
 				"with_audit":     "true",
 				"thumbnail_size": "SIZE_LARGE",
 			})
@@ -265,15 +372,7 @@ func (tb *ThunderBrowser) getFiles(dirId string) ([]*Files, pan.DriverErrorInter
 		if err != nil {
 			return nil, err
 		}
-
-		for _, file := range successResult.Files {
-			// 解决 "迅雷云盘" 重复出现问题————迅雷后端发送错误
-			if file.FolderType == ThunderDriveFolderType && file.ID == "" && file.Space == "" && dirId != "" {
-				continue
-			}
-			files = append(files, file)
-		}
-
+		files = append(files, successResult.Files...)
 		if successResult.NextPageToken == "" {
 			break
 		}
@@ -282,9 +381,9 @@ func (tb *ThunderBrowser) getFiles(dirId string) ([]*Files, pan.DriverErrorInter
 	return files, nil
 }
 
-func (tb *ThunderBrowser) uploadTask(body UploadTaskRequest) (*UploadTaskResponse, pan.DriverErrorInterface) {
+func (tb *ThunderBrowser) uploadTask(ctx context.Context, body UploadTaskRequest) (*UploadTaskResponse, pan.DriverErrorInterface) {
 	var successResult UploadTaskResponse
-	_, err := tb.request(func(r *req.Request) (*req.Response, error) {
+	_, err := tb.request(ctx, func(r *req.Request) (*req.Response, error) {
 		r.SetSuccessResult(&successResult)
 		r.SetBody(body)
 		return r.Post(API_URL + "/files")
@@ -295,9 +394,9 @@ func (tb *ThunderBrowser) uploadTask(body UploadTaskRequest) (*UploadTaskRespons
 	return &successResult, err
 }
 
-func (tb *ThunderBrowser) taskInfo(taskId string) (*Task, pan.DriverErrorInterface) {
+func (tb *ThunderBrowser) taskInfo(ctx context.Context, taskId string) (*Task, pan.DriverErrorInterface) {
 	var successResult Task
-	_, err := tb.request(func(r *req.Request) (*req.Response, error) {
+	_, err := tb.request(ctx, func(r *req.Request) (*req.Response, error) {
 		r.SetSuccessResult(&successResult)
 		r.SetPathParams(map[string]string{
 			"taskId": taskId,
@@ -307,7 +406,7 @@ func (tb *ThunderBrowser) taskInfo(taskId string) (*Task, pan.DriverErrorInterfa
 	return &successResult, err
 }
 
-func (tb *ThunderBrowser) taskQuery(taskQueryReq TaskQueryRequest) ([]*Task, pan.DriverErrorInterface) {
+func (tb *ThunderBrowser) taskQuery(ctx context.Context, taskQueryReq TaskQueryRequest) ([]*Task, pan.DriverErrorInterface) {
 
 	tasks := make([]*Task, 0)
 	var pageToken string
@@ -325,7 +424,7 @@ func (tb *ThunderBrowser) taskQuery(taskQueryReq TaskQueryRequest) ([]*Task, pan
 	filters += `}`
 	for {
 		var successResult TaskQueryResponse
-		_, err := tb.request(func(r *req.Request) (*req.Response, error) {
+		_, err := tb.request(ctx, func(r *req.Request) (*req.Response, error) {
 			r.SetSuccessResult(&successResult)
 			r.SetQueryParams(map[string]string{
 				"page_token":     pageToken,
@@ -353,7 +452,7 @@ func (tb *ThunderBrowser) taskQuery(taskQueryReq TaskQueryRequest) ([]*Task, pan
 	return tasks, nil
 }
 
-func (tb *ThunderBrowser) shareList(shareIds ...string) ([]*ShareInfo, pan.DriverErrorInterface) {
+func (tb *ThunderBrowser) shareList(ctx context.Context, shareIds ...string) ([]*ShareInfo, pan.DriverErrorInterface) {
 	var pageToken string
 	filters := `{`
 	if len(shareIds) > 0 {
@@ -364,7 +463,7 @@ func (tb *ThunderBrowser) shareList(shareIds ...string) ([]*ShareInfo, pan.Drive
 	shareList := make([]*ShareInfo, 0)
 	for {
 		var successResult ShareListResp
-		_, err := tb.request(func(r *req.Request) (*req.Response, error) {
+		_, err := tb.request(ctx, func(r *req.Request) (*req.Response, error) {
 			r.SetSuccessResult(&successResult)
 			r.SetQueryParams(map[string]string{
 				"page_token":     pageToken,
@@ -392,9 +491,9 @@ func (tb *ThunderBrowser) shareList(shareIds ...string) ([]*ShareInfo, pan.Drive
 	return shareList, nil
 }
 
-func (tb *ThunderBrowser) createShare(createShareReq CreateShareReq) (*CreateShareResp, pan.DriverErrorInterface) {
+func (tb *ThunderBrowser) createShare(ctx context.Context, createShareReq CreateShareReq) (*CreateShareResp, pan.DriverErrorInterface) {
 	var successResult CreateShareResp
-	_, err := tb.request(func(r *req.Request) (*req.Response, error) {
+	_, err := tb.request(ctx, func(r *req.Request) (*req.Response, error) {
 		r.SetSuccessResult(&successResult)
 		r.SetBody(createShareReq)
 		return r.Post(API_URL + "/share")
@@ -405,8 +504,8 @@ func (tb *ThunderBrowser) createShare(createShareReq CreateShareReq) (*CreateSha
 	return &successResult, nil
 }
 
-func (tb *ThunderBrowser) deleteShare(shareId string) pan.DriverErrorInterface {
-	_, err := tb.request(func(r *req.Request) (*req.Response, error) {
+func (tb *ThunderBrowser) deleteShare(ctx context.Context, shareId string) pan.DriverErrorInterface {
+	_, err := tb.request(ctx, func(r *req.Request) (*req.Response, error) {
 		r.SetBody(map[string]string{
 			"share_id": shareId,
 			"space":    ThunderDriveSpace,
@@ -416,9 +515,9 @@ func (tb *ThunderBrowser) deleteShare(shareId string) pan.DriverErrorInterface {
 	return err
 }
 
-func (tb *ThunderBrowser) getShare(shareDetailReq ShareDetailReq) (*ShareDetailResp, pan.DriverErrorInterface) {
+func (tb *ThunderBrowser) getShare(ctx context.Context, shareDetailReq ShareDetailReq) (*ShareDetailResp, pan.DriverErrorInterface) {
 	var successResult ShareDetailResp
-	_, err := tb.request(func(r *req.Request) (*req.Response, error) {
+	_, err := tb.request(ctx, func(r *req.Request) (*req.Response, error) {
 		r.SetSuccessResult(&successResult)
 		r.SetQueryParams(map[string]string{
 			"share_id":  shareDetailReq.ShareId,
@@ -431,9 +530,9 @@ func (tb *ThunderBrowser) getShare(shareDetailReq ShareDetailReq) (*ShareDetailR
 	return &successResult, err
 }
 
-func (tb *ThunderBrowser) getShareDetail(shareDetailReq ShareDetailReq) (*ShareDetailResp, pan.DriverErrorInterface) {
+func (tb *ThunderBrowser) getShareDetail(ctx context.Context, shareDetailReq ShareDetailReq) (*ShareDetailResp, pan.DriverErrorInterface) {
 	var successResult ShareDetailResp
-	_, err := tb.request(func(r *req.Request) (*req.Response, error) {
+	_, err := tb.request(ctx, func(r *req.Request) (*req.Response, error) {
 		r.SetSuccessResult(&successResult)
 		r.SetQueryParams(map[string]string{
 			"share_id":        shareDetailReq.ShareId,
@@ -447,9 +546,9 @@ func (tb *ThunderBrowser) getShareDetail(shareDetailReq ShareDetailReq) (*ShareD
 	return &successResult, err
 }
 
-func (tb *ThunderBrowser) about() (*AboutResp, pan.DriverErrorInterface) {
+func (tb *ThunderBrowser) about(ctx context.Context) (*AboutResp, pan.DriverErrorInterface) {
 	var successResult AboutResp
-	_, err := tb.request(func(r *req.Request) (*req.Response, error) {
+	_, err := tb.request(ctx, func(r *req.Request) (*req.Response, error) {
 		r.SetSuccessResult(&successResult)
 		r.SetQueryParams(map[string]string{
 			"with_quotas": QuotaCreateOfflineTaskLimit,
@@ -460,9 +559,9 @@ func (tb *ThunderBrowser) about() (*AboutResp, pan.DriverErrorInterface) {
 	return &successResult, err
 }
 
-func (tb *ThunderBrowser) restore(restoreReq RestoreReq) (*RestoreResp, pan.DriverErrorInterface) {
+func (tb *ThunderBrowser) restore(ctx context.Context, restoreReq RestoreReq) (*RestoreResp, pan.DriverErrorInterface) {
 	var successResult RestoreResp
-	_, err := tb.request(func(r *req.Request) (*req.Response, error) {
+	_, err := tb.request(ctx, func(r *req.Request) (*req.Response, error) {
 		r.SetSuccessResult(&successResult)
 		r.SetBody(restoreReq)
 		return r.Post(API_URL + "/share/restore")
@@ -470,13 +569,9 @@ func (tb *ThunderBrowser) restore(restoreReq RestoreReq) (*RestoreResp, pan.Driv
 	return &successResult, err
 }
 
-func (tb *ThunderBrowser) request(request func(r *req.Request) (*req.Response, error)) (*req.Response, pan.DriverErrorInterface) {
-	r := tb.sessionClient.R()
-	r.SetHeaders(map[string]string{
-		"Authorization":         fmt.Sprint(tb.Properties.TokenType, " ", tb.Properties.AccessToken),
-		"X-Captcha-Token":       tb.Properties.CaptchaToken,
-		"X-Space-Authorization": "",
-	})
+func (tb *ThunderBrowser) request(ctx context.Context, request func(r *req.Request) (*req.Response, error)) (*req.Response, pan.DriverErrorInterface) {
+	current := tb.currentSession()
+	r := current.authorize(current.client.R().SetContext(ctx))
 	var errResp ErrResp
 	r.SetErrorResult(&errResp)
 	data, err := request(r)
@@ -487,19 +582,19 @@ func (tb *ThunderBrowser) request(request func(r *req.Request) (*req.Response, e
 	switch errResp.ErrorCode {
 	case 0:
 		return data, nil
-	case 4122, 4121, 10, 16:
-		_, err = tb.refreshToken(tb.Properties.RefreshToken)
+	case CodeAccessTokenExpired, CodeInvalidAccessToken, CodeInvalidToken, CodeUnauthenticated:
+		// 在副本上重新登录，成功后才替换
+		s := tb.currentSession()
+		_, err = tb.refreshToken(ctx, s)
+		if err != nil && s.properties.Username != "" && s.properties.Password != "" {
+			_, err = tb.login(ctx, s)
+		}
 		if err == nil {
+			tb.commitSession(s)
 			break
 		}
-		if tb.Properties.Username != "" && tb.Properties.Password != "" {
-			_, err = tb.login(tb.Properties.Username, tb.Properties.Password)
-			if err == nil {
-				break
-			}
-		}
-		return nil, pan.OnlyError(err)
-	case 9:
+		return nil, pan.KindCodeMsgErrorData(pan.ErrAuthExpired, int(errResp.ErrorCode), errResp.ErrorMsg+errResp.ErrorDescription, err, nil)
+	case CodeCaptchaInvalid:
 		// space_token 获取失败
 		//if errResp.ErrorMsg == "space_token_invalid" {
 		//	if token, err := xc.GetSafeAccessToken(xc.Token); err != nil {
@@ -511,14 +606,17 @@ func (tb *ThunderBrowser) request(request func(r *req.Request) (*req.Response, e
 		//}
 		if errResp.ErrorMsg == "captcha_invalid" {
 			// 验证码token过期
-			if e := tb.refreshCaptchaTokenAtLogin(GetAction(r.Method, r.RawURL), tb.Properties.UserID); e != nil {
-				return nil, pan.OnlyError(e)
+			s := tb.currentSession()
+			if e := tb.refreshCaptchaTokenAtLogin(ctx, s, GetAction(r.Method, r.RawURL)); e != nil {
+				return nil, pan.KindCodeMsgErrorData(pan.ErrCaptchaRequired, int(errResp.ErrorCode), errResp.ErrorMsg, e, nil)
 			}
+			tb.commitSession(s)
+			_ = tb.WriteConfig()
 			break
 		}
-		return nil, pan.CodeMsg(int(errResp.ErrorCode), errResp.ErrorMsg+errResp.ErrorDescription)
+		return nil, errRespError(data.StatusCode, errResp)
 	default:
-		return nil, pan.CodeMsg(int(errResp.ErrorCode), errResp.ErrorMsg+errResp.ErrorDescription)
+		return nil, errRespError(data.StatusCode, errResp)
 	}
-	return tb.request(request)
+	return tb.request(ctx, request)
 }
@@ -151,7 +151,7 @@ type Files struct {
 	Size string `json:"size"`
 	//Revision       string    `json:"revision"`
 	//FileExtension  string    `json:"file_extension"`
-	//MimeType       string    `json:"mime_type"`
+	MimeType string `json:"mime_type"`
 	//Starred        bool      `json:"starred"`
 	WebContentLink string     `json:"web_content_link"`
 	CreatedTime    CustomTime `json:"created_time"`
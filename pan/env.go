@@ -0,0 +1,28 @@
+package pan
+
+import (
+	"encoding/gob"
+	"github.com/hefeiyu2025/pan-client/internal"
+	"sync"
+)
+
+func init() {
+	gob.Register([]*PanObj{})
+	gob.Register(&PanObj{})
+}
+
+// Env 驱动实例的运行环境，Registry为实例所在的注册中心
+type Env struct {
+	*internal.Env
+	Registry *Registry
+	// 同类型的账号可能写在同一个key下，读写配置时互斥
+	accountMu sync.Mutex
+}
+
+// envOf 驱动未设置环境时使用默认注册中心的环境
+func envOf(env *Env) *Env {
+	if env == nil {
+		return defaultRegistry.Env()
+	}
+	return env
+}
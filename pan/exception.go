@@ -3,6 +3,8 @@ package pan
 import (
 	"errors"
 	"fmt"
+	"net/http"
+	"strings"
 )
 
 const (
@@ -10,16 +12,41 @@ const (
 	UNKNOWN int = 9999
 )
 
+// 各驱动共用的异常分类，可通过errors.Is判断，原始的Code和Msg仍保留在DriverError中
+var (
+	// ErrNotFound 对象不存在
+	ErrNotFound = errors.New("not found")
+	// ErrAlreadyExists 对象已存在
+	ErrAlreadyExists = errors.New("already exists")
+	// ErrAuthExpired 登录失效，需要重新登录或更新凭证
+	ErrAuthExpired = errors.New("auth expired")
+	// ErrQuotaExceeded 空间不足或超出限额
+	ErrQuotaExceeded = errors.New("quota exceeded")
+	// ErrRateLimited 请求过于频繁
+	ErrRateLimited = errors.New("rate limited")
+	// ErrCaptchaRequired 需要验证码
+	ErrCaptchaRequired = errors.New("captcha required")
+	// ErrNotSupported 驱动不支持该功能，支持的功能见Capabilities
+	ErrNotSupported = errors.New("not supported")
+	// ErrInvalidConfig 账号配置校验不通过，具体的字段见ValidationError
+	ErrInvalidConfig = errors.New("invalid config")
+	// ErrChecksumMismatch 下载的文件与网盘返回的hash不一致，具体见ChecksumError
+	ErrChecksumMismatch = errors.New("checksum mismatch")
+)
+
 type DriverErrorInterface interface {
 	GetCode() int
 	GetMsg() string
 	GetErr() error
 	GetData() interface{}
+	// GetKind 异常分类，即上面定义的Err*，未分类时为nil
+	GetKind() error
 	Error() string
 }
 
 // DriverError 定义全局的基础异常
 type DriverError struct {
+	Kind error
 	Code int
 	Msg  string
 	Err  error
@@ -42,19 +69,33 @@ func (e *DriverError) GetData() interface{} {
 	return e.Data
 }
 
+func (e *DriverError) GetKind() error {
+	return e.Kind
+}
+
+func (e *DriverError) Unwrap() error {
+	return e.Err
+}
+
+// Is 让errors.Is可以按Kind判断
+func (e *DriverError) Is(target error) bool {
+	return e.Kind != nil && e.Kind == target
+}
+
 func (e *DriverError) Error() string {
-	m := make(map[string]any)
-	m["code"] = e.Code
-	m["msg"] = e.Msg
-	m["err"] = e.Err
-	m["data"] = e.Data
 	errorStr := ""
-	for key, value := range m {
-		if value != nil {
-			errorStr = e.appendKeyValue(errorStr, key, value)
-		}
+	if e.Kind != nil {
+		errorStr = e.appendKeyValue(errorStr, "kind", e.Kind)
+	}
+	errorStr = e.appendKeyValue(errorStr, "code", e.Code)
+	errorStr = e.appendKeyValue(errorStr, "msg", e.Msg)
+	if e.Err != nil {
+		errorStr = e.appendKeyValue(errorStr, "err", e.Err)
+	}
+	if e.Data != nil {
+		errorStr = e.appendKeyValue(errorStr, "data", e.Data)
 	}
-	return errorStr
+	return strings.TrimRight(errorStr, " ")
 }
 
 func (e *DriverError) appendKeyValue(errorStr, key string, value interface{}) string {
@@ -75,6 +116,46 @@ func OnlyError(error error) DriverErrorInterface {
 	return MsgError("", error)
 }
 
+// NotFound 对象不存在的异常，errors.Is(err, ErrNotFound)为true
+func NotFound(path string) DriverErrorInterface {
+	return KindMsg(ErrNotFound, path+" not found")
+}
+
+// NotSupported 不支持的功能，errors.Is(err, ErrNotSupported)为true
+func NotSupported(feature string) DriverErrorInterface {
+	return KindMsg(ErrNotSupported, feature+" not support")
+}
+
+// InvalidConfig 配置校验不通过，errors.Is(err, ErrInvalidConfig)为true，errors.As可以取出*ValidationError
+func InvalidConfig(err error) DriverErrorInterface {
+	return KindCodeMsgErrorData(ErrInvalidConfig, UNKNOWN, "", err, nil)
+}
+
+func KindMsg(kind error, msg string) DriverErrorInterface {
+	return KindCodeMsg(kind, UNKNOWN, msg)
+}
+
+func KindCodeMsg(kind error, code int, msg string) DriverErrorInterface {
+	return KindCodeMsgErrorData(kind, code, msg, nil, nil)
+}
+
+// StatusKind 按http状态码归类异常
+func StatusKind(status int) error {
+	switch status {
+	case http.StatusUnauthorized:
+		return ErrAuthExpired
+	case http.StatusNotFound, http.StatusGone:
+		return ErrNotFound
+	case http.StatusConflict:
+		return ErrAlreadyExists
+	case http.StatusTooManyRequests:
+		return ErrRateLimited
+	case http.StatusInsufficientStorage, http.StatusRequestEntityTooLarge:
+		return ErrQuotaExceeded
+	}
+	return nil
+}
+
 func OnlyCode(code int) DriverErrorInterface {
 	return CodeMsg(code, "")
 }
@@ -100,16 +181,29 @@ func CodeMsg(code int, msg string) DriverErrorInterface {
 }
 
 func CodeMsgErrorData(code int, msg string, error error, data interface{}) DriverErrorInterface {
+	return KindCodeMsgErrorData(nil, code, msg, error, data)
+}
+
+// KindCodeMsgErrorData 包装DriverError时保留其分类和原始的Code
+func KindCodeMsgErrorData(kind error, code int, msg string, error error, data interface{}) DriverErrorInterface {
 	var err *DriverError
 	if errors.As(error, &err) {
+		if kind == nil {
+			kind = err.Kind
+		}
+		if code == UNKNOWN {
+			code = err.Code
+		}
 		return &DriverError{
+			Kind: kind,
 			Code: code,
-			Msg:  err.Msg + " " + msg,
+			Msg:  strings.TrimSpace(err.Msg + " " + msg),
 			Err:  err.Err,
 			Data: data,
 		}
 	}
 	return &DriverError{
+		Kind: kind,
 		Code: code,
 		Msg:  msg,
 		Err:  error,
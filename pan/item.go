@@ -1,6 +1,10 @@
 package pan
 
-import "time"
+import (
+	"github.com/hefeiyu2025/pan-client/internal"
+	"io"
+	"time"
+)
 
 type Json map[string]interface{}
 
@@ -16,6 +20,28 @@ type ListReq struct {
 	Dir    *PanObj `json:"dir,omitempty"`
 }
 
+// ListPageReq 分页获取目录，PageToken为空表示第一页，PageSize小于等于0时由驱动决定
+type ListPageReq struct {
+	Dir       *PanObj `json:"dir,omitempty"`
+	PageSize  int     `json:"pageSize,omitempty"`
+	PageToken string  `json:"pageToken,omitempty"`
+}
+
+type ListPageResp struct {
+	Objs []*PanObj `json:"objs,omitempty"`
+	// 为空表示已经是最后一页
+	NextPageToken string `json:"nextPageToken,omitempty"`
+}
+
+// SearchReq 搜索，Dir为空时搜索整个网盘
+type SearchReq struct {
+	Keyword string     `json:"keyword,omitempty"`
+	Type    SearchType `json:"type,omitempty"`
+	// 非空时只返回这些后缀的文件
+	Extensions []string `json:"extensions,omitempty"`
+	Dir        *PanObj  `json:"dir,omitempty"`
+}
+
 type MkdirReq struct {
 	NewPath string  `json:"newPath,omitempty"`
 	Parent  *PanObj `json:"parent,omitempty"`
@@ -30,6 +56,11 @@ type MovieReq struct {
 	TargetObj *PanObj   `json:"targetObj,omitempty"`
 }
 
+type CopyReq struct {
+	Items     []*PanObj `json:"items,omitempty"`
+	TargetObj *PanObj   `json:"targetObj,omitempty"`
+}
+
 type ObjRenameReq struct {
 	Obj     *PanObj `json:"obj,omitempty"`
 	NewName string  `json:"newName,omitempty"`
@@ -47,10 +78,23 @@ type PanObj struct {
 	Path string `json:"path"`
 	Size int64  `json:"size"`
 	Type string `json:"type"`
+	// 修改时间和创建时间，网盘没有返回时为零值
+	ModTime    time.Time `json:"modTime"`
+	CreateTime time.Time `json:"createTime"`
+	// 网盘返回的hash，key为HashMd5、HashSha1、HashGcid
+	Hashes   map[string]string `json:"hashes,omitempty"`
+	MimeType string            `json:"mimeType,omitempty"`
 	// 额外的数据
 	Ext    Json    `json:"ext"`
 	Parent *PanObj `json:"parent"`
 }
+
+const (
+	HashMd5  = "md5"
+	HashSha1 = "sha1"
+	HashGcid = "gcid"
+)
+
 type RemoteTransfer func(remote string) string
 
 type UploadFileReq struct {
@@ -61,6 +105,32 @@ type UploadFileReq struct {
 	SuccessDel         bool   `json:"successDel,omitempty"`
 	RemotePathTransfer RemoteTransfer
 	RemoteNameTransfer RemoteTransfer
+	// RateLimit 单个文件的上传限速，单位字节/秒，0为不限速，同时受全局和实例的限速
+	RateLimit int64 `json:"rateLimit,omitempty"`
+	// Progress 该文件的进度监听，同时也会通知全局的监听器
+	Progress ProgressListener `json:"-"`
+}
+
+// UploadStreamReq 直接上传流，Size必须与流的实际长度一致
+type UploadStreamReq struct {
+	Reader     io.Reader `json:"-"`
+	Size       int64     `json:"size,omitempty"`
+	Name       string    `json:"name,omitempty"`
+	RemotePath string    `json:"remotePath,omitempty"`
+	// 预先计算好的hash，key为HashMd5、HashSha1、HashGcid，缺少且驱动需要时会自动计算
+	Hashes             map[string]string `json:"hashes,omitempty"`
+	ModTime            time.Time         `json:"modTime"`
+	OnlyFast           bool              `json:"onlyFast,omitempty"`
+	Resumable          bool              `json:"resumable,omitempty"`
+	RemotePathTransfer RemoteTransfer
+	RemoteNameTransfer RemoteTransfer
+	// RateLimit 同UploadFileReq.RateLimit
+	RateLimit int64 `json:"rateLimit,omitempty"`
+	// Progress 同UploadFileReq.Progress
+	Progress ProgressListener `json:"-"`
+	// 上传本地文件时的路径，用于进度事件
+	localFile string
+	progress  *internal.ProgressTracker
 }
 
 type UploadPathReq struct {
@@ -76,6 +146,10 @@ type UploadPathReq struct {
 	IgnoreExtensions   []string `json:"ignoreExtensions,omitempty"`
 	RemotePathTransfer RemoteTransfer
 	RemoteNameTransfer RemoteTransfer
+	// RateLimit 目录中每个文件分别按该值限速
+	RateLimit int64 `json:"rateLimit,omitempty"`
+	// Progress 目录中每个文件的进度都会通知
+	Progress ProgressListener `json:"-"`
 }
 type DownloadCallback func(localPath, localFile string)
 
@@ -94,15 +168,38 @@ type DownloadPathReq struct {
 	IgnoreExtensions   []string `json:"ignoreExtensions,omitempty"`
 	RemoteNameTransfer RemoteTransfer
 	DownloadCallback
+	// RateLimit 目录中每个文件分别按该值限速
+	RateLimit int64 `json:"rateLimit,omitempty"`
+	// Progress 目录中每个文件的进度都会通知
+	Progress ProgressListener `json:"-"`
+	// VerifyHash 同DownloadFileReq.VerifyHash
+	VerifyHash bool `json:"verifyHash,omitempty"`
 }
 
 type DownloadFileReq struct {
-	RemoteFile       *PanObj `json:"remoteFile,omitempty"`
-	LocalPath        string  `json:"localPath,omitempty"`
-	Concurrency      int     `json:"concurrency,omitempty"`
-	ChunkSize        int64   `json:"chunkSize,omitempty"`
-	OverCover        bool    `json:"overCover,omitempty"`
+	RemoteFile  *PanObj `json:"remoteFile,omitempty"`
+	LocalPath   string  `json:"localPath,omitempty"`
+	Concurrency int     `json:"concurrency,omitempty"`
+	ChunkSize   int64   `json:"chunkSize,omitempty"`
+	OverCover   bool    `json:"overCover,omitempty"`
+	// SkipByHash 本地文件已存在时按hash而不是大小判断是否已经下载，网盘没有返回hash时仍按大小判断
+	SkipByHash bool `json:"skipByHash,omitempty"`
+	// VerifyHash 下载完成后按hash校验，server.download_verify_hash为true时总是校验
+	VerifyHash       bool `json:"verifyHash,omitempty"`
 	DownloadCallback `json:"downloadCallback,omitempty"`
+	// RateLimit 单个文件的下载限速，单位字节/秒，0为不限速，同时受全局和实例的限速
+	RateLimit int64 `json:"rateLimit,omitempty"`
+	// Progress 该文件的进度监听，同时也会通知全局的监听器
+	Progress ProgressListener `json:"-"`
+}
+
+// OpenReq 以流的方式读取远程文件，Length小于等于0时读到文件结尾
+type OpenReq struct {
+	RemoteFile *PanObj `json:"remoteFile,omitempty"`
+	Offset     int64   `json:"offset,omitempty"`
+	Length     int64   `json:"length,omitempty"`
+	// RateLimit 同DownloadFileReq.RateLimit
+	RateLimit int64 `json:"rateLimit,omitempty"`
 }
 
 type OfflineDownloadReq struct {
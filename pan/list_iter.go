@@ -0,0 +1,83 @@
+package pan
+
+import "context"
+
+type ListPageFunc func(ctx context.Context, req ListPageReq) (*ListPageResp, error)
+
+// ListIter 按页遍历目录，只在当前页读完后才请求下一页，调用方可以随时停止
+//
+//	iter := pan.NewListIter(ctx, client.ListPage, pan.ListPageReq{Dir: dir, PageSize: 100})
+//	for iter.Next() {
+//		obj := iter.Obj()
+//	}
+//	if err := iter.Err(); err != nil {
+//	}
+type ListIter struct {
+	ctx      context.Context
+	listPage ListPageFunc
+	req      ListPageReq
+	objs     []*PanObj
+	index    int
+	done     bool
+	err      error
+}
+
+func NewListIter(ctx context.Context, listPage ListPageFunc, req ListPageReq) *ListIter {
+	return &ListIter{
+		ctx:      ctx,
+		listPage: listPage,
+		req:      req,
+		index:    -1,
+	}
+}
+
+// Next 移动到下一个对象，没有更多对象或出错时返回false
+func (it *ListIter) Next() bool {
+	if it.err != nil {
+		return false
+	}
+	it.index++
+	for it.index >= len(it.objs) {
+		if it.done {
+			return false
+		}
+		if err := it.ctx.Err(); err != nil {
+			it.err = err
+			return false
+		}
+		resp, err := it.listPage(it.ctx, it.req)
+		if err != nil {
+			it.err = err
+			return false
+		}
+		it.objs = resp.Objs
+		it.index = 0
+		it.req.PageToken = resp.NextPageToken
+		if resp.NextPageToken == "" {
+			it.done = true
+		}
+	}
+	return true
+}
+
+// Obj 当前对象，需在Next返回true后调用
+func (it *ListIter) Obj() *PanObj {
+	if it.index < 0 || it.index >= len(it.objs) {
+		return nil
+	}
+	return it.objs[it.index]
+}
+
+// Page 当前页的全部对象
+func (it *ListIter) Page() []*PanObj {
+	return it.objs
+}
+
+// NextPageToken 下一页的token，可保存下来稍后通过ListPageReq继续
+func (it *ListIter) NextPageToken() string {
+	return it.req.PageToken
+}
+
+func (it *ListIter) Err() error {
+	return it.err
+}
@@ -1,40 +1,324 @@
 package pan
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"github.com/hefeiyu2025/pan-client/internal"
+	"sort"
+	"sync"
 )
 
-var driverConstructorMap = map[DriverType]DriverConstructor{}
-var idMap = map[string]Driver{}
-var defaultDriverMap = map[DriverType]string{}
+// Instance 已经初始化的驱动实例
+type Instance struct {
+	Id         string     `json:"id"`
+	DriverType DriverType `json:"driverType"`
+	Driver     Driver     `json:"-"`
+}
 
-func RegisterDriver(driverType DriverType, driver DriverConstructor) {
-	driverConstructorMap[driverType] = driver
+// Registry 驱动的注册中心，并发安全，同一个账号并发获取时只会初始化一次
+// 每个Registry有自己的运行环境，其创建的实例共享该环境
+type Registry struct {
+	mu sync.Mutex
+	// 只对该注册中心生效的驱动，优先于全局注册的驱动
+	constructors map[DriverType]DriverConstructor
+	instances    map[string]*Instance
+	defaults     map[DriverType]string
+	calls        map[string]*initCall
+	base         *internal.Env
+	env          *Env
+	envOnce      sync.Once
 }
-func GetDriver(id string, driverType DriverType, read ConfigRW, write ConfigRW) (Driver, error) {
+
+// initCall 正在进行的初始化，其他协程等待其结果
+type initCall struct {
+	wg     sync.WaitGroup
+	driver Driver
+	err    error
+}
+
+// NewRegistry env为空时使用旧用法的全局环境，第一次创建实例时才初始化
+func NewRegistry(env *internal.Env) *Registry {
+	return &Registry{
+		constructors: make(map[DriverType]DriverConstructor),
+		instances:    make(map[string]*Instance),
+		defaults:     make(map[DriverType]string),
+		calls:        make(map[string]*initCall),
+		base:         env,
+	}
+}
+
+var constructorsMu sync.RWMutex
+var constructors = make(map[DriverType]DriverConstructor)
+
+var defaultRegistry = NewRegistry(nil)
+
+// DefaultRegistry GetDriver等包级方法使用的注册中心
+func DefaultRegistry() *Registry {
+	return defaultRegistry
+}
+
+// Env 该注册中心的运行环境
+func (r *Registry) Env() *Env {
+	r.envOnce.Do(func() {
+		base := r.base
+		if base == nil {
+			base = internal.DefaultEnv()
+		}
+		r.env = &Env{Env: base, Registry: r}
+	})
+	return r.env
+}
+
+// RegisterDriver 只对该注册中心注册驱动
+func (r *Registry) RegisterDriver(driverType DriverType, driver DriverConstructor) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.constructors[driverType] = driver
+}
+
+// constructor 需持有r.mu
+func (r *Registry) constructor(driverType DriverType) DriverConstructor {
+	if constructor, ok := r.constructors[driverType]; ok {
+		return constructor
+	}
+	constructorsMu.RLock()
+	defer constructorsMu.RUnlock()
+	return constructors[driverType]
+}
+
+// GetDriver 获取驱动实例，不存在时初始化，id为空时使用该类型默认的账号
+func (r *Registry) GetDriver(id string, driverType DriverType, read ConfigRW, write ConfigRW) (Driver, error) {
+	if err := r.Env().Err(); err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
 	if id == "" {
-		id = defaultDriverMap[driverType]
+		id = r.defaults[driverType]
+	}
+	if instance, ok := r.instances[id]; ok && id != "" {
+		r.mu.Unlock()
+		return instance.Driver, nil
+	}
+	key := string(driverType) + "/" + id
+	if call, ok := r.calls[key]; ok {
+		r.mu.Unlock()
+		call.wg.Wait()
+		return call.driver, call.err
 	}
-	driver, ok := idMap[id]
+	constructor := r.constructor(driverType)
+	if constructor == nil {
+		r.mu.Unlock()
+		return nil, fmt.Errorf("driver %s not exist", driverType)
+	}
+	call := &initCall{}
+	call.wg.Add(1)
+	r.calls[key] = call
+	r.mu.Unlock()
+
+	// 初始化会请求网盘，不能持有锁
+	d := constructor(r.Env())
+	driverId, err := d.InitByCustom(id, read, write)
+
+	var discarded Driver
+	r.mu.Lock()
+	delete(r.calls, key)
+	if err == nil {
+		if instance, ok := r.instances[driverId]; ok {
+			// 默认账号初始化后发现与已有的实例是同一个账号
+			discarded, d = d, instance.Driver
+		} else {
+			r.instances[driverId] = &Instance{Id: driverId, DriverType: driverType, Driver: d}
+		}
+		if id == "" {
+			r.defaults[driverType] = driverId
+		}
+	} else {
+		d = nil
+	}
+	r.mu.Unlock()
+	if discarded != nil {
+		r.drop(discarded)
+	}
+
+	call.driver, call.err = d, err
+	call.wg.Done()
+	return d, err
+}
+
+// discarder 由CommonOperate实现，标记后Drop不会影响同id的已有实例
+type discarder interface {
+	discard()
+}
+
+// drop 释放初始化后被丢弃的实例，Drop会调用Unregister，不能持有锁
+func (r *Registry) drop(d Driver) {
+	if dd, ok := d.(discarder); ok {
+		dd.discard()
+	}
+	if err := d.Drop(); err != nil && !errors.Is(err, ErrNotSupported) {
+		r.Env().Logger.Warnf("drop discarded driver %s error: %v", d.GetId(), err)
+	}
+}
+
+// Lookup 按id获取已经初始化的实例
+func (r *Registry) Lookup(id string) (Driver, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	instance, ok := r.instances[id]
 	if !ok {
-		tempDriver := driverConstructorMap[driverType]
-		if tempDriver == nil {
-			return nil, fmt.Errorf("driver %s not exist", driverType)
+		return nil, false
+	}
+	return instance.Driver, true
+}
+
+// DriverTypes 已注册的驱动类型
+func (r *Registry) DriverTypes() []DriverType {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	types := make([]DriverType, 0, len(r.constructors))
+	for driverType := range r.constructors {
+		types = append(types, driverType)
+	}
+	constructorsMu.RLock()
+	for driverType := range constructors {
+		if _, ok := r.constructors[driverType]; !ok {
+			types = append(types, driverType)
 		}
-		d := tempDriver()
-		driverId, err := d.InitByCustom(id, read, write)
-		if err != nil {
-			return nil, err
+	}
+	constructorsMu.RUnlock()
+	sort.Slice(types, func(i, j int) bool {
+		return types[i] < types[j]
+	})
+	return types
+}
+
+// Instances 已经初始化的实例，按id排序
+func (r *Registry) Instances() []Instance {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	instances := make([]Instance, 0, len(r.instances))
+	for _, instance := range r.instances {
+		instances = append(instances, *instance)
+	}
+	sort.Slice(instances, func(i, j int) bool {
+		return instances[i].Id < instances[j].Id
+	})
+	return instances
+}
+
+type RemoveOptions struct {
+	// 同时删除配置文件中该账号的配置
+	DeleteConfig bool
+}
+
+// unregister 只从注册中心移除，不释放资源
+func (r *Registry) unregister(id string) (*Instance, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	instance, ok := r.instances[id]
+	if ok {
+		delete(r.instances, id)
+		if r.defaults[instance.DriverType] == id {
+			delete(r.defaults, instance.DriverType)
 		}
-		idMap[driverId] = d
-		if id == "" {
-			defaultDriverMap[driverType] = driverId
+	}
+	return instance, ok
+}
+
+// Unregister 从注册中心移除实例但不调用Drop，供驱动的Drop使用
+func (r *Registry) Unregister(id string) {
+	r.unregister(id)
+}
+
+// Remove 移除实例并调用其Drop释放资源，驱动不支持Drop时忽略
+func (r *Registry) Remove(id string, opts RemoveOptions) error {
+	instance, ok := r.unregister(id)
+	if !ok {
+		return nil
+	}
+	if err := instance.Driver.Drop(); err != nil && !errors.Is(err, ErrNotSupported) {
+		return err
+	}
+	if opts.DeleteConfig {
+		return instance.Driver.DeleteConfig()
+	}
+	return nil
+}
+
+func (r *Registry) RemoveDriver(id string) error {
+	return r.Remove(id, RemoveOptions{})
+}
+
+// ClearCache 清除某个账号的所有缓存，账号不需要已经初始化
+func (r *Registry) ClearCache(driverType DriverType, id string) int {
+	return r.Env().Cache.DeletePrefix(CachePrefix(driverType, id))
+}
+
+// WatchConfig 监听配置存储的变化，变化时逐个重新加载已经初始化的实例，返回的stop用于停止监听
+func (r *Registry) WatchConfig() (func(), error) {
+	env := r.Env()
+	watcher, ok := env.Store.(internal.ConfigWatcher)
+	if !ok {
+		return nil, NotSupported("watch config store")
+	}
+	return watcher.Watch(r.Reload)
+}
+
+// Reload 重新加载所有已经初始化的实例，某个实例失败不影响其他实例
+func (r *Registry) Reload() {
+	for _, instance := range r.Instances() {
+		if err := instance.Driver.Reload(context.Background()); err != nil {
+			r.Env().Logger.WithError(err).Errorf("reload %s %s fail", instance.DriverType, instance.Id)
 		}
-		driver = d
 	}
-	return driver, nil
 }
 
-func RemoveDriver(id string) {
-	delete(idMap, id)
+// RegisterDriver 驱动包init时调用，对所有注册中心生效
+func RegisterDriver(driverType DriverType, driver DriverConstructor) {
+	constructorsMu.Lock()
+	defer constructorsMu.Unlock()
+	constructors[driverType] = driver
+}
+
+func GetDriver(id string, driverType DriverType, read ConfigRW, write ConfigRW) (Driver, error) {
+	return defaultRegistry.GetDriver(id, driverType, read, write)
+}
+
+func LookupDriver(id string) (Driver, bool) {
+	return defaultRegistry.Lookup(id)
+}
+
+func DriverTypes() []DriverType {
+	return defaultRegistry.DriverTypes()
+}
+
+func Instances() []Instance {
+	return defaultRegistry.Instances()
+}
+
+func RemoveDriver(id string) error {
+	return defaultRegistry.RemoveDriver(id)
+}
+
+func RemoveDriverWithOptions(id string, opts RemoveOptions) error {
+	return defaultRegistry.Remove(id, opts)
+}
+
+func WatchConfig() (func(), error) {
+	return defaultRegistry.WatchConfig()
+}
+
+// SetRateLimit 修改默认环境的上传下载限速，单位字节/秒，0为不限速
+func SetRateLimit(download, upload int64) {
+	defaultRegistry.Env().SetRateLimit(download, upload)
+}
+
+func UnregisterDriver(id string) {
+	defaultRegistry.Unregister(id)
+}
+
+// ClearCache 清除默认注册中心中某个账号的所有缓存
+func ClearCache(driverType DriverType, id string) int {
+	return defaultRegistry.ClearCache(driverType, id)
 }
@@ -0,0 +1,76 @@
+package pan
+
+import (
+	"context"
+	"github.com/hefeiyu2025/pan-client/internal"
+	"io"
+	"strings"
+)
+
+// ProgressListener 上传下载的进度监听，可以设置在请求上，也可以通过AddProgressListener全局添加
+type ProgressListener = internal.ProgressListener
+type ProgressFunc = internal.ProgressFunc
+type ProgressEvent = internal.ProgressEvent
+type ProgressEventType = internal.ProgressEventType
+type TransferDirection = internal.TransferDirection
+
+// ProgressTracker 一次传输的进度，nil时不做任何事
+type ProgressTracker = internal.ProgressTracker
+
+const (
+	ProgressStart    = internal.ProgressStart
+	ProgressBytes    = internal.ProgressBytes
+	ProgressChunk    = internal.ProgressChunk
+	ProgressRetry    = internal.ProgressRetry
+	ProgressFinished = internal.ProgressFinished
+	ProgressFailed   = internal.ProgressFailed
+
+	DirectionUpload   = internal.DirectionUpload
+	DirectionDownload = internal.DirectionDownload
+)
+
+// AddProgressListener 该注册中心所有实例的上传下载都会通知listener，返回的remove用于移除
+func (r *Registry) AddProgressListener(listener ProgressListener) (remove func()) {
+	return r.Env().Progress.Add(listener)
+}
+
+func AddProgressListener(listener ProgressListener) (remove func()) {
+	return defaultRegistry.AddProgressListener(listener)
+}
+
+// newProgress 同时通知全局和请求上的监听器
+func (b *BaseOperate) newProgress(direction TransferDirection, file, remote string, total int64, listener ProgressListener) *ProgressTracker {
+	return internal.NewProgressTracker(direction, file, remote, total, envOf(b.Env).Progress, listener)
+}
+
+// BaseUploadStream 在驱动的上传前后上报开始和结束，传输的字节由UploadReader上报
+func (b *BaseOperate) BaseUploadStream(ctx context.Context, req UploadStreamReq, uploadStream func(ctx context.Context, req UploadStreamReq) error) error {
+	file := req.localFile
+	if file == "" {
+		file = req.Name
+	}
+	remotePath, remoteName := strings.TrimRight(req.RemotePath, "/"), req.Name
+	if req.RemotePathTransfer != nil {
+		remotePath = req.RemotePathTransfer(remotePath)
+	}
+	if req.RemoteNameTransfer != nil {
+		remoteName = req.RemoteNameTransfer(remoteName)
+	}
+	remote := remotePath + "/" + remoteName
+	req.progress = b.newProgress(DirectionUpload, file, remote, req.Size, req.Progress)
+	req.progress.Start()
+	err := uploadStream(ctx, req)
+	req.progress.Done(err)
+	return err
+}
+
+// ProgressTracker BaseUploadStream创建的进度，驱动分片上传时设置到ProgressReader上报分片完成
+func (r UploadStreamReq) ProgressTracker() *ProgressTracker {
+	return r.progress
+}
+
+// UploadReader 驱动实际上传时读取的流，按全局、实例和请求的限速读取并上报进度，req.Reader可以Seek时返回的流也可以Seek
+func (b *BaseOperate) UploadReader(ctx context.Context, req UploadStreamReq) io.Reader {
+	_, uploadLimiter := b.rateLimiters()
+	return internal.TransferReader(ctx, req.Reader, req.progress, envOf(b.Env).UploadLimiter, uploadLimiter, internal.TransferLimiter(req.RateLimit))
+}
@@ -0,0 +1,150 @@
+package pan
+
+import (
+	"context"
+	"errors"
+	"github.com/hefeiyu2025/pan-client/internal"
+	"path"
+	"strings"
+)
+
+// SearchType 搜索的类型，分类按文件的MimeType或后缀判断
+type SearchType string
+
+const (
+	SearchAll   SearchType = ""
+	SearchFile  SearchType = "file"
+	SearchDir   SearchType = "dir"
+	SearchImage SearchType = "image"
+	SearchVideo SearchType = "video"
+	SearchAudio SearchType = "audio"
+	SearchDoc   SearchType = "doc"
+)
+
+var docExtensions = map[string]bool{
+	".txt": true, ".md": true, ".pdf": true, ".epub": true, ".mobi": true,
+	".doc": true, ".docx": true, ".xls": true, ".xlsx": true, ".csv": true,
+	".ppt": true, ".pptx": true, ".odt": true, ".ods": true, ".odp": true,
+}
+
+// IsCategory 是否按分类搜索
+func (t SearchType) IsCategory() bool {
+	return t == SearchImage || t == SearchVideo || t == SearchAudio || t == SearchDoc
+}
+
+// Match 判断搜索结果是否满足类型和后缀的要求，网盘原生接口过滤不了的部分在这里补上
+func (r *SearchReq) Match(obj *PanObj) bool {
+	switch r.Type {
+	case SearchDir:
+		return obj.Type == "dir"
+	case SearchAll:
+	default:
+		if obj.Type == "dir" {
+			return false
+		}
+	}
+	if len(r.Extensions) > 0 {
+		matched := false
+		for _, extension := range r.Extensions {
+			if strings.HasSuffix(obj.Name, extension) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	if !r.Type.IsCategory() {
+		return true
+	}
+	if r.Type == SearchDoc {
+		return docExtensions[strings.ToLower(path.Ext(obj.Name))]
+	}
+	mimeType := obj.MimeType
+	if mimeType == "" {
+		mimeType = internal.GetMimeType(obj.Name)
+	}
+	return strings.HasPrefix(mimeType, string(r.Type)+"/")
+}
+
+// ScopePath 搜索范围的完整路径，Dir为空时为根目录
+func (r *SearchReq) ScopePath() string {
+	if r.Dir == nil {
+		return "/"
+	}
+	return ObjPath(r.Dir)
+}
+
+// InScope 按路径判断对象是否在搜索范围内
+func (r *SearchReq) InScope(obj *PanObj) bool {
+	scope := r.ScopePath()
+	if scope == "/" {
+		return true
+	}
+	objPath := ObjPath(obj)
+	return objPath != scope && strings.HasPrefix(objPath, scope+"/")
+}
+
+// DirParent 返回目录的名称和父目录id，根目录下的目录返回根目录的id
+type DirParent func(ctx context.Context, id string) (name string, parentId string, err error)
+
+// 防止目录关系异常时无限向上查找
+const maxAncestorDepth = 256
+
+// AncestorIndex 用于搜索接口只返回父目录id时，限定范围并补全路径
+// 只沿搜索结果的父目录向上查找到范围或根目录为止，不遍历整个范围，查过的目录会记住
+type AncestorIndex struct {
+	rootId  string
+	parent  DirParent
+	dirs    map[string]*PanObj
+	outside map[string]bool
+}
+
+// NewAncestorIndex scope为搜索范围，需要有Id和完整的路径
+func NewAncestorIndex(scope *PanObj, rootId string, parent DirParent) *AncestorIndex {
+	return &AncestorIndex{
+		rootId:  rootId,
+		parent:  parent,
+		dirs:    map[string]*PanObj{scope.Id: scope},
+		outside: make(map[string]bool),
+	}
+}
+
+// Dir 返回范围内id对应的目录(带完整路径)，不在范围内时返回nil
+func (a *AncestorIndex) Dir(ctx context.Context, id string) (*PanObj, error) {
+	type node struct {
+		id, name string
+	}
+	chain := make([]node, 0)
+	current := id
+	var base *PanObj
+	for {
+		if dir, ok := a.dirs[current]; ok {
+			base = dir
+			break
+		}
+		if current == "" || current == a.rootId || a.outside[current] || len(chain) > maxAncestorDepth {
+			for _, n := range chain {
+				a.outside[n.id] = true
+			}
+			return nil, nil
+		}
+		name, parentId, err := a.parent(ctx, current)
+		if err != nil {
+			if errors.Is(err, ErrNotFound) {
+				a.outside[current] = true
+				continue
+			}
+			return nil, err
+		}
+		chain = append(chain, node{id: current, name: name})
+		current = parentId
+	}
+	for i := len(chain) - 1; i >= 0; i-- {
+		dir := &PanObj{Id: chain[i].id, Name: chain[i].name, Path: ObjPath(base), Type: "dir", Parent: base}
+		a.dirs[dir.Id] = dir
+		base = dir
+	}
+	return base, nil
+}
@@ -0,0 +1,89 @@
+package pan
+
+import (
+	"github.com/hefeiyu2025/pan-client/internal"
+	"sort"
+)
+
+// Cipher 加密配置中带secret:"true"标签的凭证字段
+type Cipher = internal.Cipher
+
+// NewCipher key为base64编码的32字节密钥时直接使用，否则使用其sha256作为密钥
+func NewCipher(key string) (*Cipher, error) {
+	return internal.NewCipher(key)
+}
+
+type secretFielder interface {
+	SecretFields() []string
+}
+
+// Rekey 用newKey重新加密store中所有已注册驱动的账号凭证
+// oldKey为空表示原来是明文，newKey为空表示解密为明文
+// 只处理driver.<type>下的账号，使用DirStore且每个账号单独一个文件时需要逐个账号读写
+func Rekey(store ConfigStore, oldKey, newKey *Cipher) error {
+	transform := func(value string) (string, error) {
+		plain, err := oldKey.Decrypt(value)
+		if err != nil {
+			return "", err
+		}
+		return newKey.Encrypt(plain)
+	}
+	constructorsMu.RLock()
+	types := make([]DriverType, 0, len(constructors))
+	for driverType := range constructors {
+		types = append(types, driverType)
+	}
+	constructorsMu.RUnlock()
+	sort.Slice(types, func(i, j int) bool {
+		return types[i] < types[j]
+	})
+	for _, driverType := range types {
+		constructorsMu.RLock()
+		constructor := constructors[driverType]
+		constructorsMu.RUnlock()
+		driver, ok := constructor(nil).(secretFielder)
+		if !ok {
+			continue
+		}
+		fields := driver.SecretFields()
+		key := driverConfigKey(driverType)
+		raw, err := loadRaw(store, key)
+		if err != nil {
+			return err
+		}
+		var rekeyed interface{}
+		switch v := raw.(type) {
+		case []interface{}:
+			list := make([]interface{}, 0, len(v))
+			for _, item := range v {
+				if account, ok := item.(map[string]interface{}); ok {
+					if item, err = internal.TransformSecrets(account, fields, transform); err != nil {
+						return MsgError(string(driverType)+" rekey", err)
+					}
+				}
+				list = append(list, item)
+			}
+			rekeyed = list
+		case map[string]interface{}:
+			if !isAccountMap(v) {
+				if rekeyed, err = internal.TransformSecrets(v, fields, transform); err != nil {
+					return MsgError(string(driverType)+" rekey", err)
+				}
+				break
+			}
+			accounts := make(map[string]interface{}, len(v))
+			for id, item := range v {
+				if accounts[id], err = internal.TransformSecrets(item.(map[string]interface{}), fields, transform); err != nil {
+					return MsgError(string(driverType)+" rekey", err)
+				}
+			}
+			rekeyed = accounts
+		default:
+			continue
+		}
+		if err = store.Save(key, rekeyed); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+package pan
+
+import (
+	"github.com/hefeiyu2025/pan-client/internal"
+)
+
+// ConfigStore 驱动配置的存储，key为ViperDriverPrefix+驱动类型
+type ConfigStore = internal.ConfigStore
+
+type FileStore = internal.FileStore
+type MemoryStore = internal.MemoryStore
+type EnvStore = internal.EnvStore
+type DirStore = internal.DirStore
+
+// NewFileStore 单个配置文件，格式由后缀决定，写入时先写临时文件再重命名
+func NewFileStore(filename string) (*FileStore, error) {
+	return internal.NewFileStore(filename)
+}
+
+// NewMemoryStore 只保存在内存中，settings的结构与配置文件相同
+func NewMemoryStore(settings map[string]interface{}) *MemoryStore {
+	return internal.NewMemoryStore(settings)
+}
+
+// NewEnvStore 从环境变量读取，如PAN_DRIVER_QUARK_PUS，保存的配置只在内存中
+func NewEnvStore(prefix string) *EnvStore {
+	return internal.NewEnvStore(prefix)
+}
+
+// NewDirStore 每个账号一个文件，如<dir>/driver.quark.yaml
+func NewDirStore(dir, ext string) *DirStore {
+	return internal.NewDirStore(dir, ext)
+}
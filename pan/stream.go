@@ -0,0 +1,126 @@
+package pan
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"encoding/hex"
+	"github.com/hefeiyu2025/pan-client/internal"
+	"hash"
+	"io"
+	"os"
+)
+
+// PrepareStreamHash 补齐上传流缺少的hash
+// 可Seek的流从当前位置读取Size个字节计算后回到原位置，否则先写入实例所在环境的临时目录，req.Reader会被替换为该临时文件
+// 返回的cleanup用于删除临时文件，必须调用；读取的字节数与Size不一致或ctx取消时返回错误
+func (b *BaseOperate) PrepareStreamHash(ctx context.Context, req *UploadStreamReq, hashTypes ...string) (func(), DriverErrorInterface) {
+	return prepareStreamHash(ctx, envOf(b.Env), req, hashTypes...)
+}
+
+// PrepareStreamHash 同BaseOperate.PrepareStreamHash，使用旧用法的全局环境
+func PrepareStreamHash(ctx context.Context, req *UploadStreamReq, hashTypes ...string) (func(), DriverErrorInterface) {
+	return prepareStreamHash(ctx, envOf(nil), req, hashTypes...)
+}
+
+func prepareStreamHash(ctx context.Context, env *Env, req *UploadStreamReq, hashTypes ...string) (func(), DriverErrorInterface) {
+	cleanup := func() {}
+	if req.Hashes == nil {
+		req.Hashes = make(map[string]string)
+	}
+	hashers := make(map[string]hash.Hash)
+	for _, hashType := range hashTypes {
+		if req.Hashes[hashType] != "" {
+			continue
+		}
+		hasher, err := newHasher(hashType, req.Size)
+		if err != nil {
+			return cleanup, err
+		}
+		hashers[hashType] = hasher
+	}
+	if len(hashers) == 0 {
+		return cleanup, nil
+	}
+	writers := make([]io.Writer, 0, len(hashers))
+	for _, hasher := range hashers {
+		writers = append(writers, hasher)
+	}
+	if seeker, ok := req.Reader.(io.ReadSeeker); ok {
+		offset, err := seeker.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return cleanup, OnlyError(err)
+		}
+		buffer := make([]byte, 1024*1024) // 1MB buffer
+		written, err := io.CopyBuffer(io.MultiWriter(writers...), internal.ContextReader(ctx, io.LimitReader(seeker, req.Size)), buffer)
+		// 无论成功与否都回到原位置
+		if _, e := seeker.Seek(offset, io.SeekStart); e != nil && err == nil {
+			err = e
+		}
+		if err != nil {
+			return cleanup, MsgError(req.Name+" hash error", err)
+		}
+		if written != req.Size {
+			return cleanup, OnlyMsg(req.Name + " stream size not match")
+		}
+	} else {
+		file, written, err := internal.SpoolToTemp(internal.ContextReader(ctx, req.Reader), env.Config.Server.UploadTmpPath, writers...)
+		if err != nil {
+			return cleanup, MsgError(req.Name+" spool error", err)
+		}
+		if written != req.Size {
+			_ = file.Close()
+			_ = os.Remove(file.Name())
+			return cleanup, OnlyMsg(req.Name + " stream size not match")
+		}
+		req.Reader = file
+		cleanup = func() {
+			_ = file.Close()
+			if e := os.Remove(file.Name()); e != nil {
+				env.Logger.Errorf("delete spool fail %s,%v", file.Name(), e)
+			}
+		}
+	}
+	for hashType, hasher := range hashers {
+		req.Hashes[hashType] = hex.EncodeToString(hasher.Sum(nil))
+	}
+	return cleanup, nil
+}
+
+// newHasher gcid的分块大小与文件大小有关
+func newHasher(hashType string, size int64) (hash.Hash, DriverErrorInterface) {
+	switch hashType {
+	case HashMd5:
+		return md5.New(), nil
+	case HashSha1:
+		return sha1.New(), nil
+	case HashGcid:
+		return internal.NewGcid(size), nil
+	}
+	return nil, OnlyMsg("not support hash type " + hashType)
+}
+
+type rangeReadCloser struct {
+	io.Reader
+	closer io.Closer
+}
+
+func (r *rangeReadCloser) Close() error {
+	return r.closer.Close()
+}
+
+// newRangeReadCloser 丢弃offset之前的字节，length大于0时只读取length个字节
+func newRangeReadCloser(readCloser io.ReadCloser, offset, length int64) (io.ReadCloser, DriverErrorInterface) {
+	if offset > 0 {
+		_, err := io.CopyN(io.Discard, readCloser, offset)
+		if err != nil {
+			_ = readCloser.Close()
+			return nil, MsgError("skip to offset failed", err)
+		}
+	}
+	var reader io.Reader = readCloser
+	if length > 0 {
+		reader = io.LimitReader(readCloser, length)
+	}
+	return &rangeReadCloser{Reader: reader, closer: readCloser}, nil
+}
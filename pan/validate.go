@@ -0,0 +1,35 @@
+package pan
+
+import (
+	"github.com/hefeiyu2025/pan-client/internal"
+)
+
+// ValidationError 配置校验不通过时的详情，可通过errors.As从驱动返回的异常中取出
+type ValidationError = internal.ValidationError
+type FieldError = internal.FieldError
+
+// ConfigSchema 驱动账号配置的JSON Schema，驱动未注册时返回NotFound
+func (r *Registry) ConfigSchema(driverType DriverType) (map[string]interface{}, error) {
+	r.mu.Lock()
+	constructor := r.constructor(driverType)
+	r.mu.Unlock()
+	if constructor == nil {
+		return nil, NotFound("driver " + string(driverType))
+	}
+	return constructor(r.Env()).ConfigSchema(), nil
+}
+
+// ConfigSchemas 所有已注册驱动的账号配置的JSON Schema，key为驱动类型
+func (r *Registry) ConfigSchemas() map[DriverType]map[string]interface{} {
+	schemas := make(map[DriverType]map[string]interface{})
+	for _, driverType := range r.DriverTypes() {
+		if schema, err := r.ConfigSchema(driverType); err == nil {
+			schemas[driverType] = schema
+		}
+	}
+	return schemas
+}
+
+func ConfigSchema(driverType DriverType) (map[string]interface{}, error) {
+	return defaultRegistry.ConfigSchema(driverType)
+}
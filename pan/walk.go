@@ -0,0 +1,263 @@
+package pan
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"path"
+	"strings"
+	"sync"
+)
+
+// SkipDir 在WalkFunc中返回，目录时不再进入该目录，文件时跳过所在目录剩余的对象
+var SkipDir = fs.SkipDir
+
+// SkipAll 在WalkFunc中返回，直接结束遍历
+var SkipAll = fs.SkipAll
+
+type Lister interface {
+	List(ctx context.Context, req ListReq) ([]*PanObj, error)
+}
+
+// ListFunc 让普通的List方法也能作为Lister使用
+type ListFunc func(ctx context.Context, req ListReq) ([]*PanObj, error)
+
+func (f ListFunc) List(ctx context.Context, req ListReq) ([]*PanObj, error) {
+	return f(ctx, req)
+}
+
+// WalkFunc 遍历回调，remotePath为对象的完整路径
+// 列目录失败时会以该目录和err回调，此时返回nil或SkipDir可忽略错误继续遍历
+type WalkFunc func(remotePath string, obj *PanObj, err error) error
+
+type WalkOptions struct {
+	Filter
+	// 最大深度，root的子对象深度为1，0表示不限制
+	MaxDepth int
+	// 列目录时是否刷新缓存
+	Reload bool
+	// 目录在其子对象之后回调，此时目录返回SkipDir不起作用，适用于重命名等会改变路径的场景
+	PostOrder bool
+	// 大于1时子目录会并发遍历，WalkFunc可能被并发调用
+	Concurrency int
+}
+
+// Filter 上传、下载、遍历共用的名称过滤规则
+type Filter struct {
+	// 需要忽略的目录名称
+	IgnorePaths []string `json:"ignorePaths,omitempty"`
+	// 需要忽略的文件名称
+	IgnoreFiles []string `json:"ignoreFiles,omitempty"`
+	// 非空时只处理这些后缀的文件
+	Extensions []string `json:"extensions,omitempty"`
+	// 需要忽略的文件后缀
+	IgnoreExtensions []string `json:"ignoreExtensions,omitempty"`
+	// glob规则(path.Match)，非空时只处理名称匹配的文件，不影响目录
+	Include []string `json:"include,omitempty"`
+	// glob规则(path.Match)，名称匹配的文件和目录都会被忽略
+	Exclude []string `json:"exclude,omitempty"`
+}
+
+// DirAllowed 目录是否需要处理
+func (f *Filter) DirAllowed(name string) bool {
+	for _, ignorePath := range f.IgnorePaths {
+		if name == ignorePath {
+			return false
+		}
+	}
+	return !matchAny(f.Exclude, name)
+}
+
+// FileAllowed 文件是否需要处理
+func (f *Filter) FileAllowed(name string) bool {
+	if len(f.Extensions) > 0 {
+		matched := false
+		for _, extension := range f.Extensions {
+			if strings.HasSuffix(name, extension) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	for _, ignoreFile := range f.IgnoreFiles {
+		if name == ignoreFile {
+			return false
+		}
+	}
+	for _, extension := range f.IgnoreExtensions {
+		if strings.HasSuffix(name, extension) {
+			return false
+		}
+	}
+	if len(f.Include) > 0 && !matchAny(f.Include, name) {
+		return false
+	}
+	return !matchAny(f.Exclude, name)
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// ObjPath 对象的完整路径
+func ObjPath(obj *PanObj) string {
+	return "/" + strings.Trim(strings.Trim(obj.Path, "/")+"/"+obj.Name, "/")
+}
+
+// Walk 遍历root下的所有对象(root本身不回调)，目录默认先于子对象回调
+func Walk(ctx context.Context, lister Lister, root *PanObj, fn WalkFunc, opts WalkOptions) error {
+	if root.Type == "file" {
+		return OnlyMsg("walk root must be a dir")
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	w := &walker{
+		lister: lister,
+		fn:     fn,
+		opts:   opts,
+		cancel: cancel,
+	}
+	if opts.Concurrency > 1 {
+		w.sem = make(chan struct{}, opts.Concurrency-1)
+	}
+	err := w.walk(ctx, ObjPath(root), root, 1)
+	if err == nil {
+		err = w.firstErr()
+	}
+	if errors.Is(err, SkipAll) || errors.Is(err, SkipDir) {
+		return nil
+	}
+	return err
+}
+
+type walker struct {
+	lister Lister
+	fn     WalkFunc
+	opts   WalkOptions
+	sem    chan struct{}
+	cancel context.CancelFunc
+	mu     sync.Mutex
+	err    error
+}
+
+func (w *walker) setErr(err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err == nil {
+		w.err = err
+		w.cancel()
+	}
+}
+
+// fail 并发时记录错误并取消其他协程
+func (w *walker) fail(err error) error {
+	if w.sem != nil {
+		w.setErr(err)
+	}
+	return err
+}
+
+func (w *walker) firstErr() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.err
+}
+
+func (w *walker) call(remotePath string, obj *PanObj, err error) error {
+	if w.sem == nil {
+		return w.fn(remotePath, obj, err)
+	}
+	// 并发时回调也可能并发，出错后不再回调
+	if e := w.firstErr(); e != nil {
+		return e
+	}
+	return w.fn(remotePath, obj, err)
+}
+
+func (w *walker) walk(ctx context.Context, dirPath string, dir *PanObj, depth int) error {
+	objs, err := w.lister.List(ctx, ListReq{
+		Reload: w.opts.Reload,
+		Dir:    dir,
+	})
+	if err != nil {
+		if e := w.call(dirPath, dir, err); e != nil && !errors.Is(e, SkipDir) {
+			return w.fail(e)
+		}
+		return nil
+	}
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for _, obj := range objs {
+		if ctx.Err() != nil {
+			if e := w.firstErr(); e != nil {
+				return e
+			}
+			return ctx.Err()
+		}
+		objPath := strings.TrimRight(dirPath, "/") + "/" + obj.Name
+		if obj.Type != "dir" {
+			if !w.opts.FileAllowed(obj.Name) {
+				continue
+			}
+			if e := w.call(objPath, obj, nil); e != nil {
+				if errors.Is(e, SkipDir) {
+					return nil
+				}
+				return w.fail(e)
+			}
+			continue
+		}
+		if !w.opts.DirAllowed(obj.Name) {
+			continue
+		}
+		if !w.opts.PostOrder {
+			if e := w.call(objPath, obj, nil); e != nil {
+				if errors.Is(e, SkipDir) {
+					continue
+				}
+				return w.fail(e)
+			}
+		}
+		visit := func(obj *PanObj, objPath string) error {
+			if w.opts.MaxDepth <= 0 || depth < w.opts.MaxDepth {
+				if e := w.walk(ctx, objPath, obj, depth+1); e != nil {
+					return e
+				}
+			}
+			if w.opts.PostOrder {
+				if e := w.call(objPath, obj, nil); e != nil && !errors.Is(e, SkipDir) {
+					return e
+				}
+			}
+			return nil
+		}
+		if w.sem != nil {
+			select {
+			case w.sem <- struct{}{}:
+				wg.Add(1)
+				go func(obj *PanObj, objPath string) {
+					defer wg.Done()
+					defer func() { <-w.sem }()
+					if e := visit(obj, objPath); e != nil {
+						w.setErr(e)
+					}
+				}(obj, objPath)
+				continue
+			default:
+				// 没有空闲的并发额度时直接在当前协程处理
+			}
+		}
+		if e := visit(obj, objPath); e != nil {
+			return w.fail(e)
+		}
+	}
+	return nil
+}